@@ -0,0 +1,77 @@
+package byos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+// Host is an existing server reachable over SSH, e.g. a VPS rented from a host without an API integration.
+// Either Password or PrivateKey is required.
+type Host struct {
+	Address string // IP address or host name
+	Port    int    // sshd's port, 22 if 0
+	User    string // root, or a user with passwordless sudo
+	// Password logs in once, to authorize a generated key that is used from then on.
+	Password string
+	// PrivateKey is a PEM encoded key authorized for User. It is stored with the server, like a generated key.
+	PrivateKey string
+	// Location is where the host is, shown in location lists. It may be empty.
+	Location common.GeoLocation
+}
+
+// ID returns the host's placement ID for Provision, its address and SSH port.
+func (h Host) ID() string {
+	return net.JoinHostPort(h.Address, strconv.Itoa(h.port()))
+}
+
+func (h Host) port() int {
+	if h.Port == 0 {
+		return common.SSHPort.From
+	}
+	return h.Port
+}
+
+func (h Host) validate() error {
+	switch {
+	case h.Address == "":
+		return errors.New("host has no address")
+	case h.User == "":
+		return fmt.Errorf("host %s has no user", h.Address)
+	case h.Password == "" && h.PrivateKey == "":
+		return fmt.Errorf("host %s needs a password or a private key", h.Address)
+	case h.Port < 0 || h.Port > 65535:
+		return fmt.Errorf("invalid SSH port %d", h.Port)
+	}
+	return nil
+}
+
+// resolve returns the host's IPv4 address, resolving a host name. Servers are addressed by IP, like
+// provisioned ones, as lantern-server-manager's clients connect to ExternalIp.
+func (h Host) resolve(ctx context.Context) (string, error) {
+	if ip := net.ParseIP(h.Address); ip != nil {
+		return h.Address, nil
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip4", h.Address)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", h.Address, err)
+	}
+	return ips[0].String(), nil
+}
+
+// hostLocation is the only location of a host's compartment entry.
+type hostLocation struct {
+	host Host
+}
+
+func (l hostLocation) GetID() string {
+	return l.host.ID()
+}
+
+func (l hostLocation) GetLocation() *common.GeoLocation {
+	return &l.host.Location
+}
@@ -0,0 +1,82 @@
+package byos
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+// SetInventory replaces the inventory, which defaults to common.DefaultInventory.
+func (p *Provisioner) SetInventory(inventory common.Inventory) {
+	p.inventory = inventory
+}
+
+func (p *Provisioner) Inventory() common.Inventory {
+	return p.inventory
+}
+
+// SetSecretStore replaces the store for SSH private keys, which defaults to common.DefaultSecretStore.
+func (p *Provisioner) SetSecretStore(store common.SecretStore) {
+	p.secrets = store
+}
+
+func (p *Provisioner) SecretStore() common.SecretStore {
+	return p.secrets
+}
+
+// UpgradeServer implements common.Upgrader.
+func (p *Provisioner) UpgradeServer(ctx context.Context, id string) {
+	p.maintainer().UpgradeServer(ctx, id)
+}
+
+// CheckUpdates implements common.UpdateChecker.
+func (p *Provisioner) CheckUpdates(ctx context.Context) {
+	p.maintainer().CheckUpdates(ctx)
+}
+
+// maintainer returns the maintenance operations for the provisioner's servers. Hosts have no cloud firewall.
+func (p *Provisioner) maintainer() *common.Maintainer {
+	return &common.Maintainer{
+		Provider:  providerName,
+		Events:    p.session.Events,
+		Inventory: p.inventory,
+		Secrets:   p.secrets,
+		Progress:  p.progress,
+		Log:       p.log,
+		LoadServer: func(_ context.Context, id string) (*common.InventoryRecord, error) {
+			return p.loadServer(id)
+		},
+	}
+}
+
+// loadServer gets a server from the inventory. There is no provider to reconcile it with.
+func (p *Provisioner) loadServer(id string) (*common.InventoryRecord, error) {
+	if p.inventory == nil {
+		return nil, errors.New("no inventory configured")
+	}
+	return p.inventory.Get(id)
+}
+
+// recordServer assigns the server a local ID, adds it to the inventory and stores its SSH key.
+// Failing to add the server to the inventory doesn't fail provisioning, the server is usable either way.
+// Failing to store the SSH key does: without it the server can't be installed or maintained.
+func (p *Provisioner) recordServer(server *common.Server, sshUser, sshPrivateKey string, status common.ServerStatus, profile *common.InstallProfile) error {
+	record := common.NewInventoryRecord(server, sshUser)
+	record.Status = status
+	record.Profile = profile
+	if p.inventory != nil {
+		if err := p.inventory.Put(record); err != nil {
+			slog.Error("Failed to add server to inventory", "id", server.ID, "err", err)
+		}
+	}
+	return common.StoreSSHKey(p.secrets, server.ID, sshPrivateKey)
+}
+
+// updateServer saves a changed server in the inventory.
+func (p *Provisioner) updateServer(server common.Server, status common.ServerStatus) {
+	if err := common.UpdateServer(p.inventory, server, status); err != nil {
+		slog.Error("Failed to update server in inventory", "id", server.ID, "err", err)
+	}
+}
@@ -0,0 +1,227 @@
+// Package byos installs Lantern on servers the user already has ("bring your own server").
+// There is no OAuth flow and no account to validate: hosts are added with AddHost and installed over SSH
+// by Provision, with the same steps, ServerConfiguration and events as servers of the cloud providers.
+package byos
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+const providerName = "byos"
+
+type Provisioner struct {
+	session   *common.Session
+	mu        sync.Mutex // Guards hosts
+	hosts     []Host
+	inventory common.Inventory
+	secrets   common.SecretStore
+}
+
+// GetProvisioner returns a provisioner for existing hosts. Unlike the cloud providers it doesn't start an
+// OAuth flow, so the session only carries events of the operations that are started. Without an OS keychain,
+// SSH keys are kept in a file encrypted with the passphrase returned by passphrase, see common.DefaultSecretStore.
+func GetProvisioner(passphrase common.PassphraseFunc) *Provisioner {
+	return &Provisioner{
+		// Nothing to cancel without an OAuth flow
+		session:   &common.Session{Events: make(chan common.Event), Cancel: func() {}},
+		inventory: common.DefaultInventory(),
+		secrets:   common.DefaultSecretStore(passphrase),
+	}
+}
+
+// AddHost makes a host available to Provision, replacing a host with the same ID. It returns the ID.
+func (p *Provisioner) AddHost(host Host) (string, error) {
+	if err := host.validate(); err != nil {
+		return "", err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hosts = slices.DeleteFunc(p.hosts, func(h Host) bool { return h.ID() == host.ID() })
+	p.hosts = append(p.hosts, host)
+	return host.ID(), nil
+}
+
+func (p *Provisioner) host(id string) (Host, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i := slices.IndexFunc(p.hosts, func(h Host) bool { return h.ID() == id })
+	if i < 0 {
+		return Host{}, false
+	}
+	return p.hosts[i], true
+}
+
+// Validate has nothing to validate, hosts are checked when they are provisioned. The token is ignored.
+// It completes right away, so the usual flow of validating before provisioning works.
+func (p *Provisioner) Validate(ctx context.Context, token string) {
+	go func() {
+		p.session.Events <- common.Event{Type: common.EventTypeValidationStarted}
+		p.session.Events <- common.Event{Type: common.EventTypeValidationCompleted}
+	}()
+}
+
+// Compartments returns one compartment with an entry per added host.
+func (p *Provisioner) Compartments() []common.Compartment {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var entries []common.CompartmentEntry
+	for _, host := range p.hosts {
+		entries = append(entries, common.CompartmentEntry{
+			ID:        host.ID(),
+			Locations: []common.CloudLocation{hostLocation{host: host}},
+		})
+	}
+	return []common.Compartment{{ID: providerName, Name: "Existing servers", Entries: entries}}
+}
+
+func (p *Provisioner) Session() *common.Session {
+	return p.session
+}
+
+// Provision installs Lantern on the host with the ID placementID, see AddHost. locationID is ignored.
+// With a password, a generated SSH key is authorized on the host first, so the password isn't stored.
+// The host's operating system is given with common.WithOS. Other options about creating servers are ignored.
+func (p *Provisioner) Provision(ctx context.Context, placementID string, locationID string, opts ...common.ProvisionOption) {
+	options := common.NewProvisionOptions(opts...)
+	go func() {
+		p.session.Events <- common.Event{Type: common.EventTypeProvisioningStarted, Message: placementID}
+
+		host, ok := p.host(placementID)
+		if !ok {
+			err := fmt.Errorf("unknown host %s, add it with AddHost: %w", placementID, common.ErrNotFound)
+			p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err}
+			return
+		}
+		recipe, err := common.Recipe(options.OS)
+		if err == nil {
+			err = recipe.CheckSupported(time.Now())
+		}
+		if err != nil {
+			p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err}
+			return
+		}
+		if err := common.CheckSecretStore(p.secrets); err != nil {
+			err = fmt.Errorf("SSH keys can't be stored: %w", err)
+			slog.Error("Secret store is unusable", "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err}
+			return
+		}
+		ip, err := host.resolve(ctx)
+		if err != nil {
+			slog.Error("Failed to resolve host", "host", host.Address, "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err}
+			return
+		}
+
+		privateSSHKey := host.PrivateKey
+		if privateSSHKey == "" {
+			var publicSSHKey string
+			if publicSSHKey, privateSSHKey, err = common.MakeSSHKeyPair(); err != nil {
+				slog.Error("Failed to create SSH key pair", "err", err)
+				p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err}
+				return
+			}
+			p.progress(fmt.Sprintf("Authorizing an SSH key for %s on %s", host.User, host.Address))
+			if err := common.AuthorizeSSHKey(ctx, ip, host.port(), host.User, host.Password, publicSSHKey); err != nil {
+				slog.Error("Failed to authorize SSH key", "host", host.Address, "err", err)
+				p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err}
+				return
+			}
+		}
+
+		server := &common.Server{
+			Provider:     providerName,
+			PlacementID:  host.ID(),
+			LocationID:   host.Location.ID,
+			InstanceID:   host.ID(),
+			InstanceName: host.Address,
+			OS:           recipe.Target,
+			Config:       common.ServerConfiguration{ExternalIp: ip},
+		}
+		if host.port() != common.SSHPort.From {
+			server.Config.SSHPort = host.port()
+		}
+		// Recorded before it is installed, so a failed installation can be resumed
+		if err := p.recordServer(server, host.User, privateSSHKey, common.ServerStatusInstalling, options.Profile); err != nil {
+			slog.Error("Failed to record server", "id", server.ID, "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err, Server: server}
+			return
+		}
+		p.installServer(ctx, server, privateSSHKey, host.User, options.InstallProfile())
+	}()
+}
+
+// ResumeInstall implements common.InstallResumer.
+func (p *Provisioner) ResumeInstall(ctx context.Context, id string) {
+	go func() {
+		p.session.Events <- common.Event{Type: common.EventTypeProvisioningStarted, Message: id}
+		record, err := p.loadServer(id)
+		if err == nil && record.Status != common.ServerStatusInstalling {
+			err = fmt.Errorf("server %s is %s, not installing", id, record.Status)
+		}
+		if err != nil {
+			slog.Error("Failed to load server", "id", id, "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err}
+			return
+		}
+		server := record.Server
+		privateSSHKey, err := common.LoadSSHKey(p.secrets, id)
+		if err != nil {
+			slog.Error("Failed to load SSH key", "id", id, "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err, Server: &server}
+			return
+		}
+		p.installServer(ctx, &server, privateSSHKey, record.SSHUser, record.InstallProfile())
+	}()
+}
+
+// installServer installs a recorded server and verifies it. There is no cloud firewall, the host's
+// own firewall is opened by the installation. The result is reported with EventTypeProvisioningCompleted
+// or EventTypeProvisioningError; the server stays installing in the inventory on failure.
+func (p *Provisioner) installServer(ctx context.Context, server *common.Server, privateSSHKey, sshUser string, profile common.InstallProfile) {
+	fail := func(msg string, err error) {
+		slog.Error(msg, "id", server.ID, "err", err)
+		p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err, Server: server}
+	}
+	port := server.Config.SSHPort
+	if port == 0 {
+		port = common.SSHPort.From
+	}
+	conf, err := common.InstallServerOnPort(ctx, server.Config.ExternalIp, port, privateSSHKey, sshUser, server.OS, profile, p.log)
+	if err != nil {
+		fail("Failed to install server", err)
+		return
+	}
+	// lantern-server-manager may detect a private address behind NAT
+	conf.ExternalIp = server.Config.ExternalIp
+	p.progress("Verifying the server")
+	if err := common.VerifyServer(ctx, *conf, privateSSHKey, sshUser); err != nil {
+		fail("Server verification failed", err)
+		return
+	}
+	server.Config = *conf
+	p.updateServer(*server, common.ServerStatusActive)
+	p.session.Events <- common.Event{
+		Type:    common.EventTypeProvisioningCompleted,
+		Message: conf.Encode(),
+		Server:  server,
+	}
+}
+
+// progress reports a progress message on the session.
+func (p *Provisioner) progress(message string) {
+	slog.Debug("Progress", "message", message)
+	p.session.Events <- common.Event{Type: common.EventTypeProgress, Message: message}
+}
+
+// log reports a line of remote command output on the session.
+func (p *Provisioner) log(line string) {
+	p.session.Events <- common.Event{Type: common.EventTypeLog, Message: line}
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"strings"
 	"time"
 
 	"github.com/pterm/pterm"
@@ -47,17 +49,47 @@ func testProvisioner(ctx context.Context, p common.Provisioner) {
 				selectedAccount, _ := pterm.DefaultInteractiveSelect.WithOptions(common.CompartmentNames(compartments)).Show("Please select an account:")
 				pterm.Info.Printfln("Selected option: %s", pterm.Green(selectedAccount))
 				compartment := common.CompartmentByName(compartments, selectedAccount)
+				if len(compartment.Entries) == 0 {
+					creator, ok := p.(common.CompartmentEntryCreator)
+					if !ok {
+						slog.Error("No projects found for the selected account")
+						return
+					}
+					// the account has no usable project, create one for the user
+					pterm.Info.Println("No projects found, creating a new one")
+					creator.CreateCompartmentEntry(ctx, compartment.ID)
+					continue
+				}
+				if !selectProject(ctx, p, compartment, "") {
+					return
+				}
 
-				selectedProject, _ := pterm.DefaultInteractiveSelect.WithOptions(common.CompartmentEntryIDs(compartment.Entries)).Show("Please select a project:")
-				pterm.Info.Printfln("Selected project: %s", pterm.Green(selectedProject))
-
-				project := common.CompartmentEntryByID(compartment.Entries, selectedProject)
-				selectedLocation, _ := pterm.DefaultInteractiveSelect.WithOptions(common.CompartmentEntryLocations(project)).Show("Please select a location:")
-				pterm.Info.Printfln("Selected location: %s", pterm.Green(selectedLocation))
-
-				// we can now proceed to create resources
-				cloc := common.CompartmentLocationByIdentifier(project.Locations, selectedLocation)
-				p.Provision(ctx, selectedProject, cloc.GetID())
+			case common.EventTypeCompartmentEntryCreated:
+				pterm.Info.Printfln("Created project: %s", pterm.Green(e.Message))
+				for _, c := range p.Compartments() {
+					if common.CompartmentEntryByID(c.Entries, e.Message) != nil {
+						if !selectProject(ctx, p, &c, e.Message) {
+							return
+						}
+						break
+					}
+				}
+			case common.EventTypeCompartmentEntryError:
+				slog.Error("Project creation failed", "err", e.Error)
+				return
+			case common.EventTypeServicesEnabled:
+				pterm.Info.Printfln("Enabled services for project: %s", pterm.Green(e.Message))
+				for _, c := range p.Compartments() {
+					if common.CompartmentEntryByID(c.Entries, e.Message) != nil {
+						if !selectProject(ctx, p, &c, e.Message) {
+							return
+						}
+						break
+					}
+				}
+			case common.EventTypeServicesError:
+				slog.Error("Enabling services failed", "err", e.Error)
+				return
 
 			case common.EventTypeValidationError:
 				slog.Error("Validation failed", "err", e.Error)
@@ -70,6 +102,10 @@ func testProvisioner(ctx context.Context, p common.Provisioner) {
 			case common.EventTypeProvisioningError:
 				slog.Error("Provisioning failed", "err", e.Error)
 				return
+			case common.EventTypeProgress:
+				pterm.Info.Println(e.Message)
+			case common.EventTypeLog:
+				slog.Debug("Remote output", "line", e.Message)
 			}
 			break
 		default:
@@ -78,12 +114,65 @@ func testProvisioner(ctx context.Context, p common.Provisioner) {
 	}
 }
 
+// selectProject asks the user for a project (unless projectID is set) and location, then starts provisioning.
+// It returns false if nothing was started.
+func selectProject(ctx context.Context, p common.Provisioner, compartment *common.Compartment, projectID string) bool {
+	selectedProject := projectID
+	if selectedProject == "" {
+		selectedProject, _ = pterm.DefaultInteractiveSelect.WithOptions(common.CompartmentEntryIDs(compartment.Entries)).Show("Please select a project:")
+		pterm.Info.Printfln("Selected project: %s", pterm.Green(selectedProject))
+	}
+
+	project := common.CompartmentEntryByID(compartment.Entries, selectedProject)
+	if len(project.MissingServices) > 0 {
+		enabler, ok := p.(common.ServiceEnabler)
+		prompt := fmt.Sprintf("The project needs %s, which may incur costs. Enable?", strings.Join(project.MissingServices, ", "))
+		if ok {
+			if enable, _ := pterm.DefaultInteractiveConfirm.Show(prompt); enable {
+				enabler.EnableServices(ctx, project.ID)
+				return true
+			}
+		}
+		slog.Error("The project can't host servers without its missing services", "services", project.MissingServices)
+		return false
+	}
+	selectedLocation, _ := pterm.DefaultInteractiveSelect.WithOptions(common.CompartmentEntryLocations(project)).Show("Please select a location:")
+	pterm.Info.Printfln("Selected location: %s", pterm.Green(selectedLocation))
+
+	// we can now proceed to create resources
+	cloc := common.CompartmentLocationByIdentifier(project.Locations, selectedLocation)
+	var opts []common.ProvisionOption
+	if estimator, ok := p.(common.CostEstimator); ok {
+		onDemand, err1 := estimator.EstimateCost(cloc.GetID())
+		spot, err2 := estimator.EstimateCost(cloc.GetID(), common.WithSpot())
+		if err1 == nil && err2 == nil && spot.Discount() > 0 {
+			prompt := fmt.Sprintf("Use spot capacity for about $%.2f/month instead of $%.2f/month (%.0f%% off, may be preempted)?",
+				spot.Monthly(), onDemand.Monthly(), spot.Discount()*100)
+			if useSpot, _ := pterm.DefaultInteractiveConfirm.Show(prompt); useSpot {
+				opts = append(opts, common.WithSpot())
+			}
+		}
+	}
+	p.Provision(ctx, selectedProject, cloc.GetID(), opts...)
+	return true
+}
+
+// promptPassphrase asks the user for the passphrase protecting the secrets file.
+func promptPassphrase() ([]byte, error) {
+	passphrase, err := pterm.DefaultInteractiveTextInput.WithMask("*").Show("Passphrase for stored SSH keys")
+	if err != nil {
+		return nil, err
+	}
+	return []byte(passphrase), nil
+}
+
 // Example Usage
 func main() {
 	slog.SetLogLoggerLevel(slog.LevelDebug)
 
 	ctx := context.Background()
-	//p := gcp.GetProvisioner(ctx, Start)
-	p := digitalocean.GetProvisioner(ctx, common.OpenBrowserDesktop)
+	//p := gcp.GetProvisioner(ctx, Start, promptPassphrase)
+	// Without an OS keychain, SSH keys are kept in a file encrypted with a passphrase
+	p := digitalocean.GetProvisioner(ctx, common.OpenBrowserDesktop, promptPassphrase)
 	testProvisioner(ctx, p)
 }
@@ -0,0 +1,223 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// AptKeyringDir is where repository signing keys are installed on the server.
+const AptKeyringDir = "/etc/apt/keyrings"
+
+// maxAptKeySize bounds a downloaded signing key; real keys are a few kilobytes.
+const maxAptKeySize = 1 << 20
+
+// ErrAptKeyMismatch is returned when a repository signing key doesn't have the pinned fingerprint.
+var ErrAptKeyMismatch = errors.New("apt repository key doesn't match the pinned fingerprint")
+
+// Signed reports whether packages from the source are verified with a signing key.
+func (s AptSource) Signed() bool {
+	return s.Key != "" || s.KeyURL != ""
+}
+
+// keyringPath returns where the source's signing key is installed on the server.
+func (s AptSource) keyringPath() string {
+	return AptKeyringDir + "/getlantern.gpg"
+}
+
+// keyring returns the source's signing key as a binary OpenPGP keyring, as apt expects in signed-by.
+// A downloaded key must have the pinned KeyFingerprint; an embedded key is checked if one is set.
+func (s AptSource) keyring(ctx context.Context) ([]byte, error) {
+	data := []byte(s.Key)
+	if s.Key == "" {
+		if s.KeyFingerprint == "" {
+			return nil, fmt.Errorf("apt key %s has no pinned fingerprint", s.KeyURL)
+		}
+		var err error
+		if data, err = downloadAptKey(ctx, s.KeyURL); err != nil {
+			return nil, err
+		}
+	}
+	keyring, err := dearmor(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read apt key: %w", err)
+	}
+	fingerprint, err := keyFingerprint(keyring)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read apt key: %w", err)
+	}
+	if s.KeyFingerprint != "" && fingerprint != normalizeFingerprint(s.KeyFingerprint) {
+		return nil, fmt.Errorf("%w: got %s, want %s", ErrAptKeyMismatch, fingerprint, normalizeFingerprint(s.KeyFingerprint))
+	}
+	return keyring, nil
+}
+
+func downloadAptKey(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download apt key: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download apt key %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxAptKeySize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download apt key: %w", err)
+	}
+	return data, nil
+}
+
+// normalizeFingerprint removes the spaces people put in fingerprints and uppercases the hex digits.
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(fingerprint), ""))
+}
+
+// dearmor decodes an ASCII-armored OpenPGP key. Binary keys are returned as they are.
+func dearmor(data []byte) ([]byte, error) {
+	const begin = "-----BEGIN PGP PUBLIC KEY BLOCK-----"
+	start := bytes.Index(data, []byte(begin))
+	if start < 0 {
+		return data, nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data[start+len(begin):]))
+	scanner.Scan() // Rest of the BEGIN line
+	inBody := false
+	var body strings.Builder
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !inBody {
+			// Armor headers, e.g. "Comment: ...", end with an empty line
+			if line == "" || strings.Contains(line, ": ") {
+				inBody = line == ""
+				continue
+			}
+			inBody = true
+		}
+		switch {
+		case strings.HasPrefix(line, "-----END PGP"):
+			decoded, err := base64.StdEncoding.DecodeString(body.String())
+			if err != nil {
+				return nil, fmt.Errorf("invalid armor: %w", err)
+			}
+			return decoded, nil
+		case strings.HasPrefix(line, "="):
+			// CRC24 checksum, the fingerprint is what matters
+		default:
+			body.WriteString(line)
+		}
+	}
+	return nil, errors.New("invalid armor: no end line")
+}
+
+// keyFingerprint returns the fingerprint of the primary key of a binary OpenPGP keyring, in uppercase hex.
+// The keyring must hold exactly one primary key, as apt trusts every key in a signed-by keyring.
+func keyFingerprint(keyring []byte) (string, error) {
+	var fingerprint string
+	for len(keyring) > 0 {
+		tag, body, rest, err := readPacket(keyring)
+		if err != nil {
+			return "", err
+		}
+		keyring = rest
+		if tag != 6 { // Public-Key packet; subkeys, user IDs and signatures belong to it
+			if fingerprint == "" {
+				return "", errors.New("keyring doesn't start with a public key")
+			}
+			continue
+		}
+		if fingerprint != "" {
+			return "", errors.New("keyring holds more than one key")
+		}
+		if len(body) == 0 {
+			return "", errors.New("empty public key packet")
+		}
+		switch body[0] {
+		case 4:
+			h := sha1.New()
+			h.Write([]byte{0x99, byte(len(body) >> 8), byte(len(body))})
+			h.Write(body)
+			fingerprint = strings.ToUpper(hex.EncodeToString(h.Sum(nil)))
+		case 6:
+			h := sha256.New()
+			h.Write([]byte{0x9b})
+			_ = binary.Write(h, binary.BigEndian, uint32(len(body)))
+			h.Write(body)
+			fingerprint = strings.ToUpper(hex.EncodeToString(h.Sum(nil)))
+		default:
+			return "", fmt.Errorf("unsupported key version %d", body[0])
+		}
+	}
+	if fingerprint == "" {
+		return "", errors.New("keyring is empty")
+	}
+	return fingerprint, nil
+}
+
+// readPacket splits the first OpenPGP packet off data (RFC 9580, section 4.2).
+func readPacket(data []byte) (tag byte, body, rest []byte, err error) {
+	if data[0]&0x80 == 0 {
+		return 0, nil, nil, errors.New("invalid packet header")
+	}
+	var length, header int
+	if data[0]&0x40 != 0 {
+		// New format
+		tag = data[0] & 0x3f
+		if len(data) < 2 {
+			return 0, nil, nil, io.ErrUnexpectedEOF
+		}
+		switch l := int(data[1]); {
+		case l < 192:
+			length, header = l, 2
+		case l < 224:
+			if len(data) < 3 {
+				return 0, nil, nil, io.ErrUnexpectedEOF
+			}
+			length, header = (l-192)<<8+int(data[2])+192, 3
+		case l == 255:
+			if len(data) < 6 {
+				return 0, nil, nil, io.ErrUnexpectedEOF
+			}
+			length, header = int(binary.BigEndian.Uint32(data[2:6])), 6
+		default:
+			return 0, nil, nil, errors.New("partial packet lengths aren't allowed in keys")
+		}
+	} else {
+		// Legacy format
+		tag = (data[0] >> 2) & 0x0f
+		switch data[0] & 0x03 {
+		case 0:
+			header = 2
+		case 1:
+			header = 3
+		case 2:
+			header = 5
+		default:
+			return 0, nil, nil, errors.New("indeterminate packet lengths aren't allowed in keys")
+		}
+		if len(data) < header {
+			return 0, nil, nil, io.ErrUnexpectedEOF
+		}
+		for _, b := range data[1:header] {
+			length = length<<8 | int(b)
+		}
+	}
+	if length < 0 || len(data)-header < length {
+		return 0, nil, nil, io.ErrUnexpectedEOF
+	}
+	return tag, data[header : header+length], data[header+length:], nil
+}
@@ -0,0 +1,234 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testKeyFingerprint is the fingerprint of the key in testdata/apt-key.gpg and testdata/apt-key.asc.
+const testKeyFingerprint = "4F440794A535552E46A861D0E2E0E9E93875AC27"
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// lengthEncoding selects how reencode writes packet lengths.
+type lengthEncoding int
+
+const (
+	newOneOctet lengthEncoding = iota
+	newTwoOctet
+	newFiveOctet
+	legacyTwoOctet
+	legacyFourOctet
+)
+
+// packet encodes an OpenPGP packet with the given header format.
+func packet(tag byte, body []byte, encoding lengthEncoding) []byte {
+	var header []byte
+	n := len(body)
+	switch encoding {
+	case newOneOctet:
+		header = []byte{0xc0 | tag, byte(n)}
+	case newTwoOctet:
+		n -= 192
+		header = []byte{0xc0 | tag, byte(n>>8) + 192, byte(n)}
+	case newFiveOctet:
+		header = binary.BigEndian.AppendUint32([]byte{0xc0 | tag, 255}, uint32(n))
+	case legacyTwoOctet:
+		header = binary.BigEndian.AppendUint16([]byte{0x80 | tag<<2 | 1}, uint16(n))
+	case legacyFourOctet:
+		header = binary.BigEndian.AppendUint32([]byte{0x80 | tag<<2 | 2}, uint32(n))
+	}
+	return append(header, body...)
+}
+
+// reencode rewrites every packet of a keyring with the given header format.
+func reencode(t *testing.T, keyring []byte, encoding lengthEncoding) []byte {
+	t.Helper()
+	var out []byte
+	for len(keyring) > 0 {
+		tag, body, rest, err := readPacket(keyring)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, packet(tag, body, encoding)...)
+		keyring = rest
+	}
+	return out
+}
+
+func TestDearmor(t *testing.T) {
+	binaryKey := readTestdata(t, "apt-key.gpg")
+	armored := readTestdata(t, "apt-key.asc")
+	withHeaders := bytes.Replace(armored, []byte("-----\n\n"), []byte("-----\nComment: Lantern\nVersion: test\n\n"), 1)
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []byte
+		wantErr bool
+	}{
+		{name: "binary", data: binaryKey, want: binaryKey},
+		{name: "armored", data: armored, want: binaryKey},
+		{name: "armor headers", data: withHeaders, want: binaryKey},
+		{name: "CRLF line endings", data: bytes.ReplaceAll(armored, []byte("\n"), []byte("\r\n")), want: binaryKey},
+		{name: "leading text", data: append([]byte("Lantern's signing key\n\n"), armored...), want: binaryKey},
+		{name: "no end line", data: armored[:bytes.Index(armored, []byte("-----END"))], wantErr: true},
+		{name: "invalid base64", data: []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----\n\nnot*base64\n-----END PGP PUBLIC KEY BLOCK-----\n"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := dearmor(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("dearmor: %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("dearmor = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyFingerprint(t *testing.T) {
+	key := readTestdata(t, "apt-key.gpg")
+	// A v6 key is hashed with SHA-256 over a four-octet length; its body is long enough for a two-octet
+	// packet length. The key material doesn't matter for the fingerprint.
+	v6 := append([]byte{6}, make([]byte, 199)...)
+	for i := range v6[1:] {
+		v6[i+1] = byte(i + 1)
+	}
+	v5 := append([]byte{5}, v6[1:]...)
+	userID := packet(13, []byte("Lantern <test@example.com>"), newOneOctet)
+
+	tests := []struct {
+		name    string
+		keyring []byte
+		want    string
+		wantErr string
+	}{
+		{name: "v4 legacy format", keyring: key, want: testKeyFingerprint},
+		{name: "v4 legacy two-octet lengths", keyring: reencode(t, key, legacyTwoOctet), want: testKeyFingerprint},
+		{name: "v4 legacy four-octet lengths", keyring: reencode(t, key, legacyFourOctet), want: testKeyFingerprint},
+		{name: "v4 new format", keyring: reencode(t, key, newOneOctet), want: testKeyFingerprint},
+		{name: "v4 new five-octet lengths", keyring: reencode(t, key, newFiveOctet), want: testKeyFingerprint},
+		{name: "v6 new two-octet length", keyring: append(packet(6, v6, newTwoOctet), userID...), want: "77D0695D5F86D42897E6EBD30891420E60374DEE03DC726598C73BA50033B1E7"},
+		{name: "v5", keyring: packet(6, v5, newTwoOctet), wantErr: "unsupported key version 5"},
+		{name: "two keys", keyring: readTestdata(t, "apt-keys-two.gpg"), wantErr: "more than one key"},
+		{name: "user ID first", keyring: append(userID, key...), wantErr: "doesn't start with a public key"},
+		{name: "empty", keyring: nil, wantErr: "empty"},
+		{name: "empty key packet", keyring: packet(6, nil, newOneOctet), wantErr: "empty public key packet"},
+		{name: "partial length", keyring: []byte{0xc6, 0xe1, 4, 0}, wantErr: "partial packet lengths"},
+		{name: "indeterminate length", keyring: append([]byte{0x9b}, key[2:]...), wantErr: "indeterminate packet lengths"},
+		{name: "truncated", keyring: key[:len(key)-10], wantErr: io.ErrUnexpectedEOF.Error()},
+		{name: "truncated header", keyring: []byte{0xc6, 0xff, 0}, wantErr: io.ErrUnexpectedEOF.Error()},
+		{name: "not a packet", keyring: []byte("mDMEatU08h"), wantErr: "invalid packet header"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := keyFingerprint(tt.keyring)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("keyFingerprint = %s, %v, want error %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("keyFingerprint = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadPacket(t *testing.T) {
+	body := bytes.Repeat([]byte{0xab}, 300)
+	for name, encoding := range map[string]lengthEncoding{
+		"new two-octet": newTwoOctet, "new five-octet": newFiveOctet,
+		"legacy two-octet": legacyTwoOctet, "legacy four-octet": legacyFourOctet,
+	} {
+		t.Run(name, func(t *testing.T) {
+			tag, got, rest, err := readPacket(append(packet(2, body, encoding), 0xff))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tag != 2 || !bytes.Equal(got, body) || !bytes.Equal(rest, []byte{0xff}) {
+				t.Errorf("readPacket = %d, %d bytes, %x", tag, len(got), rest)
+			}
+		})
+	}
+}
+
+func TestAptSourceKeyring(t *testing.T) {
+	key := readTestdata(t, "apt-key.gpg")
+	armored := readTestdata(t, "apt-key.asc")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/key.asc" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(armored)
+	}))
+	defer srv.Close()
+
+	spaced := "4f44 0794 a535 552e 46a8  61d0 e2e0 e9e9 3875 ac27"
+	other := "805660D2F4A23EEA2D15347BF37EA4B2D62F9C81"
+	tests := []struct {
+		name     string
+		source   AptSource
+		wantErr  error
+		anyError bool
+	}{
+		{name: "embedded without fingerprint", source: AptSource{Key: string(armored)}},
+		{name: "embedded binary", source: AptSource{Key: string(key), KeyFingerprint: testKeyFingerprint}},
+		{name: "fingerprint with spaces", source: AptSource{Key: string(armored), KeyFingerprint: spaced}},
+		{name: "embedded mismatch", source: AptSource{Key: string(armored), KeyFingerprint: other}, wantErr: ErrAptKeyMismatch},
+		{name: "downloaded", source: AptSource{KeyURL: srv.URL + "/key.asc", KeyFingerprint: spaced}},
+		{name: "downloaded mismatch", source: AptSource{KeyURL: srv.URL + "/key.asc", KeyFingerprint: other}, wantErr: ErrAptKeyMismatch},
+		{name: "downloaded without fingerprint", source: AptSource{KeyURL: srv.URL + "/key.asc"}, anyError: true},
+		{name: "download fails", source: AptSource{KeyURL: srv.URL + "/missing.asc", KeyFingerprint: testKeyFingerprint}, anyError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.source.keyring(context.Background())
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("keyring: %v, want %v", err, tt.wantErr)
+				}
+			case tt.anyError:
+				if err == nil {
+					t.Fatal("keyring succeeded")
+				}
+			case err != nil:
+				t.Fatal(err)
+			case !bytes.Equal(got, key):
+				t.Errorf("keyring = %x, want the binary key", got)
+			}
+		})
+	}
+}
+
+func TestStableAptSourceKey(t *testing.T) {
+	if !StableAptSource.Signed() || StableAptSource.KeyFingerprint == "" {
+		t.Fatal("StableAptSource is unsigned: commit the repository key to keys/getlantern-apt.asc and set lanternAptKeyFingerprint")
+	}
+	if _, err := StableAptSource.keyring(context.Background()); err != nil {
+		t.Errorf("embedded key: %v", err)
+	}
+}
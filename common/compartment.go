@@ -9,6 +9,9 @@ import (
 type CompartmentEntry struct {
 	ID        string          // Unique identifier for the entry (e.g., project ID)
 	Locations []CloudLocation // List of locations associated with the entry
+	// MissingServices lists provider services (e.g. GCP APIs) the entry needs but hasn't enabled.
+	// Locations is empty until they are enabled, see ServiceEnabler.
+	MissingServices []string
 }
 
 // Compartment represents a compartment in the cloud provider (billing account)
@@ -0,0 +1,33 @@
+package common
+
+// CostEstimate is the estimated price of running a server, in US dollars.
+// Estimates are based on list prices and don't include network egress or taxes.
+type CostEstimate struct {
+	Hourly         float64 // Price per hour with the requested options
+	OnDemandHourly float64 // Price per hour of the same server without discounts
+	Spot           bool    // Whether the estimate is for spot capacity
+}
+
+// hoursPerMonth is the average number of hours in a month used by cloud providers for monthly prices.
+const hoursPerMonth = 730
+
+// Monthly returns the estimated price per month.
+func (c CostEstimate) Monthly() float64 {
+	return c.Hourly * hoursPerMonth
+}
+
+// Discount returns the discount over the on-demand price as a fraction, e.g. 0.6 for 60% off.
+func (c CostEstimate) Discount() float64 {
+	if c.OnDemandHourly <= 0 {
+		return 0
+	}
+	return 1 - c.Hourly/c.OnDemandHourly
+}
+
+// CostEstimator is implemented by provisioners that can estimate the price of a server
+// before it is provisioned. Unlike other provisioner operations it returns synchronously,
+// since the estimate doesn't require calling the provider. Estimates may be fixed approximations
+// that don't depend on the location; providers document how theirs are made.
+type CostEstimator interface {
+	EstimateCost(locationID string, opts ...ProvisionOption) (*CostEstimate, error)
+}
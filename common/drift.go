@@ -0,0 +1,135 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DriftKind describes how a server at the provider differs from the inventory.
+type DriftKind string
+
+const (
+	DriftMissing   DriftKind = "missing"    // In the inventory but deleted at the provider
+	DriftOrphaned  DriftKind = "orphaned"   // Lantern resource at the provider that is not in the inventory
+	DriftIPChanged DriftKind = "ip_changed" // Public address differs from the recorded one
+	DriftStopped   DriftKind = "stopped"    // Exists but is not running
+)
+
+// Drift is a difference between the inventory and the provider's actual state.
+// Each drift can be resolved with Reconciler.Adopt, which makes the inventory match the provider,
+// or Reconciler.Purge, which removes an orphaned or missing server or resource both at the provider
+// and from the inventory.
+type Drift struct {
+	Kind     DriftKind    `json:"kind"`
+	ServerID string       `json:"server_id,omitempty"` // Local ID, empty for orphaned resources
+	Server   Server       `json:"server"`              // Server as it currently is at the provider
+	Status   ServerStatus `json:"status"`              // Status at the provider
+	Previous string       `json:"previous,omitempty"`  // Recorded value, e.g. the old IP address
+	Current  string       `json:"current,omitempty"`   // Actual value, e.g. the new IP address
+	Message  string       `json:"message"`             // Human-readable description
+}
+
+// Reconciler is implemented by provisioners that can compare the inventory with the servers
+// that actually exist at the provider. Like other provisioner operations these are non-blocking.
+type Reconciler interface {
+	// Reconcile checks all inventory servers of the provider and looks for orphaned Lantern resources.
+	// The result is reported with EventTypeReconcileCompleted, with the differences in Event.Drifts,
+	// or EventTypeReconcileError. Servers that couldn't be checked don't keep the others from being
+	// reported: their errors are joined in the completed event's Error.
+	Reconcile(ctx context.Context)
+	// Adopt resolves a drift by updating the inventory to match the provider.
+	// Orphaned servers are added to the inventory without an SSH key. Missing servers and
+	// orphaned static IPs can't be adopted.
+	// The result is reported with EventTypeDriftResolved or EventTypeDriftResolutionError.
+	Adopt(ctx context.Context, drift Drift)
+	// Purge resolves an orphaned or missing drift by deleting the server or resource at the provider,
+	// if it still exists, and removing it from the inventory. Servers that changed their address or
+	// are stopped still exist, so they are never deleted: their record is updated as with Adopt.
+	// The result is reported with EventTypeDriftResolved or EventTypeDriftResolutionError.
+	Purge(ctx context.Context, drift Drift)
+}
+
+// DetectDrift compares a recorded server with its refreshed copy, see LoadServer.
+func DetectDrift(recorded, actual InventoryRecord) []Drift {
+	id := recorded.Server.ID
+	drift := func(kind DriftKind, previous, current, message string) Drift {
+		return Drift{
+			Kind:     kind,
+			ServerID: id,
+			Server:   actual.Server,
+			Status:   actual.Status,
+			Previous: previous,
+			Current:  current,
+			Message:  message,
+		}
+	}
+	if actual.Status == ServerStatusMissing {
+		return []Drift{drift(DriftMissing, "", "",
+			fmt.Sprintf("Server %s (%s) no longer exists", id, recorded.Server.InstanceName))}
+	}
+	var res []Drift
+	if actual.Status == ServerStatusStopped {
+		res = append(res, drift(DriftStopped, string(recorded.Status), string(actual.Status),
+			fmt.Sprintf("Server %s (%s) is not running", id, recorded.Server.InstanceName)))
+	}
+	if old, cur := recorded.Server.Config.ExternalIp, actual.Server.Config.ExternalIp; old != cur {
+		res = append(res, drift(DriftIPChanged, old, cur,
+			fmt.Sprintf("Server %s (%s) changed its IP address from %s to %s", id, recorded.Server.InstanceName, old, cur)))
+	}
+	if old, cur := recorded.Server.StaticIPName, actual.Server.StaticIPName; old != cur {
+		res = append(res, drift(DriftIPChanged, old, cur,
+			fmt.Sprintf("Server %s (%s) lost its static IP address %s", id, recorded.Server.InstanceName, old)))
+	}
+	return res
+}
+
+// AdoptDrift updates the inventory to match the provider's state for a drift.
+func AdoptDrift(inventory Inventory, drift Drift) error {
+	if inventory == nil {
+		return errors.New("no inventory configured")
+	}
+	switch drift.Kind {
+	case DriftMissing:
+		return fmt.Errorf("server %s no longer exists and can't be adopted, purge it instead", drift.ServerID)
+	case DriftOrphaned:
+		if drift.Server.InstanceID == "" && drift.Server.InstanceName == "" {
+			return fmt.Errorf("%s is not a server and can't be adopted, purge it instead", drift.Server.StaticIPName)
+		}
+		server := drift.Server
+		record := NewInventoryRecord(&server, "")
+		record.Status = drift.Status
+		return inventory.Put(record)
+	default:
+		record, err := inventory.Get(drift.ServerID)
+		if err != nil {
+			return err
+		}
+		record.Server = drift.Server
+		record.Server.ID = drift.ServerID
+		record.Status = drift.Status
+		record.UpdatedAt = time.Now().UTC()
+		return inventory.Put(*record)
+	}
+}
+
+// PurgeDrift resolves a drift for Reconciler.Purge. For orphaned and missing drifts destroy deletes the
+// server or resource at the provider, which must not fail if it no longer exists, and the server is
+// removed from the inventory. Servers whose address changed or that are stopped are probably fine, so
+// they are adopted instead, see AdoptDrift. Other kinds of drift are an error.
+func PurgeDrift(inventory Inventory, store SecretStore, drift Drift, destroy func() error) error {
+	switch drift.Kind {
+	case DriftOrphaned, DriftMissing:
+		if err := destroy(); err != nil {
+			return err
+		}
+		if drift.ServerID == "" {
+			return nil
+		}
+		return ForgetServer(inventory, store, drift.ServerID)
+	case DriftIPChanged, DriftStopped:
+		return AdoptDrift(inventory, drift)
+	}
+	return fmt.Errorf("%s drift of server %s can't be purged", drift.Kind, drift.ServerID)
+}
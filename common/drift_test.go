@@ -0,0 +1,70 @@
+package common
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPurgeDrift(t *testing.T) {
+	tests := []struct {
+		kind      DriftKind
+		destroyed bool
+		recorded  bool // Whether the server is still in the inventory afterwards
+		wantErr   bool
+	}{
+		{kind: DriftOrphaned, destroyed: true, recorded: false},
+		{kind: DriftMissing, destroyed: true, recorded: false},
+		{kind: DriftIPChanged, recorded: true},
+		{kind: DriftStopped, recorded: true},
+		{kind: "unknown", recorded: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.kind), func(t *testing.T) {
+			inventory := NewMemoryInventory()
+			server := Server{Provider: "test", InstanceID: "1", Config: ServerConfiguration{ExternalIp: "192.0.2.1"}}
+			record := NewInventoryRecord(&server, "root")
+			record.Status = ServerStatusActive
+			if err := inventory.Put(record); err != nil {
+				t.Fatal(err)
+			}
+
+			actual := server
+			actual.Config.ExternalIp = "192.0.2.2"
+			drift := Drift{Kind: tt.kind, ServerID: server.ID, Server: actual, Status: ServerStatusStopped}
+			destroyed := false
+			err := PurgeDrift(inventory, nil, drift, func() error {
+				destroyed = true
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PurgeDrift: %v", err)
+			}
+			if destroyed != tt.destroyed {
+				t.Errorf("destroyed = %v, want %v", destroyed, tt.destroyed)
+			}
+			got, err := inventory.Get(server.ID)
+			if recorded := err == nil; recorded != tt.recorded {
+				t.Fatalf("recorded = %v, want %v (%v)", recorded, tt.recorded, err)
+			}
+			if tt.recorded && !tt.wantErr && (got.Server.Config.ExternalIp != "192.0.2.2" || got.Status != ServerStatusStopped) {
+				t.Errorf("record wasn't updated: %+v", got)
+			}
+		})
+	}
+}
+
+func TestPurgeDriftKeepsRecordWhenDestroyFails(t *testing.T) {
+	inventory := NewMemoryInventory()
+	server := Server{Provider: "test", InstanceID: "1"}
+	if err := inventory.Put(NewInventoryRecord(&server, "root")); err != nil {
+		t.Fatal(err)
+	}
+	failure := errors.New("delete failed")
+	err := PurgeDrift(inventory, nil, Drift{Kind: DriftMissing, ServerID: server.ID, Server: server}, func() error { return failure })
+	if !errors.Is(err, failure) {
+		t.Fatalf("PurgeDrift: %v, want the destroy error", err)
+	}
+	if _, err := inventory.Get(server.ID); err != nil {
+		t.Errorf("server was forgotten: %v", err)
+	}
+}
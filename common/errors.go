@@ -0,0 +1,99 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Error categories shared by all providers. Provider clients return *ProviderError values
+// that match one of these with errors.Is, so callers can react without knowing the provider:
+//
+//	if errors.Is(err, common.ErrBillingDisabled) { ... }
+var (
+	ErrUnauthorized      = errors.New("unauthorized")       // Token is missing, invalid or expired
+	ErrPermissionDenied  = errors.New("permission denied")  // Token is valid but lacks access to the resource
+	ErrBillingDisabled   = errors.New("billing disabled")   // Billing is not enabled or no payment method is set up
+	ErrQuotaExceeded     = errors.New("quota exceeded")     // Account or project quota (e.g. droplet limit, CPUs) is exhausted
+	ErrRegionUnavailable = errors.New("region unavailable") // Requested region or zone cannot host the resource right now
+	ErrRateLimited       = errors.New("rate limited")       // Too many requests; retry later
+	ErrNotFound          = errors.New("not found")          // Resource does not exist
+	ErrServiceDisabled   = errors.New("service disabled")   // Required provider API is not enabled
+	ErrTransient         = errors.New("transient error")    // Temporary provider-side failure; retry later
+)
+
+// ProviderError is an error returned by a cloud provider API, classified into one of the common categories.
+// The raw provider error is kept in Code, Message and Err, so it can still be inspected with errors.As.
+type ProviderError struct {
+	Provider   string // Provider name, e.g. "digitalocean" or "gcp"
+	Category   error  // One of the Err* categories, nil if the error could not be classified
+	StatusCode int    // HTTP status code, 0 if not applicable
+	Code       string // Raw provider error code, e.g. "unprocessable_entity" or "QUOTA_EXCEEDED"
+	Message    string // Raw provider error message
+	Err        error  // Underlying provider-specific error
+}
+
+func (e *ProviderError) Error() string {
+	category := "error"
+	if e.Category != nil {
+		category = e.Category.Error()
+	}
+	msg := e.Message
+	if msg == "" && e.Err != nil {
+		msg = e.Err.Error()
+	}
+	if e.Code != "" {
+		return fmt.Sprintf("%s %s (%s): %s", e.Provider, category, e.Code, msg)
+	}
+	return fmt.Sprintf("%s %s: %s", e.Provider, category, msg)
+}
+
+// Is reports whether target is the category of this error.
+func (e *ProviderError) Is(target error) bool {
+	return e.Category != nil && target == e.Category
+}
+
+// Unwrap returns the underlying provider-specific error.
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// Retriable implements Retriable. Rate limited and transient errors can be retried.
+func (e *ProviderError) Retriable() bool {
+	return e.Category == ErrRateLimited || e.Category == ErrTransient
+}
+
+// RetryAfter implements RetryAfterHint by delegating to the underlying error.
+func (e *ProviderError) RetryAfter() time.Duration {
+	return RetryAfter(e.Err)
+}
+
+// ErrorCategory returns the common category of err, or nil if err is not a classified provider error.
+func ErrorCategory(err error) error {
+	var pe *ProviderError
+	if errors.As(err, &pe) {
+		return pe.Category
+	}
+	return nil
+}
+
+// CategoryForStatus returns the default category for an HTTP status code.
+// Providers refine it using their own error codes, e.g. to detect billing or quota problems.
+func CategoryForStatus(statusCode int) error {
+	switch {
+	case statusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case statusCode == http.StatusPaymentRequired:
+		return ErrBillingDisabled
+	case statusCode == http.StatusForbidden:
+		return ErrPermissionDenied
+	case statusCode == http.StatusNotFound:
+		return ErrNotFound
+	case statusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case IsRetriableStatus(statusCode):
+		return ErrTransient
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package common
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// PortRange is a port, or a range of ports, that must be reachable from the internet.
+// Its text form matches firewalld's, e.g. "22/tcp" or "30000-30100/udp".
+type PortRange struct {
+	Protocol string // "tcp" or "udp"
+	From     int
+	To       int // Last port of the range, equal to From for a single port
+}
+
+// SSHPort is the port used to install and maintain servers.
+var SSHPort = PortRange{Protocol: "tcp", From: 22, To: 22}
+
+// AnyIPv4 and AnyIPv6 are the source ranges used for publicly reachable ports.
+const (
+	AnyIPv4 = "0.0.0.0/0"
+	AnyIPv6 = "::/0"
+)
+
+// Port returns a PortRange for a single port.
+func Port(protocol string, port int) PortRange {
+	return PortRange{Protocol: protocol, From: port, To: port}
+}
+
+// Ports returns the port or range in provider notation, e.g. "22" or "30000-30100".
+func (p PortRange) Ports() string {
+	if p.To <= p.From {
+		return strconv.Itoa(p.From)
+	}
+	return fmt.Sprintf("%d-%d", p.From, p.To)
+}
+
+func (p PortRange) String() string {
+	return p.Ports() + "/" + p.Protocol
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (p PortRange) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (p *PortRange) UnmarshalText(text []byte) error {
+	parsed, err := ParsePortRange(string(text))
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// ParsePortRange parses a port range in firewalld notation, e.g. "443/tcp" or "30000-30100/udp".
+func ParsePortRange(s string) (PortRange, error) {
+	ports, protocol, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok || (protocol != "tcp" && protocol != "udp") {
+		return PortRange{}, fmt.Errorf("invalid port range %q: expected <port>[-<port>]/<tcp|udp>", s)
+	}
+	fromStr, toStr, isRange := strings.Cut(ports, "-")
+	from, err := strconv.Atoi(fromStr)
+	if err != nil || from < 1 || from > 65535 {
+		return PortRange{}, fmt.Errorf("invalid port in %q", s)
+	}
+	to := from
+	if isRange {
+		if to, err = strconv.Atoi(toStr); err != nil || to < from || to > 65535 {
+			return PortRange{}, fmt.Errorf("invalid port range end in %q", s)
+		}
+	}
+	return PortRange{Protocol: protocol, From: from, To: to}, nil
+}
+
+// Contains reports whether p covers all ports of other.
+func (p PortRange) Contains(other PortRange) bool {
+	return p.Protocol == other.Protocol && p.From <= other.From && max(p.To, p.From) >= max(other.To, other.From)
+}
+
+// MergePorts returns the union of the given port lists without duplicates or ranges contained in others,
+// sorted by protocol and port.
+func MergePorts(lists ...[]PortRange) []PortRange {
+	var all []PortRange
+	for _, l := range lists {
+		for _, p := range l {
+			if p.To < p.From {
+				p.To = p.From
+			}
+			all = append(all, p)
+		}
+	}
+	slices.SortFunc(all, func(a, b PortRange) int {
+		if c := strings.Compare(a.Protocol, b.Protocol); c != 0 {
+			return c
+		}
+		if a.From != b.From {
+			return a.From - b.From
+		}
+		return b.To - a.To // Wider range first, so narrower ones are dropped below
+	})
+	var res []PortRange
+	for _, p := range all {
+		if len(res) > 0 && res[len(res)-1].Contains(p) {
+			continue
+		}
+		res = append(res, p)
+	}
+	return res
+}
@@ -1,13 +1,20 @@
 package common
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"log/slog"
+	"net"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/ssh"
@@ -30,7 +37,9 @@ func MakeSSHKeyPair() (string, string, error) {
 	return string(public), string(private), nil
 }
 
-func establishSSH(ip, privateKey, username string) (*ssh.Client, error) {
+// establishSSH connects to the server, retrying while it boots. ports are tried in turn, for servers
+// whose sshd may have been moved by SSHHardening.
+func establishSSH(ip string, ports []int, privateKey, username string) (*ssh.Client, error) {
 	signer, err := ssh.ParsePrivateKey([]byte(privateKey))
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse private key: %w", err)
@@ -47,7 +56,8 @@ func establishSSH(ip, privateKey, username string) (*ssh.Client, error) {
 	maxTries := 10
 	var client *ssh.Client
 	for {
-		client, err = ssh.Dial("tcp", ip+":22", config)
+		address := net.JoinHostPort(ip, strconv.Itoa(ports[tryCounter%len(ports)]))
+		client, err = ssh.Dial("tcp", address, config)
 		if err != nil {
 			if tryCounter >= maxTries {
 				return nil, fmt.Errorf("failed to dial SSH: %w after %d attempts", err, tryCounter)
@@ -59,27 +69,35 @@ func establishSSH(ip, privateKey, username string) (*ssh.Client, error) {
 		}
 		break
 	}
-	slog.Debug("SSH connection established", "ip", ip)
+	slog.Debug("SSH connection established", "address", client.RemoteAddr())
 	return client, nil
 }
 
-func runSSHCommand(client *ssh.Client, cmd string) ([]byte, error) {
-	session, err := client.NewSession()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create SSH session: %w", err)
-	}
-	defer session.Close()
-	output, err := session.CombinedOutput(cmd)
-	if err != nil {
-		return nil, fmt.Errorf("failed to run command %q: %w", cmd, err)
+type ServerConfiguration struct {
+	ExternalIp   string      `json:"external_ip"`
+	ExternalIpv6 string      `json:"external_ipv6,omitempty"` // Public IPv6 address, if the server has one
+	Port         int         `json:"port"`
+	AccessToken  string      `json:"access_token"`
+	OpenPorts    []PortRange `json:"open_ports,omitempty"` // Ports opened in firewalld on the server, e.g. for sing-box
+	SSHPort      int         `json:"ssh_port,omitempty"`   // sshd's port if it was moved by SSHHardening or isn't the default, 22 if 0
+}
+
+// sshPorts returns the port sshd listens on, as a list for establishSSH.
+func (sc ServerConfiguration) sshPorts() []int {
+	if sc.SSHPort > 0 {
+		return []int{sc.SSHPort}
 	}
-	return output, nil
+	return []int{SSHPort.From}
 }
 
-type ServerConfiguration struct {
-	ExternalIp  string `json:"external_ip"`
-	Port        int    `json:"port"`
-	AccessToken string `json:"access_token"`
+// FirewallPorts returns the ports that must be reachable from the internet for this server:
+// SSH, the lantern-server-manager API port and the ports opened on the server itself.
+func (sc ServerConfiguration) FirewallPorts() []PortRange {
+	ports := []PortRange{Port("tcp", sc.sshPorts()[0])}
+	if sc.Port > 0 {
+		ports = append(ports, Port("tcp", sc.Port))
+	}
+	return MergePorts(ports, sc.OpenPorts)
 }
 
 func (sc ServerConfiguration) Encode() string {
@@ -87,44 +105,308 @@ func (sc ServerConfiguration) Encode() string {
 	return string(data)
 }
 
-func InstallServer(ip string, privateSSHKey string, username string) (*ServerConfiguration, error) {
-	client, err := establishSSH(ip, privateSSHKey, username)
-	if err != nil {
-		return nil, fmt.Errorf("failed to establish SSH connection: %w", err)
+// installSteps returns the steps that install Lantern with profile on the recipe's operating system, for a
+// server maintained as username over sshPort. keyring is the apt source's verified signing key, nil if the
+// source isn't signed.
+func installSteps(recipe OSRecipe, profile InstallProfile, username string, sshPort int, keyring []byte) []installStep {
+	pm, fw := recipe.PackageManager, recipe.Firewall
+
+	// The Lantern packages are only published in an apt repository
+	const sourcesFile = "/etc/apt/sources.list.d/getlantern.list"
+	sourceLine := profile.AptSource.Line()
+	sourceCheck := fmt.Sprintf("grep -qsxF %s %s", shellQuote(sourceLine), sourcesFile)
+	var sourceApply []remoteCommand
+	if keyring != nil {
+		path := profile.AptSource.keyringPath()
+		sourceCheck += fmt.Sprintf(" && echo '%x  %s' | sha256sum --check --status", sha256.Sum256(keyring), path)
+		sourceApply = append(sourceApply,
+			remoteCommand{cmd: "sudo install -d -m 0755 " + AptKeyringDir},
+			remoteCommand{cmd: fmt.Sprintf("echo %s | base64 -d | sudo tee %s > /dev/null && sudo chmod 0644 %s",
+				base64.StdEncoding.EncodeToString(keyring), path, path)},
+		)
 	}
-	defer client.Close()
+	sourceApply = append(sourceApply,
+		remoteCommand{cmd: fmt.Sprintf("echo %s | sudo tee %s", shellQuote(sourceLine), sourcesFile)},
+		remoteCommand{cmd: pm.Refresh()},
+	)
 
-	// Run the installation commands
-	commands := []string{
-		"sudo cloud-init status --wait",
-		"sudo sh -c 'echo deb [trusted=yes] https://apt.fury.io/getlantern/ / > /etc/apt/sources.list.d/getlantern.list'",
-		"sudo apt-get update -y -q",
-		"sudo apt-get install -y lantern-server-manager sing-box-extensions fail2ban firewalld",
-		"sudo firewall-cmd --add-port 22/tcp --permanent",
-		"sudo systemctl restart systemd-journald",
-		"sudo systemctl enable --now firewalld lantern-server-manager fail2ban sing-box-extensions",
-	}
-	for _, cmd := range commands {
-		output, err2 := runSSHCommand(client, cmd)
-		if err2 != nil {
-			return nil, err2
+	packages := recipe.packages(profile)
+	var packageArgs []string
+	for _, pkg := range packages {
+		if version, ok := profile.PinnedVersions[pkg]; ok {
+			pkg += "=" + version
 		}
-		slog.Debug("Command output", "command", cmd, "output", string(output))
+		packageArgs = append(packageArgs, pkg)
+	}
+	installed := pm.Installed(packages, profile.PinnedVersions)
+	packagesApply := []remoteCommand{
+		// Finish a previous run that was interrupted, the package manager refuses to install anything until then
+		{cmd: pm.Repair()},
+		{cmd: pm.Install(packageArgs), timeout: 20 * time.Minute},
+	}
+	if len(profile.PinnedVersions) > 0 {
+		packagesApply = append(packagesApply, remoteCommand{cmd: pm.Hold(profile.pinnedPackages())})
+	}
+	// The port the installation connects on stays open when firewalld is started
+	ports := MergePorts([]PortRange{Port("tcp", sshPort)}, profile.ExtraPorts)
+	services := strings.Join(profile.Services, " ")
+
+	steps := []installStep{
+		{
+			// Images that log in as root may not have sudo, which all other steps use. cloud-init may still be
+			// running apt, so its lock is waited for.
+			name:  "sudo",
+			check: "command -v sudo > /dev/null",
+			apply: []remoteCommand{{
+				cmd:     "apt-get -o DPkg::Lock::Timeout=600 update -y -q && DEBIAN_FRONTEND=noninteractive apt-get -o DPkg::Lock::Timeout=600 install -y -q sudo",
+				timeout: 20 * time.Minute,
+			}},
+		},
+		{
+			name: "cloud-init",
+			// Not all images use cloud-init, e.g. GCP's Debian images. Exit code 2 means it finished with warnings.
+			check: "! command -v cloud-init > /dev/null",
+			apply: []remoteCommand{{cmd: "sudo cloud-init status --wait || [ $? -eq 2 ]", timeout: 20 * time.Minute}},
+		},
+	}
+	if len(profile.PreInstall) > 0 {
+		steps = append(steps, installStep{name: "pre-install", apply: hookCommands(profile.PreInstall)})
 	}
+	steps = append(steps,
+		installStep{
+			name:   "apt-source",
+			check:  sourceCheck,
+			apply:  sourceApply,
+			verify: "apt-cache show lantern-server-manager > /dev/null",
+		},
+		installStep{
+			name:   "packages",
+			check:  installed,
+			apply:  packagesApply,
+			verify: installed,
+		},
+	)
+	if len(recipe.Configure) > 0 {
+		steps = append(steps, installStep{name: "configure-" + string(recipe.Target), apply: hookCommands(recipe.Configure)})
+	}
+	steps = append(steps,
+		installStep{
+			name:  "firewall",
+			check: fw.QueryPorts(ports),
+			apply: []remoteCommand{
+				{cmd: fw.AddPorts(ports)},
+				// Apply the permanent configuration now, so the ports are reported and opened at the provider
+				{cmd: fw.Reload()},
+			},
+			verify: fw.QueryPorts(ports),
+		},
+		installStep{
+			name:  "journald",
+			apply: []remoteCommand{{cmd: "sudo systemctl restart systemd-journald"}},
+		},
+	)
+	if services != "" {
+		steps = append(steps, installStep{
+			name:   "services",
+			check:  "systemctl is-enabled --quiet " + services,
+			apply:  []remoteCommand{{cmd: "sudo systemctl enable --now " + services}},
+			verify: "systemctl is-enabled --quiet " + services,
+		})
+	}
+	if profile.AutomaticUpdates != nil {
+		steps = append(steps, automaticUpdatesStep(pm, *profile.AutomaticUpdates, profile.AptSource))
+	}
+	if len(profile.PostInstall) > 0 {
+		steps = append(steps, installStep{name: "post-install", apply: hookCommands(profile.PostInstall)})
+	}
+	if profile.SSHHardening != nil {
+		steps = append(steps, sshHardeningStep(recipe, profile.SSHPort().From, sshPort, username))
+	}
+	return steps
+}
+
+const sshdConfigFile = "/etc/ssh/sshd_config.d/00-lantern.conf"
+
+// sshHardeningStep returns the step that applies SSHHardening, moving sshd from currentPort to port.
+// sshd's configuration is validated before it is restarted, and currentPort is only closed once sshd
+// listens on the new port, so a mistake can't lock out the installation's own connection.
+func sshHardeningStep(recipe OSRecipe, port, currentPort int, username string) installStep {
+	rootLogin := "no"
+	if username == "root" {
+		rootLogin = "prohibit-password"
+	}
+	// The first value of each option wins, so the file is named to come before cloud-init's
+	config := strings.Join([]string{
+		"# Written by lantern-server-provisioner",
+		fmt.Sprintf("Port %d", port),
+		"PermitRootLogin " + rootLogin,
+		"PasswordAuthentication no",
+		"KbdInteractiveAuthentication no",
+		"PubkeyAuthentication yes",
+		"AuthenticationMethods publickey",
+		"Ciphers chacha20-poly1305@openssh.com,aes256-gcm@openssh.com,aes128-gcm@openssh.com",
+		"KexAlgorithms curve25519-sha256,curve25519-sha256@libssh.org",
+		"MACs hmac-sha2-512-etm@openssh.com,hmac-sha2-256-etm@openssh.com",
+		"HostKeyAlgorithms ssh-ed25519,rsa-sha2-512,rsa-sha2-256",
+		"PubkeyAcceptedAlgorithms ssh-ed25519,rsa-sha2-512,rsa-sha2-256",
+		"",
+	}, "\n")
+	listening := fmt.Sprintf("ss -Hlnt 'sport = :%d' | grep -q .", port)
+	service := recipe.SSHService
+	apply := []remoteCommand{
+		{cmd: fmt.Sprintf("printf '%%s' %s | sudo tee %s", shellQuote(config), sshdConfigFile)},
+		{cmd: fmt.Sprintf("sudo sshd -t || { sudo rm -f %s; exit 1; }", sshdConfigFile)},
+		{cmd: recipe.Firewall.AddPorts([]PortRange{Port("tcp", port)})},
+		{cmd: recipe.Firewall.Reload()},
+		// Ubuntu 24.04 starts sshd from a socket unit, whose port is generated from sshd_config
+		{cmd: fmt.Sprintf("sudo systemctl daemon-reload && if systemctl is-enabled --quiet %s.socket 2> /dev/null; then sudo systemctl restart %s.socket; fi && sudo systemctl restart %s",
+			service, service, service)},
+		{cmd: fmt.Sprintf("for i in $(seq 30); do %s && exit 0; sleep 1; done; exit 1", listening)},
+	}
+	if port != currentPort {
+		apply = append(apply,
+			remoteCommand{cmd: recipe.Firewall.CloseSSH(Port("tcp", currentPort))},
+			remoteCommand{cmd: recipe.Firewall.Reload()},
+		)
+	}
+	return installStep{
+		name:   "ssh-hardening",
+		apply:  apply,
+		verify: fmt.Sprintf("%s && sudo sshd -T | grep -qx 'passwordauthentication no'", listening),
+	}
+}
+
+// FirstBootScript returns a script for a new server's user data that moves sshd to the profile's SSH port
+// on first boot, or "" if sshd stays on port 22. The provider firewall then only needs to allow the
+// profile's port from the start; the rest of SSHHardening is applied by sshHardeningStep.
+// An existing sshd configuration is left alone, as GCP runs startup scripts on every boot.
+func (r OSRecipe) FirstBootScript(profile InstallProfile) string {
+	port := profile.SSHPort().From
+	if profile.SSHHardening == nil || port == SSHPort.From {
+		return ""
+	}
+	service := r.SSHService
+	return strings.Join([]string{
+		"#!/bin/sh",
+		fmt.Sprintf("[ -e %s ] && exit 0", sshdConfigFile),
+		"mkdir -p " + path.Dir(sshdConfigFile),
+		fmt.Sprintf("printf '# Written by lantern-server-provisioner\\nPort %d\\n' > %s", port, sshdConfigFile),
+		fmt.Sprintf("systemctl daemon-reload && if systemctl is-enabled --quiet %s.socket 2> /dev/null; then systemctl restart %s.socket; fi && systemctl restart %s",
+			service, service, service),
+		"",
+	}, "\n")
+}
+
+// hookCommands returns the commands to run a profile's hooks as root.
+// Hooks are recorded like other steps, so they run again only if they didn't complete.
+func hookCommands(hooks []string) []remoteCommand {
+	var commands []remoteCommand
+	for _, hook := range hooks {
+		commands = append(commands, remoteCommand{cmd: "sudo sh -c " + shellQuote(hook), timeout: 20 * time.Minute})
+	}
+	return commands
+}
+
+// shellQuote quotes s as a single POSIX shell word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// InstallServer installs Lantern on a server running target, as described by profile, connecting on port 22.
+// Each line of output of the installation commands is passed to log, which may be nil. The signing key of
+// a signed apt source is checked before connecting, and installation fails with ErrAptKeyMismatch if it
+// isn't the pinned one.
+// The installation is a list of idempotent steps that are recorded on the server when they complete.
+// If the SSH connection is lost, InstallServer connects again and continues with the interrupted step;
+// calling it again after it failed continues the same way, so a server never has to be started over.
+func InstallServer(ctx context.Context, ip string, privateSSHKey string, username string, target OSTarget, profile InstallProfile, log LogFunc) (*ServerConfiguration, error) {
+	return InstallServerOnPort(ctx, ip, SSHPort.From, privateSSHKey, username, target, profile, log)
+}
+
+// InstallServerOnPort is like InstallServer for a server whose sshd listens on another port, e.g. an
+// existing host. The port is kept open in firewalld, and recorded in ServerConfiguration.SSHPort.
+func InstallServerOnPort(ctx context.Context, ip string, sshPort int, privateSSHKey string, username string, target OSTarget, profile InstallProfile, log LogFunc) (*ServerConfiguration, error) {
+	recipe, err := Recipe(target)
+	if err != nil {
+		return nil, err
+	}
+	if err := profile.validate(); err != nil {
+		return nil, err
+	}
+	var keyring []byte
+	if profile.AptSource.Signed() {
+		// Checked before anything is installed, so a server never trusts the wrong key
+		if keyring, err = profile.AptSource.keyring(ctx); err != nil {
+			return nil, err
+		}
+	} else {
+		slog.Warn("Apt source has no signing key, packages won't be verified", "url", profile.AptSource.URL)
+	}
+	// sshd may have been moved already if the installation is resumed
+	ports := []int{sshPort}
+	if port := profile.SSHPort().From; profile.SSHHardening != nil && port != sshPort {
+		ports = append(ports, port)
+	}
+	steps := installSteps(recipe, profile, username, sshPort, keyring)
+	client, err := runInstallSteps(ctx, ip, ports, privateSSHKey, username, steps, log)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
 	// TODO: add sysctl configuration
-	// Wait for a few seconds to ensure the services are up and running
-	time.Sleep(5 * time.Second)
-	// Read the contents of /opt/lantern/data/server.json
-	output, err := runSSHCommand(client, "sudo cat /opt/lantern/data/server.json")
+	// lantern-server-manager writes server.json on startup
+	if err := waitForServices(ctx, client, LanternPackages); err != nil {
+		return nil, err
+	}
+	config, err := readServerConfiguration(ctx, client, recipe.Firewall)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case profile.SSHHardening != nil:
+		config.SSHPort = profile.SSHPort().From
+	case sshPort != SSHPort.From:
+		config.SSHPort = sshPort
+	}
+	return config, nil
+}
+
+// readServerConfiguration reads the configuration lantern-server-manager wrote to /opt/lantern/data/server.json,
+// along with the ports it opened for its services, so the cloud firewall can match them.
+func readServerConfiguration(ctx context.Context, client *ssh.Client, firewall FirewallTool) (*ServerConfiguration, error) {
+	// Not logged, the file holds the access token
+	output, err := runSSHCommand(ctx, client, "sudo cat /opt/lantern/data/server.json", 0, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
-	slog.Debug("Config file contents", "config", string(output))
 
-	// Parse the JSON output
 	var config ServerConfiguration
 	if err := json.Unmarshal(output, &config); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
+	slog.Debug("Read server configuration", "ip", config.ExternalIp, "port", config.Port)
+	if config.OpenPorts, err = listFirewallPorts(ctx, client, firewall); err != nil {
+		return nil, err
+	}
 	return &config, nil
 }
+
+// listFirewallPorts returns the ports opened in the server's firewall.
+func listFirewallPorts(ctx context.Context, client *ssh.Client, firewall FirewallTool) ([]PortRange, error) {
+	output, err := runSSHCommand(ctx, client, firewall.ListPorts(), 0, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list open ports: %w", err)
+	}
+	var ports []PortRange
+	for _, field := range strings.Fields(string(output)) {
+		port, err := ParsePortRange(field)
+		if err != nil {
+			// e.g. sctp or dccp ports, which we never open
+			slog.Debug("Ignoring unsupported firewall port", "port", field, "err", err)
+			continue
+		}
+		ports = append(ports, port)
+	}
+	return MergePorts(ports), nil
+}
@@ -0,0 +1,90 @@
+package common
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFirstBootScript(t *testing.T) {
+	recipe, err := Recipe(OSUbuntu2404)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		hardened *SSHHardening
+		want     string // Expected in the script, "" for no script
+	}{
+		{name: "no hardening"},
+		{name: "hardening on port 22", hardened: &SSHHardening{}},
+		{name: "hardening on port 22 explicitly", hardened: &SSHHardening{Port: 22}},
+		{name: "hardening on another port", hardened: &SSHHardening{Port: 2222}, want: "Port 2222"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile := DefaultInstallProfile()
+			profile.SSHHardening = tt.hardened
+			script := recipe.FirstBootScript(profile)
+			if tt.want == "" {
+				if script != "" {
+					t.Errorf("got a script:\n%s", script)
+				}
+				return
+			}
+			if !strings.HasPrefix(script, "#!/bin/sh\n") || !strings.Contains(script, tt.want) {
+				t.Errorf("script doesn't set %q:\n%s", tt.want, script)
+			}
+			if !strings.Contains(script, "[ -e "+sshdConfigFile+" ] && exit 0") {
+				t.Errorf("script overwrites an existing configuration:\n%s", script)
+			}
+		})
+	}
+}
+
+// stepKeys returns the keys of the install steps by name.
+func stepKeys(t *testing.T, profile InstallProfile) ([]string, map[string]string) {
+	t.Helper()
+	recipe, err := Recipe(OSDebian12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	keys := make(map[string]string)
+	for _, step := range installSteps(recipe, profile, "root", SSHPort.From, nil) {
+		names = append(names, step.name)
+		keys[step.name] = step.key()
+	}
+	return names, keys
+}
+
+func TestInstallStepsOrder(t *testing.T) {
+	names, _ := stepKeys(t, DefaultInstallProfile())
+	// Every step but the first uses sudo
+	if len(names) < 2 || names[0] != "sudo" || names[1] != "cloud-init" {
+		t.Errorf("steps = %v, want sudo, then cloud-init first", names)
+	}
+}
+
+func TestInstallStepKeys(t *testing.T) {
+	profile := DefaultInstallProfile()
+	_, before := stepKeys(t, profile)
+	if _, again := stepKeys(t, profile); !reflect.DeepEqual(before, again) {
+		t.Fatalf("keys aren't stable: %v, %v", before, again)
+	}
+	for name, key := range before {
+		if !strings.HasPrefix(key, name+"-") || strings.ContainsAny(key, " /") {
+			t.Errorf("key %q of step %s isn't a file name starting with the step name", key, name)
+		}
+	}
+
+	profile.ExtraPackages = append(profile.ExtraPackages, "htop")
+	profile.ExtraPorts = []PortRange{Port("udp", 8443)}
+	_, after := stepKeys(t, profile)
+	changed := map[string]bool{"packages": true, "firewall": true}
+	for name, key := range before {
+		if (after[name] != key) != changed[name] {
+			t.Errorf("step %s: key changed = %v, want %v", name, after[name] != key, changed[name])
+		}
+	}
+}
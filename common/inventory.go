@@ -0,0 +1,334 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ServerStatus is the state of a server at its provider, as last seen by the inventory.
+type ServerStatus string
+
+const (
+	ServerStatusActive  ServerStatus = "active"  // Running
+	ServerStatusStopped ServerStatus = "stopped" // Exists but is not running, e.g. after a preemption
+	ServerStatusMissing ServerStatus = "missing" // No longer exists at the provider
+	// Running, but its installation didn't finish, see InstallResumer
+	ServerStatusInstalling ServerStatus = "installing"
+)
+
+// RefreshedStatus returns the status of a recorded server given its status at the provider.
+// The provider can't tell whether a running server was installed, so an installing server stays installing.
+func RefreshedStatus(recorded, actual ServerStatus) ServerStatus {
+	if recorded == ServerStatusInstalling && actual == ServerStatusActive {
+		return ServerStatusInstalling
+	}
+	return actual
+}
+
+// InventoryRecord is everything needed to maintain a provisioned server after provisioning.
+// The server's SSH private key is kept separately in a SecretStore, see StoreSSHKey.
+type InventoryRecord struct {
+	Server    Server          `json:"server"`
+	SSHUser   string          `json:"ssh_user"` // User the SSH key is authorized for
+	Status    ServerStatus    `json:"status"`
+	Profile   *InstallProfile `json:"install_profile,omitempty"` // Profile the server was installed with, if not the default
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// InstallProfile returns the profile the server was installed with.
+func (r InventoryRecord) InstallProfile() InstallProfile {
+	if r.Profile == nil {
+		return DefaultInstallProfile()
+	}
+	return *r.Profile
+}
+
+// Inventory stores provisioned servers by their local ID (Server.ID).
+// Get returns an error matching ErrNotFound if there is no server with the ID.
+type Inventory interface {
+	Get(id string) (*InventoryRecord, error)
+	List() ([]InventoryRecord, error)
+	Put(record InventoryRecord) error
+	Delete(id string) error
+}
+
+// ServerManager is implemented by provisioners that record provisioned servers in an Inventory
+// and can operate on them by their local ID. Servers are reconciled with the provider's actual
+// state before each operation, so the inventory reflects servers that were changed or deleted
+// outside of the library. Like other provisioner operations these are non-blocking.
+type ServerManager interface {
+	// SetInventory replaces the inventory, which defaults to DefaultInventory.
+	SetInventory(inventory Inventory)
+	Inventory() Inventory
+	// SetSecretStore replaces the store for SSH private keys, which defaults to DefaultSecretStore with the
+	// passphrase given to the provisioner's constructor.
+	SetSecretStore(store SecretStore)
+	SecretStore() SecretStore
+	// DestroyServer deletes the server and its static address, and removes it and its SSH key.
+	// Progress is reported with EventTypeDestroyStarted, EventTypeDestroyCompleted or EventTypeDestroyError.
+	DestroyServer(ctx context.Context, id string)
+	// RotateServerIP is like IPRotator.RotateIP for a server in the inventory.
+	RotateServerIP(ctx context.Context, id string)
+}
+
+// NewServerID returns a random local ID for a server.
+func NewServerID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand doesn't fail on supported platforms
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// NewInventoryRecord returns a record for a newly provisioned server, assigning it a local ID if it has none.
+func NewInventoryRecord(server *Server, sshUser string) InventoryRecord {
+	if server.ID == "" {
+		server.ID = NewServerID()
+	}
+	now := time.Now().UTC()
+	return InventoryRecord{
+		Server:    *server,
+		SSHUser:   sshUser,
+		Status:    ServerStatusActive,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// LoadServer gets a server from the inventory and reconciles it with the provider using refresh,
+// which updates the record in place. The record is saved if refresh succeeds.
+func LoadServer(inventory Inventory, id string, refresh func(record *InventoryRecord) error) (*InventoryRecord, error) {
+	if inventory == nil {
+		return nil, errors.New("no inventory configured")
+	}
+	record, err := inventory.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := refresh(record); err != nil {
+		return nil, fmt.Errorf("failed to refresh server %s: %w", id, err)
+	}
+	record.UpdatedAt = time.Now().UTC()
+	if err := inventory.Put(*record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// UpdateServer saves the changed server of an existing record, e.g. after its IP was rotated.
+// Servers without a local ID, and a nil inventory, are ignored.
+func UpdateServer(inventory Inventory, server Server, status ServerStatus) error {
+	if inventory == nil || server.ID == "" {
+		return nil
+	}
+	record, err := inventory.Get(server.ID)
+	if err != nil {
+		return err
+	}
+	record.Server = server
+	record.Status = status
+	record.UpdatedAt = time.Now().UTC()
+	return inventory.Put(*record)
+}
+
+// DefaultInventoryPath returns the path of the default inventory file in the user's config directory.
+func DefaultInventoryPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "lantern-server-provisioner", "inventory.json"), nil
+}
+
+// DefaultInventory returns the inventory used by provisioners unless another one is set.
+// It is a FileInventory at DefaultInventoryPath, or a MemoryInventory if there is no config directory.
+func DefaultInventory() Inventory {
+	path, err := DefaultInventoryPath()
+	if err != nil {
+		return NewMemoryInventory()
+	}
+	return NewFileInventory(path)
+}
+
+// inventoryFile is the on-disk format of a FileInventory.
+type inventoryFile struct {
+	Version int               `json:"version"`
+	Servers []InventoryRecord `json:"servers"`
+}
+
+const inventoryFileVersion = 1
+
+// FileInventory is an Inventory kept in a JSON file. The file holds access tokens,
+// so it is only readable by the current user. Every operation reads the file, so changes made by
+// other processes are picked up; writes replace the file atomically.
+type FileInventory struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileInventory returns an inventory stored at path. The file is created on the first write.
+func NewFileInventory(path string) *FileInventory {
+	return &FileInventory{path: path}
+}
+
+func (f *FileInventory) Get(id string) (*InventoryRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	records, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	return findRecord(records, id)
+}
+
+func (f *FileInventory) List() ([]InventoryRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.load()
+}
+
+func (f *FileInventory) Put(record InventoryRecord) error {
+	if record.Server.ID == "" {
+		return errors.New("server has no ID")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	records, err := f.load()
+	if err != nil {
+		return err
+	}
+	return f.save(putRecord(records, record))
+}
+
+func (f *FileInventory) Delete(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	records, err := f.load()
+	if err != nil {
+		return err
+	}
+	return f.save(slices.DeleteFunc(records, func(r InventoryRecord) bool { return r.Server.ID == id }))
+}
+
+func (f *FileInventory) load() ([]InventoryRecord, error) {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inventory: %w", err)
+	}
+	var file inventoryFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse inventory %s: %w", f.path, err)
+	}
+	if file.Version > inventoryFileVersion {
+		return nil, fmt.Errorf("inventory %s has unsupported version %d", f.path, file.Version)
+	}
+	return file.Servers, nil
+}
+
+func (f *FileInventory) save(records []InventoryRecord) error {
+	data, err := json.MarshalIndent(inventoryFile{Version: inventoryFileVersion, Servers: records}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(f.path, data); err != nil {
+		return fmt.Errorf("failed to write inventory: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file at path with data, creating its directory if needed.
+// The file is only readable by the current user, and readers never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// CreateTemp already uses 0600, but be explicit as the files hold secrets
+	if err := os.Chmod(tmp.Name(), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// MemoryInventory is an Inventory that only lives as long as the process.
+type MemoryInventory struct {
+	mu      sync.Mutex
+	records []InventoryRecord
+}
+
+func NewMemoryInventory() *MemoryInventory {
+	return &MemoryInventory{}
+}
+
+func (m *MemoryInventory) Get(id string) (*InventoryRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return findRecord(m.records, id)
+}
+
+func (m *MemoryInventory) List() ([]InventoryRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.records), nil
+}
+
+func (m *MemoryInventory) Put(record InventoryRecord) error {
+	if record.Server.ID == "" {
+		return errors.New("server has no ID")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records = putRecord(m.records, record)
+	return nil
+}
+
+func (m *MemoryInventory) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records = slices.DeleteFunc(m.records, func(r InventoryRecord) bool { return r.Server.ID == id })
+	return nil
+}
+
+func findRecord(records []InventoryRecord, id string) (*InventoryRecord, error) {
+	for _, r := range records {
+		if r.Server.ID == id {
+			return &r, nil
+		}
+	}
+	return nil, fmt.Errorf("server %s: %w", id, ErrNotFound)
+}
+
+// putRecord replaces the record with the same ID, or appends it.
+func putRecord(records []InventoryRecord, record InventoryRecord) []InventoryRecord {
+	if i := slices.IndexFunc(records, func(r InventoryRecord) bool { return r.Server.ID == record.Server.ID }); i >= 0 {
+		records[i] = record
+		return records
+	}
+	return append(records, record)
+}
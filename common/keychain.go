@@ -0,0 +1,136 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// keychainService is the service name secrets are stored under in the OS keychain.
+const keychainService = "lantern-server-provisioner"
+
+// KeychainStore is a SecretStore backed by the OS keychain: the login keychain on macOS,
+// through the security tool, and the Secret Service (e.g. GNOME Keyring or KWallet) on Linux,
+// through secret-tool. Secrets are never passed on the command line, where other users could see them.
+type KeychainStore struct {
+	service string
+}
+
+// NewKeychainStore returns a keychain store that keeps secrets under service.
+func NewKeychainStore(service string) *KeychainStore {
+	return &KeychainStore{service: service}
+}
+
+// Available reports whether the OS keychain can be used.
+func (k *KeychainStore) Available() bool {
+	switch runtime.GOOS {
+	case "darwin":
+		_, err := exec.LookPath("security")
+		return err == nil
+	case "linux", "freebsd", "openbsd":
+		// secret-tool needs a session bus to reach the Secret Service
+		_, err := exec.LookPath("secret-tool")
+		return err == nil && os.Getenv("DBUS_SESSION_BUS_ADDRESS") != ""
+	}
+	return false
+}
+
+func (k *KeychainStore) Get(name string) ([]byte, error) {
+	var out []byte
+	var err error
+	switch runtime.GOOS {
+	case "darwin":
+		out, err = k.run(nil, "security", "find-generic-password", "-s", k.service, "-a", name, "-w")
+	default:
+		out, err = k.run(nil, "secret-tool", "lookup", "service", k.service, "account", name)
+	}
+	var toolErr *keychainError
+	if errors.As(err, &toolErr) && toolErr.notFound() {
+		return nil, fmt.Errorf("secret %s: %w", name, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	value := strings.TrimSpace(string(out))
+	if value == "" {
+		return nil, fmt.Errorf("secret %s: %w", name, ErrNotFound)
+	}
+	return decodeSecret(value)
+}
+
+func (k *KeychainStore) Set(name string, value []byte) error {
+	encoded := encodeSecret(value)
+	switch runtime.GOOS {
+	case "darwin":
+		// In interactive mode the command, including the secret, is read from stdin
+		cmd := fmt.Sprintf("add-generic-password -U -s %q -a %q -w %q\n", k.service, name, encoded)
+		_, err := k.run([]byte(cmd), "security", "-i")
+		return err
+	default:
+		_, err := k.run([]byte(encoded), "secret-tool", "store", "--label", k.service+" "+name, "service", k.service, "account", name)
+		return err
+	}
+}
+
+func (k *KeychainStore) Delete(name string) error {
+	var err error
+	switch runtime.GOOS {
+	case "darwin":
+		_, err = k.run(nil, "security", "delete-generic-password", "-s", k.service, "-a", name)
+	default:
+		_, err = k.run(nil, "secret-tool", "clear", "service", k.service, "account", name)
+	}
+	var toolErr *keychainError
+	if errors.As(err, &toolErr) && toolErr.notFound() {
+		// Deleting a secret that doesn't exist fails, which is fine
+		return nil
+	}
+	return err
+}
+
+// run runs a keychain tool with stdin as input and returns its output.
+func (k *KeychainStore) run(stdin []byte, name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return out, &keychainError{tool: name, stderr: strings.TrimSpace(stderr.String()), err: err}
+	}
+	return out, nil
+}
+
+// keychainError is returned when a keychain tool fails.
+type keychainError struct {
+	tool   string
+	stderr string
+	err    error
+}
+
+func (e *keychainError) Error() string {
+	return fmt.Sprintf("%s failed: %v (%s)", e.tool, e.err, e.stderr)
+}
+
+func (e *keychainError) Unwrap() error {
+	return e.err
+}
+
+// notFound reports whether the tool failed because there is no such secret.
+func (e *keychainError) notFound() bool {
+	var exitErr *exec.ExitError
+	if !errors.As(e.err, &exitErr) {
+		return false
+	}
+	if e.tool == "security" {
+		return exitErr.ExitCode() == 44 // errSecItemNotFound
+	}
+	// secret-tool exits with 1 and no message if nothing matched
+	return exitErr.ExitCode() == 1 && e.stderr == ""
+}
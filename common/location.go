@@ -20,14 +20,18 @@ type GeoLocation struct {
 // Predefined GeoLocation constants
 var (
 	AMSTERDAM         = GeoLocation{ID: "amsterdam", CountryCode: "NL"}
+	ASHBURN           = GeoLocation{ID: "ashburn", CountryCode: "US"}
 	NORTHERN_VIRGINIA = GeoLocation{ID: "northern-virginia", CountryCode: "US"}
 	BANGALORE         = GeoLocation{ID: "bangalore", CountryCode: "IN"}
 	IOWA              = GeoLocation{ID: "iowa", CountryCode: "US"}
 	CHANGHUA_COUNTY   = GeoLocation{ID: "changhua-county", CountryCode: "TW"}
 	DELHI             = GeoLocation{ID: "delhi", CountryCode: "IN"}
 	EEMSHAVEN         = GeoLocation{ID: "eemshaven", CountryCode: "NL"}
+	FALKENSTEIN       = GeoLocation{ID: "falkenstein", CountryCode: "DE"}
 	FRANKFURT         = GeoLocation{ID: "frankfurt", CountryCode: "DE"}
 	HAMINA            = GeoLocation{ID: "hamina", CountryCode: "FI"}
+	HELSINKI          = GeoLocation{ID: "helsinki", CountryCode: "FI"}
+	HILLSBORO         = GeoLocation{ID: "hillsboro", CountryCode: "US"}
 	HONG_KONG         = GeoLocation{ID: "HK", CountryCode: "HK"}
 	JAKARTA           = GeoLocation{ID: "jakarta", CountryCode: "ID"}
 	JURONG_WEST       = GeoLocation{ID: "jurong-west", CountryCode: "SG"}
@@ -39,6 +43,7 @@ var (
 	MONTREAL          = GeoLocation{ID: "montreal", CountryCode: "CA"}
 	MUMBAI            = GeoLocation{ID: "mumbai", CountryCode: "IN"}
 	NEW_YORK_CITY     = GeoLocation{ID: "new-york-city", CountryCode: "US"}
+	NUREMBERG         = GeoLocation{ID: "nuremberg", CountryCode: "DE"}
 	SAN_FRANCISCO     = GeoLocation{ID: "san-francisco", CountryCode: "US"}
 	SINGAPORE         = GeoLocation{ID: "SG", CountryCode: "SG"}
 	OSAKA             = GeoLocation{ID: "osaka", CountryCode: "JP"}
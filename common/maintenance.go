@@ -0,0 +1,88 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// Maintainer implements the maintenance operations that work the same way for every provider, reporting on
+// Events. Providers only supply how their servers are loaded and how their firewall is updated.
+type Maintainer struct {
+	Provider  string
+	Events    chan<- Event
+	Inventory Inventory
+	Secrets   SecretStore
+	Progress  ProgressFunc
+	Log       LogFunc // Receives the output of remote commands, may be nil
+	// LoadServer gets a server from the inventory, reconciled with the provider.
+	LoadServer func(ctx context.Context, id string) (*InventoryRecord, error)
+	// UpdateFirewall opens ports for an upgraded server whose ports changed. Nil if the provider has no firewall.
+	UpdateFirewall func(ctx context.Context, record InventoryRecord, ports []PortRange) error
+}
+
+// CheckUpdates implements UpdateChecker.
+func (m *Maintainer) CheckUpdates(ctx context.Context) {
+	go func() {
+		m.Events <- Event{Type: EventTypeUpdateCheckStarted}
+		m.progress("Checking the servers for updates")
+		statuses, err := CheckServerUpdates(ctx, m.Inventory, m.Secrets, m.Provider)
+		if err != nil {
+			slog.Error("Failed to check for updates", "err", err)
+			m.Events <- Event{Type: EventTypeUpdateCheckError, Error: err}
+			return
+		}
+		slog.Debug("Checked servers for updates", "servers", len(statuses))
+		m.Events <- Event{Type: EventTypeUpdateCheckCompleted, Updates: statuses}
+	}()
+}
+
+// UpgradeServer implements Upgrader.
+func (m *Maintainer) UpgradeServer(ctx context.Context, id string) {
+	go func() {
+		m.Events <- Event{Type: EventTypeUpgradeStarted, Message: id}
+		result, err := m.upgradeServer(ctx, id)
+		if err != nil {
+			slog.Error("Failed to upgrade server", "id", id, "err", err)
+			m.Events <- Event{Type: EventTypeUpgradeError, Error: err}
+			return
+		}
+		slog.Debug("Upgraded server", "id", id, "packages", result.UpgradedPackages())
+		m.Events <- Event{Type: EventTypeUpgradeCompleted, Message: result.Encode(), Server: &result.Server}
+	}()
+}
+
+func (m *Maintainer) upgradeServer(ctx context.Context, id string) (*UpgradeResult, error) {
+	record, err := m.LoadServer(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if record.Status != ServerStatusActive {
+		return nil, fmt.Errorf("server %s is %s", id, record.Status)
+	}
+	result, err := UpgradeServer(ctx, *record, m.Secrets, m.Progress, m.Log)
+	if err != nil {
+		return nil, err
+	}
+	if result.FirewallChanged() && m.UpdateFirewall != nil {
+		m.progress("Updating the firewall")
+		if err := m.UpdateFirewall(ctx, *record, result.Server.Config.FirewallPorts()); err != nil {
+			return nil, err
+		}
+	}
+	updated := *record
+	updated.Server = result.Server
+	if err := VerifyRecordedServer(ctx, updated, m.Secrets); err != nil {
+		return nil, err
+	}
+	if err := UpdateServer(m.Inventory, result.Server, ServerStatusActive); err != nil {
+		slog.Error("Failed to update server in inventory", "id", id, "err", err)
+	}
+	return result, nil
+}
+
+func (m *Maintainer) progress(message string) {
+	if m.Progress != nil {
+		m.Progress(message)
+	}
+}
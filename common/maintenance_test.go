@@ -0,0 +1,44 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMaintainerCheckUpdatesSkipsOtherServers(t *testing.T) {
+	inventory := NewMemoryInventory()
+	inventory.Put(InventoryRecord{Server: Server{ID: "1", Provider: "other"}, Status: ServerStatusActive})
+	inventory.Put(InventoryRecord{Server: Server{ID: "2", Provider: "test"}, Status: ServerStatusInstalling})
+	events := make(chan Event, 10)
+	m := &Maintainer{Provider: "test", Events: events, Inventory: inventory}
+
+	m.CheckUpdates(context.Background())
+	for _, want := range []EventType{EventTypeUpdateCheckStarted, EventTypeUpdateCheckCompleted} {
+		if e := <-events; e.Type != want || e.Error != nil || len(e.Updates) != 0 {
+			t.Errorf("event = %+v, want %v without updates", e, want)
+		}
+	}
+}
+
+func TestMaintainerUpgradeServerRequiresActiveServer(t *testing.T) {
+	events := make(chan Event, 10)
+	m := &Maintainer{
+		Provider: "test",
+		Events:   events,
+		LoadServer: func(_ context.Context, id string) (*InventoryRecord, error) {
+			if id != "1" {
+				return nil, errors.New("not found")
+			}
+			return &InventoryRecord{Server: Server{ID: id}, Status: ServerStatusStopped}, nil
+		},
+	}
+
+	m.UpgradeServer(context.Background(), "1")
+	if e := <-events; e.Type != EventTypeUpgradeStarted || e.Message != "1" {
+		t.Errorf("event = %+v, want upgrade of 1 started", e)
+	}
+	if e := <-events; e.Type != EventTypeUpgradeError || e.Error == nil {
+		t.Errorf("event = %+v, want an upgrade error", e)
+	}
+}
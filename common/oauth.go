@@ -15,12 +15,44 @@ const (
 	EventTypeProvisioningStarted
 	EventTypeProvisioningCompleted
 	EventTypeProvisioningError
+	EventTypeProgress                // Progress update for a long-running step; Message describes the step.
+	EventTypeCompartmentEntryCreated // A new compartment entry (e.g. project) was created; Message is its ID.
+	EventTypeCompartmentEntryError
+	EventTypeIPRotationStarted
+	EventTypeIPRotationCompleted
+	EventTypeIPRotationError
+	EventTypeServerPreempted // A spot server was reclaimed by the provider; Server is the preempted server.
+	EventTypeServerRestarted // A preempted server was started again; Server is the updated server.
+	EventTypePreemptionRecoveryError
+	EventTypeDestroyStarted
+	EventTypeDestroyCompleted // A server was destroyed; Message is its local ID.
+	EventTypeDestroyError
+	EventTypeReconcileStarted
+	EventTypeReconcileCompleted // Inventory was compared with the provider; Drifts lists the differences, Error what couldn't be checked.
+	EventTypeReconcileError
+	EventTypeDriftResolved // A drift was adopted or purged; Message is the drift kind.
+	EventTypeDriftResolutionError
+	EventTypeUpgradeStarted
+	EventTypeUpgradeCompleted // A server was upgraded; Message is the encoded UpgradeResult.
+	EventTypeUpgradeError
+	EventTypeLog // A line of output from a command run on a server; Message is the line.
+	EventTypeUpdateCheckStarted
+	EventTypeUpdateCheckCompleted // Updates lists the update status of each server.
+	EventTypeUpdateCheckError
+	EventTypeServicesEnabled // The missing services of a compartment entry were enabled; Message is its ID.
+	EventTypeServicesError
 )
 
+// ProgressFunc receives human-readable progress messages from long-running operations.
+type ProgressFunc func(message string)
+
 type Event struct {
 	Type    EventType
-	Error   error  // Error if the event is an error event.
-	Message string // Additional information about the event, if applicable.
+	Error   error          // Error if the event is an error event.
+	Message string         // Additional information about the event, if applicable.
+	Server  *Server        // Server the event relates to, set when provisioning or maintenance completes.
+	Drifts  []Drift        // Differences between the inventory and the provider, set when reconciliation completes.
+	Updates []UpdateStatus // Update status of each server, set when an update check completes.
 }
 
 // Session represents an ongoing provisioning session.
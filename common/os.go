@@ -0,0 +1,214 @@
+package common
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// OSTarget is an operating system that servers can be provisioned with.
+type OSTarget string
+
+const (
+	OSUbuntu2204 OSTarget = "ubuntu-22.04"
+	OSUbuntu2404 OSTarget = "ubuntu-24.04"
+	OSDebian12   OSTarget = "debian-12"
+)
+
+// DefaultOS is the operating system of servers provisioned without WithOS, and of servers recorded
+// before the operating system was.
+const DefaultOS = OSUbuntu2204
+
+// OSTargets lists the supported operating systems.
+var OSTargets = []OSTarget{OSUbuntu2204, OSUbuntu2404, OSDebian12}
+
+// WithOS provisions the server with the given operating system instead of DefaultOS.
+func WithOS(target OSTarget) ProvisionOption {
+	return func(o *ProvisionOptions) {
+		o.OS = target
+	}
+}
+
+// OSRecipe describes how Lantern is installed on an operating system.
+type OSRecipe struct {
+	Target OSTarget
+	// DefaultUser is the login user on providers where the image decides it, e.g. GCP.
+	// Providers that always log in as root ignore it.
+	DefaultUser    string
+	PackageManager PackageManager
+	Firewall       FirewallTool
+	SSHService     string // systemd unit of sshd
+	// ExtraPackages are needed on this operating system only, e.g. for fail2ban to read the journal.
+	ExtraPackages []string
+	// Configure runs as root once the packages are installed, to adapt them to the operating system.
+	Configure []string
+	// EndOfLife is when the distribution stops getting security updates. New servers aren't
+	// provisioned with it afterwards.
+	EndOfLife time.Time
+}
+
+// PackageManager builds the commands that install packages on a server.
+type PackageManager interface {
+	// Refresh updates the package lists.
+	Refresh() string
+	// Repair finishes an installation that was interrupted, e.g. by a lost connection.
+	Repair() string
+	// Install installs packages, which may have a version, e.g. "name=1.2.3".
+	Install(packages []string) string
+	// Installed succeeds if all packages are installed, at the given version if there is one.
+	Installed(packages []string, versions map[string]string) string
+	// Hold keeps packages at their installed version.
+	Hold(packages []string) string
+	// Upgrade upgrades installed packages to their latest version, keeping changed configuration files.
+	Upgrade(packages []string) string
+}
+
+// FirewallTool builds the commands that manage the firewall on a server.
+type FirewallTool interface {
+	// Package is the package that provides the firewall.
+	Package() string
+	// Service is the systemd unit of the firewall.
+	Service() string
+	// AddPorts opens ports permanently; Reload applies them.
+	AddPorts(ports []PortRange) string
+	// QueryPorts succeeds if all ports are open permanently.
+	QueryPorts(ports []PortRange) string
+	// CloseSSH closes the port sshd listened on, once it was moved to another port.
+	CloseSSH(port PortRange) string
+	// Reload applies the permanent configuration, if the firewall is running yet.
+	Reload() string
+	// ListPorts prints the open ports in PortRange text form, separated by whitespace.
+	ListPorts() string
+}
+
+// Recipe returns the recipe for target, which is DefaultOS if empty.
+func Recipe(target OSTarget) (OSRecipe, error) {
+	ubuntu := func(target OSTarget, endOfLife time.Time) OSRecipe {
+		return OSRecipe{
+			Target:         target,
+			DefaultUser:    "ubuntu",
+			PackageManager: apt{},
+			Firewall:       firewalld{},
+			SSHService:     "ssh",
+			EndOfLife:      endOfLife,
+		}
+	}
+	switch target {
+	case "", OSUbuntu2204:
+		return ubuntu(OSUbuntu2204, time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)), nil
+	case OSUbuntu2404:
+		return ubuntu(OSUbuntu2404, time.Date(2029, time.April, 30, 0, 0, 0, 0, time.UTC)), nil
+	case OSDebian12:
+		return OSRecipe{
+			Target:         OSDebian12,
+			DefaultUser:    "debian",
+			PackageManager: apt{},
+			Firewall:       firewalld{},
+			SSHService:     "ssh",
+			// Debian 12 logs to the journal only, fail2ban's default of reading auth.log finds nothing
+			ExtraPackages: []string{"python3-systemd"},
+			Configure: []string{
+				`if [ -d /etc/fail2ban/jail.d ]; then printf '[DEFAULT]\nbackend = systemd\n' > /etc/fail2ban/jail.d/00-lantern-systemd.conf; fi`,
+			},
+			// End of Debian LTS
+			EndOfLife: time.Date(2028, time.June, 30, 0, 0, 0, 0, time.UTC),
+		}, nil
+	}
+	return OSRecipe{}, fmt.Errorf("unsupported operating system %q, supported are %v", target, OSTargets)
+}
+
+// CheckSupported returns an error if the operating system no longer gets security updates.
+func (r OSRecipe) CheckSupported(now time.Time) error {
+	if now.After(r.EndOfLife) {
+		return fmt.Errorf("%s reached its end of life on %s, choose one of %v", r.Target, r.EndOfLife.Format(time.DateOnly), OSTargets)
+	}
+	return nil
+}
+
+// apt is the PackageManager of Debian and Ubuntu.
+type apt struct{}
+
+func (apt) Refresh() string {
+	return "sudo apt-get update -y -q"
+}
+
+func (apt) Repair() string {
+	return "sudo dpkg --configure -a"
+}
+
+func (apt) Install(packages []string) string {
+	return "sudo DEBIAN_FRONTEND=noninteractive apt-get install -y -q --allow-downgrades " + strings.Join(packages, " ")
+}
+
+func (apt) Installed(packages []string, versions map[string]string) string {
+	checks := []string{fmt.Sprintf("test \"$(dpkg-query -W -f='${db:Status-Abbrev}\\n' %s 2>/dev/null | grep -c '^ii')\" -eq %d",
+		strings.Join(packages, " "), len(packages))}
+	for _, pkg := range packages {
+		if version, ok := versions[pkg]; ok {
+			checks = append(checks, fmt.Sprintf("test \"$(dpkg-query -W -f='${Version}' %s)\" = %s", pkg, shellQuote(version)))
+		}
+	}
+	return strings.Join(checks, " && ")
+}
+
+func (apt) Hold(packages []string) string {
+	return "sudo apt-mark hold " + strings.Join(packages, " ")
+}
+
+func (apt) Upgrade(packages []string) string {
+	// Keep configuration files changed on the server, there is nobody to answer the prompt
+	return "sudo DEBIAN_FRONTEND=noninteractive apt-get install -y -q --only-upgrade -o Dpkg::Options::=--force-confold " +
+		strings.Join(packages, " ")
+}
+
+// firewalld is the FirewallTool of all supported operating systems.
+type firewalld struct{}
+
+func (firewalld) Package() string {
+	return "firewalld"
+}
+
+func (firewalld) Service() string {
+	return "firewalld"
+}
+
+func (firewalld) AddPorts(ports []PortRange) string {
+	cmd := "sudo firewall-cmd --permanent"
+	for _, port := range ports {
+		cmd += " --add-port " + port.String()
+	}
+	return cmd
+}
+
+func (firewalld) QueryPorts(ports []PortRange) string {
+	var queries []string
+	for _, port := range ports {
+		queries = append(queries, "sudo firewall-cmd --permanent --query-port "+port.String())
+	}
+	return strings.Join(queries, " && ")
+}
+
+func (firewalld) CloseSSH(port PortRange) string {
+	// The default zone allows the ssh service, port 22, in addition to the opened port
+	return "sudo firewall-cmd --permanent --remove-service ssh --remove-port " + port.String()
+}
+
+func (firewalld) Reload() string {
+	return "if sudo firewall-cmd --state > /dev/null 2>&1; then sudo firewall-cmd --reload; fi"
+}
+
+func (firewalld) ListPorts() string {
+	return "sudo firewall-cmd --list-ports"
+}
+
+// packages returns the names of all packages installed with profile on the recipe's operating system.
+func (r OSRecipe) packages(profile InstallProfile) []string {
+	packages := append(slices.Clone(LanternPackages), r.Firewall.Package())
+	for _, pkg := range slices.Concat(r.ExtraPackages, profile.ExtraPackages, profile.pinnedPackages()) {
+		if !slices.Contains(packages, pkg) {
+			packages = append(packages, pkg)
+		}
+	}
+	return packages
+}
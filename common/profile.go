@@ -0,0 +1,146 @@
+package common
+
+import (
+	_ "embed"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// AptSource is an apt repository that the Lantern packages are installed from.
+// If it has a signing key, the key is installed in AptKeyringDir and the source only trusts packages
+// signed with it. Without one, the source is marked trusted=yes and packages aren't verified.
+type AptSource struct {
+	URL        string   `json:"url"`
+	Suite      string   `json:"suite"`                // "/" for a flat repository, like Gemfury's
+	Components []string `json:"components,omitempty"` // Empty for a flat repository
+	// Key is the repository's signing key, ASCII-armored or binary, e.g. embedded with go:embed.
+	Key string `json:"key,omitempty"`
+	// KeyURL is downloaded if there is no Key. KeyFingerprint is required then.
+	KeyURL string `json:"key_url,omitempty"`
+	// KeyFingerprint pins the signing key's fingerprint, e.g. "0123 4567 89AB ...".
+	// Installation fails with ErrAptKeyMismatch if the key has another one.
+	KeyFingerprint string `json:"key_fingerprint,omitempty"`
+}
+
+// Line returns the source in one-line sources.list format.
+func (s AptSource) Line() string {
+	options := "[trusted=yes]"
+	if s.Signed() {
+		options = "[signed-by=" + s.keyringPath() + "]"
+	}
+	line := fmt.Sprintf("deb %s %s %s", options, s.URL, s.Suite)
+	if len(s.Components) > 0 {
+		line += " " + strings.Join(s.Components, " ")
+	}
+	return line
+}
+
+// lanternAptKey is the signing key of the Lantern Gemfury repository, ASCII-armored as Gemfury serves it.
+// Until it is committed the file is empty and StableAptSource stays unsigned.
+//
+//go:embed keys/getlantern-apt.asc
+var lanternAptKey string
+
+// lanternAptKeyFingerprint pins lanternAptKey, so replacing the key file alone can't change the trusted key.
+// It must be set together with the key.
+const lanternAptKeyFingerprint = ""
+
+var (
+	// StableAptSource is the repository of released Lantern packages.
+	StableAptSource = AptSource{
+		URL:            "https://apt.fury.io/getlantern/",
+		Suite:          "/",
+		Key:            strings.TrimSpace(lanternAptKey),
+		KeyFingerprint: lanternAptKeyFingerprint,
+	}
+	// StagingAptSource is the repository of pre-release Lantern packages, for testing them before release.
+	StagingAptSource = AptSource{URL: "https://apt.fury.io/getlantern-staging/", Suite: "/"}
+)
+
+// InstallProfile describes what is installed on a server. Use DefaultInstallProfile as a starting point
+// and pass the changed profile to Provision with WithInstallProfile.
+// The profile is recorded in the inventory, so an installation is resumed and upgraded the same way.
+type InstallProfile struct {
+	AptSource AptSource `json:"apt_source"`
+	// ExtraPackages are installed in addition to LanternPackages and the operating system's firewall.
+	ExtraPackages []string `json:"extra_packages,omitempty"`
+	// PinnedVersions installs the given version of a package, e.g. "lantern-server-manager": "1.2.3",
+	// and holds it at that version. Pinned packages are skipped by upgrades.
+	PinnedVersions map[string]string `json:"pinned_versions,omitempty"`
+	// ExtraPorts are opened in firewalld, and so in the provider's firewall, in addition to SSH.
+	ExtraPorts []PortRange `json:"extra_ports,omitempty"`
+	// Services are enabled and started after the packages are installed.
+	Services []string `json:"services"`
+	// PreInstall commands run as root once cloud-init is done, before the apt source is added.
+	PreInstall []string `json:"pre_install,omitempty"`
+	// PostInstall commands run as root after the services were enabled.
+	PostInstall []string `json:"post_install,omitempty"`
+	// AutomaticUpdates configures unattended-upgrades, if set.
+	AutomaticUpdates *AutomaticUpdates `json:"automatic_updates,omitempty"`
+	// SSHHardening hardens sshd once everything else is installed, if set.
+	SSHHardening *SSHHardening `json:"ssh_hardening,omitempty"`
+}
+
+// SSHHardening restricts sshd to key authentication with modern algorithms and disables password login.
+// Root can only log in with a key, and not at all if servers are maintained as another user.
+// sshd can be moved off port 22, which censors scan for; port 22 is then closed in firewalld, and the
+// new port is recorded in ServerConfiguration.SSHPort for maintenance.
+type SSHHardening struct {
+	Port int `json:"port,omitempty"` // sshd's port, 22 if 0
+}
+
+// SSHPort returns the port sshd listens on once the profile is installed.
+// Provisioners open it in the cloud firewall before installing, so the server stays reachable.
+func (p InstallProfile) SSHPort() PortRange {
+	if p.SSHHardening != nil && p.SSHHardening.Port > 0 {
+		return Port("tcp", p.SSHHardening.Port)
+	}
+	return SSHPort
+}
+
+// DefaultInstallProfile returns the profile servers are installed with unless another one is given.
+func DefaultInstallProfile() InstallProfile {
+	return InstallProfile{
+		AptSource:     StableAptSource,
+		ExtraPackages: []string{"fail2ban"},
+		Services:      []string{"firewalld", "lantern-server-manager", "fail2ban", "sing-box-extensions"},
+		// Security updates only, without automatic reboots
+		AutomaticUpdates: &AutomaticUpdates{},
+	}
+}
+
+// WithInstallProfile installs the server with profile instead of DefaultInstallProfile.
+func WithInstallProfile(profile InstallProfile) ProvisionOption {
+	return func(o *ProvisionOptions) {
+		o.Profile = &profile
+	}
+}
+
+// validate checks the settings that end up in configuration files on the server.
+func (p InstallProfile) validate() error {
+	if p.AutomaticUpdates != nil && p.AutomaticUpdates.RebootTime != "" {
+		if _, err := time.Parse("15:04", p.AutomaticUpdates.RebootTime); err != nil {
+			return fmt.Errorf("invalid reboot time %q, expected HH:MM", p.AutomaticUpdates.RebootTime)
+		}
+	}
+	if p.SSHHardening != nil && (p.SSHHardening.Port < 0 || p.SSHHardening.Port > 65535) {
+		return fmt.Errorf("invalid SSH port %d", p.SSHHardening.Port)
+	}
+	return nil
+}
+
+// pinnedPackages returns the names of the pinned packages, sorted.
+func (p InstallProfile) pinnedPackages() []string {
+	return slices.Sorted(maps.Keys(p.PinnedVersions))
+}
+
+// Unpinned returns the packages that don't have a pinned version.
+func (p InstallProfile) Unpinned(packages []string) []string {
+	return slices.DeleteFunc(slices.Clone(packages), func(pkg string) bool {
+		_, pinned := p.PinnedVersions[pkg]
+		return pinned
+	})
+}
@@ -19,7 +19,86 @@ type Provisioner interface {
 	// Session returns the current session for the provisioner, which can be used to track events.
 	Session() *Session
 	// Provision creates a new instance in the cloud provider using the specified placement ID and location ID.
-	Provision(ctx context.Context, placementID string, locationID string)
+	Provision(ctx context.Context, placementID string, locationID string, opts ...ProvisionOption)
+}
+
+// ProvisionOptions holds optional settings for Provision.
+// Providers ignore options they don't support.
+type ProvisionOptions struct {
+	// StaticIP keeps the server's public address stable across rebuilds.
+	// On DigitalOcean this assigns a reserved IP; GCP always promotes the address to a static IP.
+	StaticIP bool
+	// IPv6 gives the server a public IPv6 address in addition to IPv4.
+	// On GCP this provisions the server on a dual-stack subnet; DigitalOcean droplets always get one.
+	IPv6 bool
+	// Spot requests discounted capacity that the provider may reclaim at any time.
+	// On GCP this creates a SPOT instance that is stopped, not deleted, when preempted, and the
+	// provisioner watches it for preemption until the context passed to Provision is done.
+	Spot bool
+	// Profile is what is installed on the server, DefaultInstallProfile if nil.
+	Profile *InstallProfile
+	// OS is the operating system the server is provisioned with, DefaultOS if empty.
+	OS OSTarget
+}
+
+// InstallProfile returns the profile to install the server with.
+func (o ProvisionOptions) InstallProfile() InstallProfile {
+	if o.Profile == nil {
+		return DefaultInstallProfile()
+	}
+	return *o.Profile
+}
+
+// ProvisionOption configures ProvisionOptions.
+type ProvisionOption func(*ProvisionOptions)
+
+// NewProvisionOptions applies opts to the default options.
+func NewProvisionOptions(opts ...ProvisionOption) ProvisionOptions {
+	var o ProvisionOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithIPv6 requests a public IPv6 address in addition to IPv4.
+func WithIPv6() ProvisionOption {
+	return func(o *ProvisionOptions) {
+		o.IPv6 = true
+	}
+}
+
+// WithSpot requests discounted spot (preemptible) capacity.
+func WithSpot() ProvisionOption {
+	return func(o *ProvisionOptions) {
+		o.Spot = true
+	}
+}
+
+// WithStaticIP requests a public address that is kept across rebuilds.
+func WithStaticIP() ProvisionOption {
+	return func(o *ProvisionOptions) {
+		o.StaticIP = true
+	}
+}
+
+// CompartmentEntryCreator is implemented by provisioners that can create a new compartment entry
+// (e.g. a dedicated GCP project) for users that don't have a usable one yet.
+// Like other provisioner operations it is non-blocking; the result is reported with
+// EventTypeCompartmentEntryCreated or EventTypeCompartmentEntryError, after which the
+// new entry is included in Compartments.
+type CompartmentEntryCreator interface {
+	CreateCompartmentEntry(ctx context.Context, compartmentID string)
+}
+
+// ServiceEnabler is implemented by provisioners whose compartment entries may lack required provider
+// services, listed in CompartmentEntry.MissingServices. Validate never enables them, since it must not
+// change the user's account and enabling a service may incur costs. EnableServices enables them for
+// one entry, once the user agreed to it. Like other provisioner operations it is non-blocking; the result
+// is reported with EventTypeServicesEnabled or EventTypeServicesError, after which the entry's locations
+// are included in Compartments.
+type ServiceEnabler interface {
+	EnableServices(ctx context.Context, entryID string)
 }
 
 type BrowserOpener func(url string) error
@@ -0,0 +1,205 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// LogFunc receives lines of output from commands run on a server, as they are printed.
+type LogFunc func(line string)
+
+const (
+	// DefaultCommandTimeout bounds a single remote command unless it has its own timeout.
+	DefaultCommandTimeout = 10 * time.Minute
+	// outputTailLines is how many lines of output are kept for CommandError.
+	outputTailLines = 40
+	// outputBufferLines is how many lines of output can wait for the LogFunc. Once the buffer is full,
+	// reading the output waits for the LogFunc, so every line is passed on.
+	outputBufferLines = 256
+)
+
+// ErrCommandTimeout is matched by CommandError when a remote command didn't finish in time.
+var ErrCommandTimeout = errors.New("command timed out")
+
+// CommandError is returned when a remote command fails. It includes the last lines of the command's
+// output, so the problem can be diagnosed from the error alone.
+type CommandError struct {
+	Command string
+	Err     error
+	Tail    []string // Last lines of stdout and stderr, in the order they were printed
+}
+
+func (e *CommandError) Error() string {
+	msg := fmt.Sprintf("failed to run command %q: %v", e.Command, e.Err)
+	if len(e.Tail) > 0 {
+		msg += "\nlast output:\n" + strings.Join(e.Tail, "\n")
+	}
+	return msg
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// remoteCommand is a command run on a server with its own timeout.
+type remoteCommand struct {
+	cmd     string
+	timeout time.Duration // DefaultCommandTimeout if 0
+}
+
+// runSSHCommand runs cmd on the server and returns its standard output.
+// Each line of stdout and stderr is passed to log as it is printed, from another goroutine; the command
+// is slowed down rather than lines dropped if log can't keep up. log may be nil for commands whose output
+// must not be shown, e.g. because it holds secrets. The last lines of output are in the CommandError
+// either way. The command is killed if it doesn't finish within timeout (DefaultCommandTimeout if 0) or
+// when ctx is done.
+func runSSHCommand(ctx context.Context, client *ssh.Client, cmd string, timeout time.Duration, log LogFunc) ([]byte, error) {
+	if timeout <= 0 {
+		timeout = DefaultCommandTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer session.Close()
+
+	stdoutPipe, err := session.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	stderrPipe, err := session.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SSH session: %w", err)
+	}
+
+	out := newCommandOutput(log)
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		out.forward(ctx)
+	}()
+	var stdout bytes.Buffer
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		out.copyLines(ctx, io.TeeReader(stdoutPipe, &stdout))
+	}()
+	go func() {
+		defer wg.Done()
+		out.copyLines(ctx, stderrPipe)
+	}()
+
+	if err := session.Start(cmd); err != nil {
+		return nil, &CommandError{Command: cmd, Err: err}
+	}
+	done := make(chan error, 1)
+	go func() {
+		// Wait for the output to be read and forwarded before the exit status, so its lines are
+		// logged before the next command's. Forwarding stops when ctx is done.
+		wg.Wait()
+		out.close()
+		<-forwarded
+		done <- session.Wait()
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		_ = session.Signal(ssh.SIGKILL)
+		session.Close()
+		err = ctx.Err()
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("%w after %s", ErrCommandTimeout, timeout)
+		}
+	}
+	if err != nil {
+		return stdout.Bytes(), &CommandError{Command: cmd, Err: err, Tail: out.tail()}
+	}
+	return stdout.Bytes(), nil
+}
+
+// commandOutput forwards lines of a command's output and keeps the last ones.
+type commandOutput struct {
+	log     LogFunc
+	pending chan string // Lines waiting to be passed to log, nil without log
+	mu      sync.Mutex
+	lines   []string
+}
+
+func newCommandOutput(log LogFunc) *commandOutput {
+	o := &commandOutput{log: log}
+	if log != nil {
+		o.pending = make(chan string, outputBufferLines)
+	}
+	return o
+}
+
+// copyLines reads lines from r, waiting for log when too many are pending. Once ctx is done, lines are only
+// kept for the tail, as forward has stopped.
+func (o *commandOutput) copyLines(ctx context.Context, r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		o.mu.Lock()
+		o.lines = append(o.lines, line)
+		if len(o.lines) > outputTailLines {
+			o.lines = o.lines[len(o.lines)-outputTailLines:]
+		}
+		o.mu.Unlock()
+		if o.pending != nil && ctx.Err() == nil {
+			slog.Debug("Command output", "line", line)
+			select {
+			case o.pending <- line:
+			case <-ctx.Done():
+			}
+		}
+	}
+	// Drain the rest if a line was too long, so the command doesn't block on a full pipe
+	_, _ = io.Copy(io.Discard, r)
+}
+
+// close is called once all output was read.
+func (o *commandOutput) close() {
+	if o.pending != nil {
+		close(o.pending)
+	}
+}
+
+// forward passes the pending lines to log until close is called or ctx is done.
+func (o *commandOutput) forward(ctx context.Context) {
+	if o.pending == nil {
+		return
+	}
+	for {
+		select {
+		case line, ok := <-o.pending:
+			if !ok {
+				return
+			}
+			o.log(line)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (o *commandOutput) tail() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]string(nil), o.lines...)
+}
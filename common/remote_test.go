@@ -0,0 +1,108 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func numberedLines(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	return b.String()
+}
+
+func TestCommandOutputLogsEveryLine(t *testing.T) {
+	release := make(chan struct{})
+	var logged []string
+	out := newCommandOutput(func(line string) {
+		<-release
+		logged = append(logged, line)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		out.forward(ctx)
+	}()
+
+	const n = 10 * outputBufferLines
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		out.copyLines(ctx, strings.NewReader(numberedLines(n)))
+	}()
+	select {
+	case <-copied:
+		t.Fatal("output was read past the buffer without waiting for the log function")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	<-copied
+	out.close()
+	<-forwarded
+	if len(logged) != n {
+		t.Fatalf("logged %d lines, want %d", len(logged), n)
+	}
+	for i, line := range logged {
+		if want := fmt.Sprintf("line %d", i); line != want {
+			t.Fatalf("line %d is %q, want %q", i, line, want)
+		}
+	}
+}
+
+func TestCommandOutputStopsWaitingWithContext(t *testing.T) {
+	blocked := make(chan struct{})
+	defer close(blocked)
+	out := newCommandOutput(func(string) { <-blocked })
+	ctx, cancel := context.WithCancel(context.Background())
+	go out.forward(ctx)
+
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		out.copyLines(ctx, strings.NewReader(numberedLines(10*outputBufferLines)))
+	}()
+	cancel()
+	select {
+	case <-copied:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reading the output kept waiting for the log function after the context was done")
+	}
+	// The tail is still complete, so the error shows how the command ended
+	if tail := out.tail(); len(tail) != outputTailLines || tail[len(tail)-1] != fmt.Sprintf("line %d", 10*outputBufferLines-1) {
+		t.Errorf("unexpected tail %q", tail)
+	}
+}
+
+func TestCommandOutputForwardStopsWithContext(t *testing.T) {
+	out := newCommandOutput(func(string) {})
+	ctx, cancel := context.WithCancel(context.Background())
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		out.forward(ctx)
+	}()
+	cancel()
+	select {
+	case <-forwarded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("forward didn't return after the context was done")
+	}
+}
+
+func TestCommandOutputWithoutLog(t *testing.T) {
+	out := newCommandOutput(nil)
+	out.copyLines(context.Background(), strings.NewReader(numberedLines(10)))
+	out.close()
+	out.forward(context.Background())
+	if tail := out.tail(); len(tail) != 10 || tail[9] != "line 9" {
+		t.Errorf("tail of unlogged output = %q", tail)
+	}
+}
@@ -0,0 +1,228 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy describes how a failed provider API call is retried.
+// Delays grow exponentially from InitialInterval up to MaxInterval, with random jitter applied to each delay.
+// A delay requested by the provider (e.g. via Retry-After) always takes precedence over the computed one.
+type RetryPolicy struct {
+	MaxAttempts     int           // Total number of attempts, including the first one. Values below 1 mean a single attempt.
+	InitialInterval time.Duration // Delay before the first retry
+	MaxInterval     time.Duration // Upper bound for a computed delay
+	Multiplier      float64       // Factor applied to the delay after each attempt
+	Jitter          float64       // Randomization factor in [0, 1]; 0.2 means +/-20%
+	MaxElapsed      time.Duration // Overall time budget for all attempts, 0 means unlimited
+	// Classify reports whether an error is worth retrying. IsRetriable is used if nil.
+	Classify func(err error) bool
+}
+
+// DefaultRetryPolicy is a reasonable policy for interactive provider API calls.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:     5,
+	InitialInterval: 1 * time.Second,
+	MaxInterval:     30 * time.Second,
+	Multiplier:      2,
+	Jitter:          0.2,
+	MaxElapsed:      2 * time.Minute,
+}
+
+// NoRetry is a policy that performs a single attempt.
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+// Retriable is implemented by errors that know whether the failed call can be retried.
+type Retriable interface {
+	Retriable() bool
+}
+
+// RetryAfterHint is implemented by errors that carry a provider-requested delay before the next attempt.
+type RetryAfterHint interface {
+	RetryAfter() time.Duration
+}
+
+// Do calls op until it succeeds, returns a non-retriable error, the attempts are exhausted or ctx is done.
+// The last error returned by op is wrapped in the returned error, so errors.Is/As keep working.
+func (p RetryPolicy) Do(ctx context.Context, op func(ctx context.Context) error) error {
+	classify := p.Classify
+	if classify == nil {
+		classify = IsRetriable
+	}
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := op(ctx)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || !classify(err) {
+			return err
+		}
+		if attempt >= p.MaxAttempts {
+			if attempt > 1 {
+				return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+			}
+			return err
+		}
+		delay := p.Backoff(attempt)
+		if hint := RetryAfter(err); hint > delay {
+			delay = hint
+		}
+		if p.MaxElapsed > 0 && time.Since(start)+delay > p.MaxElapsed {
+			return fmt.Errorf("giving up after %d attempts, retry budget of %s exhausted: %w", attempt, p.MaxElapsed, err)
+		}
+		slog.Debug("Retrying after error", "attempt", attempt, "delay", delay, "error", err)
+		if err := Sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// ForMethod returns the policy to use for a request with the given HTTP method. Requests that aren't
+// idempotent, e.g. POST requests creating servers or reserving addresses, are only retried when the
+// provider didn't process them (see NotProcessed): a request that timed out or failed with a server
+// error may still have succeeded, and repeating it could create a second, billable resource.
+func (p RetryPolicy) ForMethod(method string) RetryPolicy {
+	if IsIdempotent(method) {
+		return p
+	}
+	classify := p.Classify
+	if classify == nil {
+		classify = IsRetriable
+	}
+	p.Classify = func(err error) bool {
+		return NotProcessed(err) && classify(err)
+	}
+	return p
+}
+
+// IsIdempotent reports whether repeating a request with the given HTTP method has the same effect as
+// making it once.
+func IsIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// NotProcessed reports whether err shows that a request was rejected before the provider acted on it:
+// it was rate limited, or the connection was refused before it was sent.
+func NotProcessed(err error) bool {
+	return errors.Is(err, ErrRateLimited) || errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// Backoff returns the jittered delay to wait after the given (1-based) failed attempt.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := float64(p.InitialInterval)
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	for i := 1; i < attempt; i++ {
+		delay *= multiplier
+		if p.MaxInterval > 0 && delay > float64(p.MaxInterval) {
+			break
+		}
+	}
+	if p.MaxInterval > 0 && delay > float64(p.MaxInterval) {
+		delay = float64(p.MaxInterval)
+	}
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(delay)
+}
+
+// Sleep waits for d or until ctx is done, whichever comes first.
+func Sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// IsRetriable is the default error classification used by RetryPolicy.
+// Errors implementing Retriable decide for themselves; otherwise network timeouts and
+// dropped connections are considered transient, and everything else is not.
+func IsRetriable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var r Retriable
+	if errors.As(err, &r) {
+		return r.Retriable()
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// RetryAfter returns the delay requested by the provider for err, or 0 if there is none.
+func RetryAfter(err error) time.Duration {
+	var hint RetryAfterHint
+	if errors.As(err, &hint) {
+		return hint.RetryAfter()
+	}
+	return 0
+}
+
+// IsRetriableStatus reports whether an HTTP status code indicates a transient failure.
+func IsRetriableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// ParseRetryAfter extracts the delay requested by a provider from response headers.
+// It understands the standard Retry-After header (delta-seconds or HTTP-date) and
+// the RateLimit-Reset header used by DigitalOcean and Hetzner, which holds the Unix time at which
+// the rate limit window resets. It returns 0 if no usable header is present.
+func ParseRetryAfter(h http.Header, now time.Time) time.Duration {
+	if v := h.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			return max(time.Duration(secs)*time.Second, 0)
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return max(t.Sub(now), 0)
+		}
+	}
+	if v := h.Get("RateLimit-Reset"); v != "" {
+		if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+			// DigitalOcean and Hetzner send an absolute Unix timestamp; the IETF draft uses delta-seconds.
+			if secs > 1_000_000_000 {
+				return max(time.Unix(secs, 0).Sub(now), 0)
+			}
+			return max(time.Duration(secs)*time.Second, 0)
+		}
+	}
+	return 0
+}
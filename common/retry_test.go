@@ -0,0 +1,164 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{InitialInterval: time.Second, MaxInterval: 5 * time.Second, Multiplier: 2}
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if got := p.Backoff(attempt); got != want {
+			t.Errorf("Backoff(%d) = %s, want %s", attempt, got, want)
+		}
+	}
+
+	p.Jitter = 0.2
+	for i := 0; i < 100; i++ {
+		if got := p.Backoff(2); got < 1600*time.Millisecond || got > 2400*time.Millisecond {
+			t.Fatalf("Backoff(2) with 20%% jitter = %s", got)
+		}
+	}
+}
+
+// fastPolicy retries without noticeable delays.
+var fastPolicy = RetryPolicy{MaxAttempts: 3, InitialInterval: time.Millisecond, Multiplier: 1}
+
+// countAttempts runs policy with an operation that always fails with err and returns the number of attempts.
+func countAttempts(policy RetryPolicy, err error) int {
+	attempts := 0
+	_ = policy.Do(context.Background(), func(context.Context) error {
+		attempts++
+		return err
+	})
+	return attempts
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	transient := &ProviderError{Provider: "test", Category: ErrTransient}
+	if n := countAttempts(fastPolicy, transient); n != 3 {
+		t.Errorf("transient error: %d attempts, want 3", n)
+	}
+	if n := countAttempts(fastPolicy, &ProviderError{Provider: "test", Category: ErrQuotaExceeded}); n != 1 {
+		t.Errorf("quota error: %d attempts, want 1", n)
+	}
+
+	attempts := 0
+	err := fastPolicy.Do(context.Background(), func(context.Context) error {
+		attempts++
+		if attempts < 2 {
+			return transient
+		}
+		return nil
+	})
+	if err != nil || attempts != 2 {
+		t.Errorf("got %v after %d attempts, want success after 2", err, attempts)
+	}
+
+	err = fastPolicy.Do(context.Background(), func(context.Context) error { return transient })
+	if !errors.Is(err, ErrTransient) {
+		t.Errorf("last error isn't wrapped: %v", err)
+	}
+}
+
+type hintError struct{ delay time.Duration }
+
+func (e hintError) Error() string             { return "rate limited" }
+func (e hintError) Retriable() bool           { return true }
+func (e hintError) RetryAfter() time.Duration { return e.delay }
+
+func TestRetryPolicyDoHonorsRetryAfter(t *testing.T) {
+	start := time.Now()
+	attempts := 0
+	_ = RetryPolicy{MaxAttempts: 2, InitialInterval: time.Millisecond}.Do(context.Background(), func(context.Context) error {
+		attempts++
+		return hintError{delay: 50 * time.Millisecond}
+	})
+	if elapsed := time.Since(start); attempts != 2 || elapsed < 50*time.Millisecond {
+		t.Errorf("%d attempts in %s, want 2 attempts at least 50ms apart", attempts, elapsed)
+	}
+
+	// A requested delay beyond the budget ends the retries right away
+	policy := RetryPolicy{MaxAttempts: 5, InitialInterval: time.Millisecond, MaxElapsed: time.Second}
+	if n := countAttempts(policy, hintError{delay: time.Minute}); n != 1 {
+		t.Errorf("%d attempts, want 1", n)
+	}
+}
+
+func TestRetryPolicyForMethod(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	tests := []struct {
+		method string
+		err    error
+		want   int
+	}{
+		{http.MethodGet, &ProviderError{Category: ErrTransient, StatusCode: 503}, 3},
+		{http.MethodDelete, &ProviderError{Category: ErrTransient, StatusCode: 500}, 3},
+		{http.MethodPut, timeoutError{}, 3},
+		{http.MethodPost, &ProviderError{Category: ErrTransient, StatusCode: 503}, 1},
+		{http.MethodPost, timeoutError{}, 1},
+		{http.MethodPost, fmt.Errorf("read: %w", syscall.ECONNRESET), 1},
+		{http.MethodPatch, &ProviderError{Category: ErrTransient, StatusCode: 502}, 1},
+		{http.MethodPost, &ProviderError{Category: ErrRateLimited, StatusCode: 429}, 3},
+		{http.MethodPost, refused, 3},
+		{http.MethodPost, &ProviderError{Category: ErrQuotaExceeded, StatusCode: 403}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %v", tt.method, tt.err), func(t *testing.T) {
+			if n := countAttempts(fastPolicy.ForMethod(tt.method), tt.err); n != tt.want {
+				t.Errorf("%d attempts, want %d", n, tt.want)
+			}
+		})
+	}
+
+	// The policy's own classification still applies
+	never := fastPolicy
+	never.Classify = func(error) bool { return false }
+	if n := countAttempts(never.ForMethod(http.MethodPost), &ProviderError{Category: ErrRateLimited}); n != 1 {
+		t.Errorf("%d attempts, want 1", n)
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    time.Duration
+	}{
+		{"none", nil, 0},
+		{"delta seconds", map[string]string{"Retry-After": "30"}, 30 * time.Second},
+		{"HTTP date", map[string]string{"Retry-After": now.Add(90 * time.Second).Format(http.TimeFormat)}, 90 * time.Second},
+		{"HTTP date in the past", map[string]string{"Retry-After": now.Add(-time.Minute).Format(http.TimeFormat)}, 0},
+		{"invalid Retry-After", map[string]string{"Retry-After": "soon"}, 0},
+		{"Unix reset time", map[string]string{"RateLimit-Reset": fmt.Sprint(now.Add(42 * time.Second).Unix())}, 42 * time.Second},
+		{"reset time in the past", map[string]string{"RateLimit-Reset": fmt.Sprint(now.Add(-time.Minute).Unix())}, 0},
+		{"delta reset", map[string]string{"RateLimit-Reset": "15"}, 15 * time.Second},
+		{"invalid reset", map[string]string{"RateLimit-Reset": "later"}, 0},
+		{"Retry-After first", map[string]string{"Retry-After": "5", "RateLimit-Reset": "15"}, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for k, v := range tt.headers {
+				h.Set(k, v)
+			}
+			if got := ParseRetryAfter(h, now); got != tt.want {
+				t.Errorf("ParseRetryAfter = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
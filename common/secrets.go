@@ -0,0 +1,271 @@
+package common
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// SecretStore keeps secrets, such as SSH private keys, encrypted at rest.
+// Get returns an error matching ErrNotFound if there is no secret with the name.
+type SecretStore interface {
+	Get(name string) ([]byte, error)
+	Set(name string, value []byte) error
+	Delete(name string) error
+}
+
+// PassphraseFunc returns the passphrase protecting a FileSecretStore, e.g. by prompting the user.
+type PassphraseFunc func() ([]byte, error)
+
+var (
+	ErrNoPassphrase    = errors.New("no passphrase available for the secret store")
+	ErrWrongPassphrase = errors.New("wrong passphrase for the secret store")
+)
+
+// DefaultSecretStore returns the secret store used by provisioners unless another one is set.
+// It is the OS keychain if one is available, and otherwise a FileSecretStore at DefaultSecretsPath
+// protected by passphrase. If passphrase is nil the file store can't be unlocked, and CheckSecretStore fails.
+func DefaultSecretStore(passphrase PassphraseFunc) SecretStore {
+	if keychain := NewKeychainStore(keychainService); keychain.Available() {
+		return keychain
+	}
+	path, err := DefaultSecretsPath()
+	if err != nil {
+		path = "secrets.json"
+	}
+	return NewFileSecretStore(path, passphrase)
+}
+
+// CheckSecretStore makes sure SSH keys can be kept in store. Provisioners call it before creating a server,
+// so a store that can't be used fails provisioning instead of losing the new server's only SSH key.
+// A FileSecretStore is unlocked, which may ask for its passphrase.
+func CheckSecretStore(store SecretStore) error {
+	if store == nil {
+		return errors.New("no secret store configured")
+	}
+	if f, ok := store.(*FileSecretStore); ok {
+		return f.Unlock()
+	}
+	return nil
+}
+
+// DefaultSecretsPath returns the path of the encrypted secrets file in the user's config directory.
+func DefaultSecretsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "lantern-server-provisioner", "secrets.json"), nil
+}
+
+// scrypt parameters for deriving the file encryption key, as recommended for interactive logins.
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = chacha20poly1305.KeySize
+)
+
+// passphraseCheck is encrypted with the derived key to detect a wrong passphrase.
+const passphraseCheck = "lantern-server-provisioner"
+
+// secretsFile is the on-disk format of a FileSecretStore.
+type secretsFile struct {
+	Version int               `json:"version"`
+	Salt    []byte            `json:"salt"`
+	N       int               `json:"n"`
+	R       int               `json:"r"`
+	P       int               `json:"p"`
+	Check   []byte            `json:"check"`   // passphraseCheck sealed with the key
+	Secrets map[string][]byte `json:"secrets"` // Sealed secrets by name
+}
+
+// FileSecretStore is a SecretStore kept in a file, encrypted with XChaCha20-Poly1305 using a key
+// derived from a passphrase with scrypt. The passphrase is requested once, on first use.
+type FileSecretStore struct {
+	path       string
+	passphrase PassphraseFunc
+	mu         sync.Mutex
+	key        []byte // Derived key, cached after the first use
+}
+
+// NewFileSecretStore returns a secret store at path. The file is created on the first write.
+func NewFileSecretStore(path string, passphrase PassphraseFunc) *FileSecretStore {
+	return &FileSecretStore{path: path, passphrase: passphrase}
+}
+
+// Unlock derives the key from the passphrase and checks it, so later operations don't need to.
+// The file is created if it doesn't exist yet.
+func (f *FileSecretStore) Unlock() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := f.load()
+	if err != nil {
+		return err
+	}
+	created := file.Check == nil
+	if err := f.unlock(file); err != nil {
+		return err
+	}
+	if created {
+		// Keep the salt the key was derived from
+		return f.save(file)
+	}
+	return nil
+}
+
+func (f *FileSecretStore) Get(name string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	sealed, ok := file.Secrets[name]
+	if !ok {
+		return nil, fmt.Errorf("secret %s: %w", name, ErrNotFound)
+	}
+	if err := f.unlock(file); err != nil {
+		return nil, err
+	}
+	value, err := open(f.key, sealed, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt secret %s: %w", name, err)
+	}
+	return value, nil
+}
+
+func (f *FileSecretStore) Set(name string, value []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := f.load()
+	if err != nil {
+		return err
+	}
+	if err := f.unlock(file); err != nil {
+		return err
+	}
+	if file.Secrets[name], err = seal(f.key, value, name); err != nil {
+		return err
+	}
+	return f.save(file)
+}
+
+func (f *FileSecretStore) Delete(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := f.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := file.Secrets[name]; !ok {
+		return nil
+	}
+	delete(file.Secrets, name)
+	return f.save(file)
+}
+
+// load reads the secrets file, or returns a new one with a fresh salt if it doesn't exist.
+func (f *FileSecretStore) load() (*secretsFile, error) {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		return &secretsFile{Version: 1, Salt: salt, N: scryptN, R: scryptR, P: scryptP, Secrets: map[string][]byte{}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets: %w", err)
+	}
+	var file secretsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse secrets %s: %w", f.path, err)
+	}
+	if file.Version != 1 {
+		return nil, fmt.Errorf("secrets %s have unsupported version %d", f.path, file.Version)
+	}
+	if file.Secrets == nil {
+		file.Secrets = map[string][]byte{}
+	}
+	return &file, nil
+}
+
+// unlock derives the key for file if it isn't cached yet, and checks it against the passphrase check.
+func (f *FileSecretStore) unlock(file *secretsFile) error {
+	if f.key == nil {
+		if f.passphrase == nil {
+			return fmt.Errorf("%w: SSH keys are kept in %s, which needs a passphrase", ErrNoPassphrase, f.path)
+		}
+		passphrase, err := f.passphrase()
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrNoPassphrase, err)
+		}
+		if f.key, err = scrypt.Key(passphrase, file.Salt, file.N, file.R, file.P, scryptKeyLen); err != nil {
+			return fmt.Errorf("failed to derive key: %w", err)
+		}
+	}
+	if file.Check == nil {
+		check, err := seal(f.key, []byte(passphraseCheck), "")
+		if err != nil {
+			return err
+		}
+		file.Check = check
+		return nil
+	}
+	if check, err := open(f.key, file.Check, ""); err != nil || string(check) != passphraseCheck {
+		f.key = nil
+		return ErrWrongPassphrase
+	}
+	return nil
+}
+
+func (f *FileSecretStore) save(file *secretsFile) error {
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(f.path, data)
+}
+
+// seal encrypts value, binding it to name so sealed values can't be swapped between names.
+func seal(key, value []byte, name string) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(value)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, value, []byte(name)), nil
+}
+
+func open(key, sealed []byte, name string) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed value is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, []byte(name))
+}
+
+// encodeSecret and decodeSecret store binary secrets in text-only backends, such as OS keychains.
+func encodeSecret(value []byte) string {
+	return base64.StdEncoding.EncodeToString(value)
+}
+
+func decodeSecret(value string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(value)
+}
@@ -0,0 +1,137 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func passphrase(s string) PassphraseFunc {
+	return func() ([]byte, error) { return []byte(s), nil }
+}
+
+func TestFileSecretStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	store := NewFileSecretStore(path, passphrase("correct horse"))
+	if _, err := store.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get of a missing secret: %v, want ErrNotFound", err)
+	}
+	if err := store.Set("a", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("b", []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, plain := range []string{"first", "second", "correct horse"} {
+		if bytes.Contains(data, []byte(plain)) {
+			t.Errorf("secrets file contains %q in plain text", plain)
+		}
+	}
+
+	reopened := NewFileSecretStore(path, passphrase("correct horse"))
+	for name, want := range map[string]string{"a": "first", "b": "second"} {
+		got, err := reopened.Get(name)
+		if err != nil || string(got) != want {
+			t.Errorf("Get(%s) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+
+	if err := reopened.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.Get("a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete: %v, want ErrNotFound", err)
+	}
+	if err := reopened.Delete("a"); err != nil {
+		t.Errorf("Delete of a missing secret: %v", err)
+	}
+}
+
+func TestFileSecretStorePassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	if err := NewFileSecretStore(path, passphrase("right")).Set("key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewFileSecretStore(path, passphrase("wrong")).Get("key"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Get with a wrong passphrase: %v, want ErrWrongPassphrase", err)
+	}
+	if err := NewFileSecretStore(path, passphrase("wrong")).Set("other", []byte("value")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Set with a wrong passphrase: %v, want ErrWrongPassphrase", err)
+	}
+	if _, err := NewFileSecretStore(path, nil).Get("key"); !errors.Is(err, ErrNoPassphrase) {
+		t.Errorf("Get without a passphrase: %v, want ErrNoPassphrase", err)
+	}
+	failing := func() ([]byte, error) { return nil, errors.New("cancelled") }
+	if _, err := NewFileSecretStore(path, failing).Get("key"); !errors.Is(err, ErrNoPassphrase) {
+		t.Errorf("Get with a failing passphrase prompt: %v, want ErrNoPassphrase", err)
+	}
+}
+
+func TestFileSecretStoreBindsNames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	store := NewFileSecretStore(path, passphrase("pass"))
+	if err := store.Set("a", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("b", []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file secretsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	file.Secrets["a"], file.Secrets["b"] = file.Secrets["b"], file.Secrets["a"]
+	if data, err = json.Marshal(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("a"); err == nil {
+		t.Error("a secret sealed for another name was decrypted")
+	}
+}
+
+func TestCheckSecretStore(t *testing.T) {
+	if err := CheckSecretStore(nil); err == nil {
+		t.Error("CheckSecretStore(nil) succeeded")
+	}
+
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	if err := CheckSecretStore(NewFileSecretStore(path, nil)); !errors.Is(err, ErrNoPassphrase) {
+		t.Errorf("store without a passphrase: %v, want ErrNoPassphrase", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("a failed check created the file: %v", err)
+	}
+
+	store := NewFileSecretStore(path, passphrase("pass"))
+	if err := CheckSecretStore(store); err != nil {
+		t.Fatalf("CheckSecretStore: %v", err)
+	}
+	// The key derived by the check must match the salt written to the file
+	if err := store.Set("key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewFileSecretStore(path, passphrase("pass")).Get("key")
+	if err != nil || string(got) != "value" {
+		t.Errorf("Get after check = %q, %v", got, err)
+	}
+	if err := CheckSecretStore(NewFileSecretStore(path, passphrase("wrong"))); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("store with a wrong passphrase: %v, want ErrWrongPassphrase", err)
+	}
+}
@@ -0,0 +1,45 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Server identifies a provisioned server at its cloud provider, so it can be maintained after provisioning.
+type Server struct {
+	ID           string              `json:"id,omitempty"`             // Stable local ID, see Inventory
+	Provider     string              `json:"provider"`                 // Provider name, e.g. "digitalocean" or "gcp"
+	PlacementID  string              `json:"placement_id"`             // Project the server was provisioned in
+	LocationID   string              `json:"location_id"`              // Region or zone ID
+	InstanceID   string              `json:"instance_id"`              // Provider-specific instance ID
+	InstanceName string              `json:"instance_name"`            // Instance name
+	StaticIPName string              `json:"static_ip_name,omitempty"` // GCP address name, DigitalOcean reserved IP or Hetzner primary IP ID
+	Spot         bool                `json:"spot,omitempty"`           // Provisioned on spot capacity that can be preempted
+	OS           OSTarget            `json:"os,omitempty"`             // Operating system, DefaultOS if empty
+	Config       ServerConfiguration `json:"config"`                   // Configuration read from the server
+}
+
+func (s Server) Encode() string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+// IPRotator is implemented by provisioners that can move a server to a new public IP address,
+// e.g. after it was blocked by a censor. The server keeps its installed state.
+// Like other provisioner operations it is non-blocking; progress is reported with
+// EventTypeIPRotationStarted, EventTypeIPRotationCompleted (with the updated Server and
+// the new ServerConfiguration encoded in Message) or EventTypeIPRotationError. The started and error
+// events identify the server by its inventory ID in Message, and carry the Server once it is known.
+type IPRotator interface {
+	RotateIP(ctx context.Context, server Server)
+}
+
+// PreemptionWatcher is implemented by provisioners that can keep spot servers running.
+// WatchServer returns immediately and watches the server until ctx is done. When the provider
+// reclaims the server it reports EventTypeServerPreempted and tries to start it again, reporting
+// EventTypeServerRestarted on success. If the server can't be restarted where it is, a replacement
+// is provisioned elsewhere and reported with the usual provisioning events; if that isn't possible
+// either, EventTypePreemptionRecoveryError is reported.
+type PreemptionWatcher interface {
+	WatchServer(ctx context.Context, server Server)
+}
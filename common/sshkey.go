@@ -0,0 +1,121 @@
+package common
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshKeySecretName returns the name a server's SSH private key is stored under in a SecretStore.
+func sshKeySecretName(serverID string) string {
+	return "ssh-key/" + serverID
+}
+
+// StoreSSHKey stores the PEM encoded SSH private key of a server.
+func StoreSSHKey(store SecretStore, serverID, privateKey string) error {
+	if store == nil {
+		return errors.New("no secret store configured")
+	}
+	if err := store.Set(sshKeySecretName(serverID), []byte(privateKey)); err != nil {
+		return fmt.Errorf("failed to store SSH key of server %s: %w", serverID, err)
+	}
+	return nil
+}
+
+// LoadSSHKey returns the PEM encoded SSH private key of a server.
+func LoadSSHKey(store SecretStore, serverID string) (string, error) {
+	if store == nil {
+		return "", errors.New("no secret store configured")
+	}
+	key, err := store.Get(sshKeySecretName(serverID))
+	if err != nil {
+		return "", fmt.Errorf("failed to load SSH key of server %s: %w", serverID, err)
+	}
+	return string(key), nil
+}
+
+// ExportSSHKey returns a server's SSH key pair in OpenSSH format: the private key as written by
+// ssh-keygen, usable with ssh -i, and the public key in authorized_keys format.
+func ExportSSHKey(store SecretStore, serverID string) (privateKey, publicKey []byte, err error) {
+	pemKey, err := LoadSSHKey(store, serverID)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := ssh.ParseRawPrivateKey([]byte(pemKey))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse SSH key of server %s: %w", serverID, err)
+	}
+	block, err := ssh.MarshalPrivateKey(key, "lantern-"+serverID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to export SSH key of server %s: %w", serverID, err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(block), ssh.MarshalAuthorizedKey(signer.PublicKey()), nil
+}
+
+// DialServer opens an SSH connection to a server in the inventory, using its stored SSH key.
+func DialServer(record InventoryRecord, store SecretStore) (*ssh.Client, error) {
+	key, err := LoadSSHKey(store, record.Server.ID)
+	if err != nil {
+		return nil, err
+	}
+	if record.SSHUser == "" {
+		return nil, fmt.Errorf("server %s has no SSH user", record.Server.ID)
+	}
+	return establishSSH(record.Server.Config.ExternalIp, record.Server.Config.sshPorts(), key, record.SSHUser)
+}
+
+// AuthorizeSSHKey logs in to a server with a password and adds publicKey, in authorized_keys format,
+// to the user's authorized keys, so the server can be installed and maintained with the key.
+// Adding a key that is already authorized does nothing.
+func AuthorizeSSHKey(ctx context.Context, ip string, port int, username, password, publicKey string) error {
+	// Hosts that disable plain password authentication often still ask for the password interactively
+	answer := func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i := range answers {
+			answers[i] = password
+		}
+		return answers, nil
+	}
+	config := &ssh.ClientConfig{
+		User:            username,
+		Auth:            []ssh.AuthMethod{ssh.Password(password), ssh.KeyboardInteractive(answer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         30 * time.Second,
+	}
+	client, err := ssh.Dial("tcp", net.JoinHostPort(ip, strconv.Itoa(port)), config)
+	if err != nil {
+		return fmt.Errorf("failed to log in with password: %w", err)
+	}
+	defer client.Close()
+
+	key := shellQuote(strings.TrimSpace(publicKey))
+	cmd := fmt.Sprintf("umask 077 && mkdir -p ~/.ssh && { grep -qsxF %s ~/.ssh/authorized_keys || echo %s >> ~/.ssh/authorized_keys; }", key, key)
+	if _, err := runSSHCommand(ctx, client, cmd, 0, nil); err != nil {
+		return fmt.Errorf("failed to authorize SSH key: %w", err)
+	}
+	return nil
+}
+
+// ForgetServer removes a server from the inventory and deletes its SSH key.
+func ForgetServer(inventory Inventory, store SecretStore, serverID string) error {
+	if store != nil {
+		if err := store.Delete(sshKeySecretName(serverID)); err != nil {
+			return fmt.Errorf("failed to delete SSH key of server %s: %w", serverID, err)
+		}
+	}
+	if inventory != nil {
+		return inventory.Delete(serverID)
+	}
+	return nil
+}
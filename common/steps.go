@@ -0,0 +1,157 @@
+package common
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// InstallStateDir is where completed install steps are recorded on the server, one file per step and
+// version of its inputs.
+const InstallStateDir = "/opt/lantern/install"
+
+// installAttempts is how often the install steps are run when the SSH connection is lost.
+const installAttempts = 3
+
+// InstallResumer is implemented by provisioners that can finish installing a server whose
+// installation failed, e.g. because the SSH connection dropped. Provisioned servers are added to the
+// inventory with ServerStatusInstalling before they are installed, and EventTypeProvisioningError
+// carries the Server, so its ID is known. ResumeInstall continues with the first step that didn't
+// complete on the same server. Like Provision it is non-blocking and reports its progress with
+// EventTypeProvisioningStarted, EventTypeProvisioningCompleted or EventTypeProvisioningError.
+type InstallResumer interface {
+	ResumeInstall(ctx context.Context, id string)
+}
+
+// installStep is a named, idempotent part of installing a server.
+// A step that completed is recorded in InstallStateDir and skipped when the installation is run again
+// with the same inputs.
+type installStep struct {
+	name   string
+	check  string          // Succeeds if the step's effect is already in place, so apply can be skipped; optional
+	apply  []remoteCommand // Safe to run again if a previous run was interrupted
+	verify string          // Succeeds if apply took effect; optional
+}
+
+// key returns what the step is recorded as: its name and a hash of its commands, which hold everything
+// the profile puts into it. A step whose inputs changed since it completed, e.g. because the installation
+// is resumed with another profile, runs again.
+func (s installStep) key() string {
+	inputs := []string{s.check, s.verify}
+	for _, c := range s.apply {
+		inputs = append(inputs, c.cmd)
+	}
+	h := sha256.New()
+	for _, input := range inputs {
+		h.Write([]byte(input))
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%s-%x", s.name, h.Sum(nil)[:8])
+}
+
+// runInstallSteps runs the steps that didn't complete yet. If the SSH connection is lost, it connects
+// again and continues with the step that was interrupted.
+func runInstallSteps(ctx context.Context, ip string, ports []int, privateSSHKey, username string, steps []installStep, log LogFunc) (*ssh.Client, error) {
+	for attempt := 1; ; attempt++ {
+		client, err := establishSSH(ip, ports, privateSSHKey, username)
+		if err != nil {
+			return nil, fmt.Errorf("failed to establish SSH connection: %w", err)
+		}
+		err = runSteps(ctx, client, steps, log)
+		if err == nil {
+			return client, nil
+		}
+		client.Close()
+		if attempt >= installAttempts || !isConnectionError(err) || ctx.Err() != nil {
+			return nil, err
+		}
+		slog.Warn("SSH connection lost during installation, reconnecting", "ip", ip, "attempt", attempt, "err", err)
+	}
+}
+
+func runSteps(ctx context.Context, client *ssh.Client, steps []installStep, log LogFunc) error {
+	completed, err := completedSteps(ctx, client)
+	if err != nil {
+		return err
+	}
+	for _, step := range steps {
+		if completed[step.key()] {
+			slog.Debug("Install step already completed", "step", step.name)
+			continue
+		}
+		if err := runStep(ctx, client, step, log); err != nil {
+			return fmt.Errorf("install step %s failed: %w", step.name, err)
+		}
+	}
+	return nil
+}
+
+func runStep(ctx context.Context, client *ssh.Client, step installStep, log LogFunc) error {
+	if log != nil {
+		log("== " + step.name)
+	}
+	if step.check != "" {
+		if _, err := runSSHCommand(ctx, client, step.check, 0, nil); err == nil {
+			slog.Debug("Install step already in place", "step", step.name)
+			return markStepCompleted(ctx, client, step)
+		} else if !isExitError(err) {
+			return err
+		}
+	}
+	for _, c := range step.apply {
+		if log != nil {
+			log("$ " + c.cmd)
+		}
+		if _, err := runSSHCommand(ctx, client, c.cmd, c.timeout, log); err != nil {
+			return err
+		}
+	}
+	if step.verify != "" {
+		if _, err := runSSHCommand(ctx, client, step.verify, 0, log); err != nil {
+			return fmt.Errorf("step didn't take effect: %w", err)
+		}
+	}
+	return markStepCompleted(ctx, client, step)
+}
+
+// completedSteps returns the keys of the steps recorded in InstallStateDir.
+func completedSteps(ctx context.Context, client *ssh.Client) (map[string]bool, error) {
+	output, err := runSSHCommand(ctx, client, "ls "+InstallStateDir+" 2>/dev/null || true", 0, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read install state: %w", err)
+	}
+	completed := make(map[string]bool)
+	for _, name := range strings.Fields(string(output)) {
+		if step, ok := strings.CutSuffix(name, ".done"); ok {
+			completed[step] = true
+		}
+	}
+	return completed, nil
+}
+
+func markStepCompleted(ctx context.Context, client *ssh.Client, step installStep) error {
+	cmd := fmt.Sprintf("sudo mkdir -p %s && sudo touch %s/%s.done", InstallStateDir, InstallStateDir, step.key())
+	if _, err := runSSHCommand(ctx, client, cmd, 0, nil); err != nil {
+		return fmt.Errorf("failed to record install step %s: %w", step.name, err)
+	}
+	return nil
+}
+
+// isExitError reports whether err is a command that ran and failed, as opposed to a lost connection.
+func isExitError(err error) bool {
+	var exitErr *ssh.ExitError
+	return errors.As(err, &exitErr)
+}
+
+// isConnectionError reports whether a failed install step is worth retrying on a new connection.
+func isConnectionError(err error) bool {
+	return !isExitError(err) &&
+		!errors.Is(err, ErrCommandTimeout) &&
+		!errors.Is(err, context.Canceled) &&
+		!errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,150 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// AutomaticUpdates configures unattended-upgrades to install security updates on the server.
+type AutomaticUpdates struct {
+	// IncludeLantern also installs new versions from the Lantern apt source. Pinned packages are held either way.
+	IncludeLantern bool `json:"include_lantern,omitempty"`
+	// RebootTime is the time of day, in the server's time zone, e.g. "04:00", at which the server reboots
+	// if an update requires it. The server is never rebooted automatically if it is empty.
+	RebootTime string `json:"reboot_time,omitempty"`
+}
+
+const unattendedUpgradesFile = "/etc/apt/apt.conf.d/52lantern-unattended-upgrades"
+
+// automaticUpdatesStep returns the step that configures AutomaticUpdates.
+func automaticUpdatesStep(pm PackageManager, updates AutomaticUpdates, source AptSource) installStep {
+	config := []string{
+		"// Written by lantern-server-provisioner",
+		`APT::Periodic::Update-Package-Lists "1";`,
+		`APT::Periodic::Unattended-Upgrade "1";`,
+	}
+	if updates.RebootTime != "" {
+		config = append(config,
+			`Unattended-Upgrade::Automatic-Reboot "true";`,
+			fmt.Sprintf(`Unattended-Upgrade::Automatic-Reboot-Time "%s";`, updates.RebootTime),
+		)
+	} else {
+		config = append(config, `Unattended-Upgrade::Automatic-Reboot "false";`)
+	}
+	if updates.IncludeLantern {
+		if u, err := url.Parse(source.URL); err == nil && u.Host != "" {
+			config = append(config, fmt.Sprintf(`Unattended-Upgrade::Origins-Pattern { "site=%s"; };`, u.Host))
+		}
+	}
+	config = append(config, "")
+	return installStep{
+		name: "automatic-updates",
+		apply: []remoteCommand{
+			{cmd: pm.Install([]string{"unattended-upgrades"}), timeout: 20 * time.Minute},
+			{cmd: fmt.Sprintf("printf '%%s' %s | sudo tee %s", shellQuote(strings.Join(config, "\n")), unattendedUpgradesFile)},
+			{cmd: "sudo systemctl enable --now apt-daily.timer apt-daily-upgrade.timer"},
+		},
+		verify: `systemctl is-enabled --quiet apt-daily-upgrade.timer && apt-config dump | grep -qF 'APT::Periodic::Unattended-Upgrade "1";'`,
+	}
+}
+
+// UpdateStatus is the state of a server's package updates.
+type UpdateStatus struct {
+	ServerID        string   `json:"server_id"`
+	PendingUpdates  []string `json:"pending_updates,omitempty"`  // Packages with a newer version available
+	SecurityUpdates []string `json:"security_updates,omitempty"` // Subset of PendingUpdates from a security suite
+	RebootRequired  bool     `json:"reboot_required"`
+	RebootPackages  []string `json:"reboot_packages,omitempty"` // Packages that require the reboot, if known
+	Error           string   `json:"error,omitempty"`           // Set if the server couldn't be checked
+}
+
+func (s UpdateStatus) Encode() string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+// UpdateChecker is implemented by provisioners that can report the package updates of their servers.
+// CheckUpdates checks every active server of the provider in the inventory over SSH. Like other
+// provisioner operations it is non-blocking; the result is reported with EventTypeUpdateCheckCompleted,
+// with one status per server in Event.Updates, or EventTypeUpdateCheckError. A server that can't be
+// reached doesn't fail the check, its status has Error set instead.
+type UpdateChecker interface {
+	CheckUpdates(ctx context.Context)
+}
+
+// CheckUpdates reports the pending updates of a server and whether it needs a reboot, using its stored SSH key.
+// Package lists are refreshed by the server itself, see AutomaticUpdates, so this doesn't change the server.
+func CheckUpdates(ctx context.Context, record InventoryRecord, store SecretStore) (*UpdateStatus, error) {
+	client, err := DialServer(record, store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to establish SSH connection: %w", err)
+	}
+	defer client.Close()
+
+	status := &UpdateStatus{ServerID: record.Server.ID}
+	output, err := runSSHCommand(ctx, client, "apt list --upgradable 2> /dev/null", 0, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list updates: %w", err)
+	}
+	status.PendingUpdates, status.SecurityUpdates = parseUpgradable(string(output))
+
+	output, err = runSSHCommand(ctx, client, "if [ -f /var/run/reboot-required ]; then echo yes; cat /var/run/reboot-required.pkgs 2> /dev/null; fi", 0, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check for a required reboot: %w", err)
+	}
+	lines := strings.Fields(string(output))
+	if len(lines) > 0 && lines[0] == "yes" {
+		status.RebootRequired = true
+		status.RebootPackages = lines[1:]
+	}
+	return status, nil
+}
+
+// CheckServerUpdates checks the updates of every active server of provider in the inventory.
+func CheckServerUpdates(ctx context.Context, inventory Inventory, store SecretStore, provider string) ([]UpdateStatus, error) {
+	if inventory == nil {
+		return nil, errors.New("no inventory configured")
+	}
+	records, err := inventory.List()
+	if err != nil {
+		return nil, err
+	}
+	var statuses []UpdateStatus
+	for _, record := range records {
+		if record.Server.Provider != provider || record.Status != ServerStatusActive {
+			continue
+		}
+		status, err := CheckUpdates(ctx, record, store)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			status = &UpdateStatus{ServerID: record.Server.ID, Error: err.Error()}
+		}
+		statuses = append(statuses, *status)
+	}
+	return statuses, nil
+}
+
+// parseUpgradable parses the output of "apt list --upgradable", e.g.
+// "openssl/jammy-updates,jammy-security 3.0.2-0ubuntu1.19 amd64 [upgradable from: 3.0.2-0ubuntu1.18]".
+func parseUpgradable(output string) (pending, security []string) {
+	for _, line := range strings.Split(output, "\n") {
+		name, rest, ok := strings.Cut(strings.TrimSpace(line), "/")
+		if !ok || !strings.Contains(rest, "upgradable from") {
+			// "Listing..." header
+			continue
+		}
+		pending = append(pending, name)
+		suites, _, _ := strings.Cut(rest, " ")
+		if strings.Contains(suites, "-security") {
+			security = append(security, name)
+		}
+	}
+	return pending, security
+}
@@ -0,0 +1,209 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// LanternPackages are the packages that make up a Lantern server.
+var LanternPackages = []string{"lantern-server-manager", "sing-box-extensions"}
+
+const (
+	serviceHealthTimeout      = 2 * time.Minute
+	serviceHealthPollInterval = 3 * time.Second
+)
+
+// UpgradeResult describes what changed when a server was upgraded.
+type UpgradeResult struct {
+	Server         Server              `json:"server"`          // Server with the configuration read back after the upgrade
+	Previous       ServerConfiguration `json:"previous"`        // Configuration before the upgrade
+	BeforeVersions map[string]string   `json:"before_versions"` // Installed package versions before the upgrade
+	AfterVersions  map[string]string   `json:"after_versions"`  // Installed package versions after the upgrade
+}
+
+// UpgradedPackages returns the packages whose version changed.
+func (r *UpgradeResult) UpgradedPackages() []string {
+	var res []string
+	for pkg, after := range r.AfterVersions {
+		if r.BeforeVersions[pkg] != after {
+			res = append(res, pkg)
+		}
+	}
+	slices.Sort(res)
+	return res
+}
+
+// PortChanged reports whether lantern-server-manager now listens on another port.
+func (r *UpgradeResult) PortChanged() bool {
+	return r.Previous.Port != r.Server.Config.Port
+}
+
+// AccessTokenChanged reports whether the lantern-server-manager access token changed.
+func (r *UpgradeResult) AccessTokenChanged() bool {
+	return r.Previous.AccessToken != r.Server.Config.AccessToken
+}
+
+// FirewallChanged reports whether the ports that must be reachable changed, so the cloud firewall must be updated.
+func (r *UpgradeResult) FirewallChanged() bool {
+	return !slices.Equal(r.Previous.FirewallPorts(), r.Server.Config.FirewallPorts())
+}
+
+// Summary describes the changes in a human-readable form.
+func (r *UpgradeResult) Summary() string {
+	var changes []string
+	for _, pkg := range slices.Sorted(maps.Keys(r.AfterVersions)) {
+		before, after := r.BeforeVersions[pkg], r.AfterVersions[pkg]
+		if before == after {
+			changes = append(changes, fmt.Sprintf("%s %s is up to date", pkg, after))
+		} else {
+			changes = append(changes, fmt.Sprintf("%s upgraded from %s to %s", pkg, before, after))
+		}
+	}
+	if r.PortChanged() {
+		changes = append(changes, fmt.Sprintf("port changed from %d to %d", r.Previous.Port, r.Server.Config.Port))
+	}
+	if r.AccessTokenChanged() {
+		changes = append(changes, "access token changed")
+	}
+	return strings.Join(changes, "; ")
+}
+
+func (r *UpgradeResult) Encode() string {
+	data, _ := json.Marshal(r)
+	return string(data)
+}
+
+// Upgrader is implemented by provisioners that can upgrade the Lantern packages of a server in the inventory.
+// Like other provisioner operations it is non-blocking; progress is reported with EventTypeUpgradeStarted,
+// EventTypeUpgradeCompleted (with the updated Server and the UpgradeResult encoded in Message) or
+// EventTypeUpgradeError. The cloud firewall is updated if the server's ports changed.
+type Upgrader interface {
+	UpgradeServer(ctx context.Context, id string)
+}
+
+// UpgradeServer upgrades the Lantern packages of a server over SSH, using its stored SSH key.
+// Packages pinned by the server's install profile are left at their version.
+// It restarts the services, waits for them to become active and reads the server's configuration back.
+// The public addresses and SSH port are kept from the record, as the server may not know its reserved IP.
+// Each line of output of the upgrade commands is passed to log, which may be nil.
+func UpgradeServer(ctx context.Context, record InventoryRecord, store SecretStore, progress ProgressFunc, log LogFunc) (*UpgradeResult, error) {
+	if progress == nil {
+		progress = func(string) {}
+	}
+	recipe, err := Recipe(record.Server.OS)
+	if err != nil {
+		return nil, err
+	}
+	progress(fmt.Sprintf("Connecting to %s", record.Server.Config.ExternalIp))
+	client, err := DialServer(record, store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to establish SSH connection: %w", err)
+	}
+	defer client.Close()
+
+	result := &UpgradeResult{Previous: record.Server.Config}
+	if result.BeforeVersions, err = packageVersions(ctx, client, LanternPackages); err != nil {
+		return nil, err
+	}
+
+	packages := record.InstallProfile().Unpinned(LanternPackages)
+	if len(packages) == 0 {
+		return nil, errors.New("all Lantern packages are pinned, change the install profile to upgrade them")
+	}
+	progress("Upgrading " + strings.Join(packages, ", "))
+	commands := []remoteCommand{
+		{cmd: recipe.PackageManager.Refresh()},
+		{cmd: recipe.PackageManager.Upgrade(packages), timeout: 20 * time.Minute},
+		{cmd: "sudo systemctl restart " + strings.Join(LanternPackages, " ")},
+	}
+	for _, c := range commands {
+		if log != nil {
+			log("$ " + c.cmd)
+		}
+		if _, err := runSSHCommand(ctx, client, c.cmd, c.timeout, log); err != nil {
+			return nil, err
+		}
+	}
+
+	progress("Waiting for the services to start")
+	if err := waitForServices(ctx, client, LanternPackages); err != nil {
+		return nil, err
+	}
+
+	config, err := readServerConfiguration(ctx, client, recipe.Firewall)
+	if err != nil {
+		return nil, err
+	}
+	config.ExternalIp = record.Server.Config.ExternalIp
+	config.ExternalIpv6 = record.Server.Config.ExternalIpv6
+	config.SSHPort = record.Server.Config.SSHPort
+	result.Server = record.Server
+	result.Server.Config = *config
+
+	if result.AfterVersions, err = packageVersions(ctx, client, LanternPackages); err != nil {
+		return nil, err
+	}
+	progress(result.Summary())
+	return result, nil
+}
+
+// packageVersions returns the installed versions of packages, omitting packages that aren't installed.
+func packageVersions(ctx context.Context, client *ssh.Client, packages []string) (map[string]string, error) {
+	// dpkg-query fails if any package is unknown, but still prints the others
+	cmd := "dpkg-query -W -f='${Package} ${Version}\\n' " + strings.Join(packages, " ") + " 2>/dev/null || true"
+	output, err := runSSHCommand(ctx, client, cmd, 0, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get package versions: %w", err)
+	}
+	versions := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if pkg, version, ok := strings.Cut(line, " "); ok && version != "" {
+			versions[pkg] = version
+		}
+	}
+	return versions, nil
+}
+
+// waitForServices waits until all services are active, failing if one of them fails or doesn't start in time.
+func waitForServices(ctx context.Context, client *ssh.Client, services []string) error {
+	deadline := time.Now().Add(serviceHealthTimeout)
+	for {
+		// is-active exits with an error unless all services are active, so read its output instead
+		output, err := runSSHCommand(ctx, client, "systemctl is-active "+strings.Join(services, " ")+" || true", time.Minute, nil)
+		if err != nil {
+			return fmt.Errorf("failed to check services: %w", err)
+		}
+		states := strings.Fields(string(output))
+		var pending []string
+		for i, service := range services {
+			state := "unknown"
+			if i < len(states) {
+				state = states[i]
+			}
+			switch state {
+			case "active":
+			case "failed":
+				return fmt.Errorf("service %s failed to start", service)
+			default:
+				pending = append(pending, service+" ("+state+")")
+			}
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("services did not become active within %s: %s", serviceHealthTimeout, strings.Join(pending, ", "))
+		}
+		if err := Sleep(ctx, serviceHealthPollInterval); err != nil {
+			return err
+		}
+	}
+}
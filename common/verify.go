@@ -0,0 +1,187 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrServerUnhealthy is matched by errors returned when a server fails its health verification.
+var ErrServerUnhealthy = errors.New("server is not healthy")
+
+// ManagerVerifyPath is the lantern-server-manager API endpoint requested to verify the access token.
+// Any authenticated endpoint works; it is requested with GET and must answer with a 2xx status.
+var ManagerVerifyPath = "/api/v1/connect-config"
+
+// VerifyRetryPolicy controls how long a freshly installed server is given to become healthy.
+var VerifyRetryPolicy = RetryPolicy{
+	MaxAttempts:     8,
+	InitialInterval: 2 * time.Second,
+	MaxInterval:     30 * time.Second,
+	Multiplier:      2,
+	Jitter:          0.2,
+	MaxElapsed:      3 * time.Minute,
+	Classify: func(err error) bool {
+		// A rejected token won't be accepted later, everything else may just need more time
+		return !errors.Is(err, ErrUnauthorized) && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	},
+}
+
+// HealthCheckError describes which health check a server failed.
+type HealthCheckError struct {
+	Check string // e.g. "manager API" or "sing-box listener"
+	Err   error
+}
+
+func (e *HealthCheckError) Error() string {
+	return fmt.Sprintf("%s: %s check failed: %v", ErrServerUnhealthy, e.Check, e.Err)
+}
+
+func (e *HealthCheckError) Is(target error) bool {
+	return target == ErrServerUnhealthy
+}
+
+func (e *HealthCheckError) Unwrap() error {
+	return e.Err
+}
+
+// VerifyServer checks that an installed server is usable from the outside: lantern-server-manager must
+// accept the access token at ExternalIp:Port, and sing-box must listen on the ports opened for it.
+// Checks are retried according to VerifyRetryPolicy. It must be called after the cloud firewall
+// has been opened for the server's ports. Errors match ErrServerUnhealthy.
+func VerifyServer(ctx context.Context, config ServerConfiguration, privateSSHKey, username string) error {
+	client, err := establishSSH(config.ExternalIp, config.sshPorts(), privateSSHKey, username)
+	if err != nil {
+		return &HealthCheckError{Check: "SSH", Err: err}
+	}
+	defer client.Close()
+	return verifyServer(ctx, config, client)
+}
+
+// VerifyRecordedServer is like VerifyServer for a server in the inventory, using its stored SSH key.
+func VerifyRecordedServer(ctx context.Context, record InventoryRecord, store SecretStore) error {
+	client, err := DialServer(record, store)
+	if err != nil {
+		return &HealthCheckError{Check: "SSH", Err: err}
+	}
+	defer client.Close()
+	return verifyServer(ctx, record.Server.Config, client)
+}
+
+func verifyServer(ctx context.Context, config ServerConfiguration, client *ssh.Client) error {
+	return VerifyRetryPolicy.Do(ctx, func(ctx context.Context) error {
+		if err := checkManager(ctx, config); err != nil {
+			return &HealthCheckError{Check: "manager API", Err: err}
+		}
+		if err := checkListeners(ctx, client, config); err != nil {
+			return &HealthCheckError{Check: "sing-box listener", Err: err}
+		}
+		slog.Debug("Server is healthy", "ip", config.ExternalIp, "port", config.Port)
+		return nil
+	})
+}
+
+// managerHTTPClient talks to lantern-server-manager, which uses a self-signed certificate.
+// The request is authenticated with the access token, so the certificate isn't relied on; the token is
+// only ever sent over TLS.
+var managerHTTPClient = &http.Client{
+	Timeout: 15 * time.Second,
+	Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	},
+}
+
+// checkManager requests ManagerVerifyPath over HTTPS with the access token in the Authorization header.
+// Errors never contain the token, they end up in logs and events.
+func checkManager(ctx context.Context, config ServerConfiguration) error {
+	if config.Port == 0 || config.AccessToken == "" {
+		return errors.New("server configuration has no port or access token")
+	}
+	host := net.JoinHostPort(config.ExternalIp, strconv.Itoa(config.Port))
+	return requestManager(ctx, host, config.AccessToken)
+}
+
+func requestManager(ctx context.Context, host, token string) error {
+	u := url.URL{Scheme: "https", Host: host, Path: ManagerVerifyPath}
+	endpoint := host + ManagerVerifyPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("invalid manager endpoint %s: %w", endpoint, err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := managerHTTPClient.Do(req)
+	if err != nil {
+		// The url.Error repeats the URL, only keep its cause
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("request to %s failed: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("access token rejected with status %d: %w", resp.StatusCode, ErrUnauthorized)
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, endpoint)
+	}
+	return nil
+}
+
+// checkListeners checks that something listens on each single port opened for the server's services.
+// Port ranges are skipped, they are usually redirected to a single listener.
+func checkListeners(ctx context.Context, client *ssh.Client, config ServerConfiguration) error {
+	listening := make(map[string]map[int]bool)
+	for _, protocol := range []string{"tcp", "udp"} {
+		flags := map[string]string{"tcp": "-Hlnt", "udp": "-Hlnu"}[protocol]
+		output, err := runSSHCommand(ctx, client, "ss "+flags, time.Minute, nil)
+		if err != nil {
+			return err
+		}
+		listening[protocol] = parseListeningPorts(string(output))
+	}
+	var missing []string
+	for _, p := range config.OpenPorts {
+		if p.To > p.From || p == SSHPort || (p.Protocol == "tcp" && p.From == config.Port) {
+			continue
+		}
+		if !listening[p.Protocol][p.From] {
+			missing = append(missing, p.String())
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("nothing is listening on %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// parseListeningPorts returns the local ports in ss output without a header,
+// e.g. "LISTEN 0 4096 0.0.0.0:443 0.0.0.0:*".
+func parseListeningPorts(output string) map[int]bool {
+	ports := make(map[int]bool)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		local := fields[3]
+		if i := strings.LastIndex(local, ":"); i >= 0 {
+			if port, err := strconv.Atoi(local[i+1:]); err == nil {
+				ports[port] = true
+			}
+		}
+	}
+	return ports
+}
@@ -0,0 +1,100 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const testAccessToken = "s3cr3t-access-token"
+
+func serverConfig(t *testing.T, addr string) ServerConfiguration {
+	t.Helper()
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ServerConfiguration{ExternalIp: host, Port: p, AccessToken: testAccessToken}
+}
+
+func TestCheckManagerSendsTokenInHeaderOnly(t *testing.T) {
+	var authorization, query string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization, query = r.Header.Get("Authorization"), r.URL.RawQuery
+	}))
+	defer srv.Close()
+
+	if err := checkManager(context.Background(), serverConfig(t, srv.Listener.Addr().String())); err != nil {
+		t.Fatalf("checkManager: %v", err)
+	}
+	if authorization != "Bearer "+testAccessToken {
+		t.Errorf("Authorization = %q", authorization)
+	}
+	if query != "" {
+		t.Errorf("query = %q, want none", query)
+	}
+}
+
+func TestCheckManagerErrorsDontContainToken(t *testing.T) {
+	failing := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	var plainRequests atomic.Int32
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		plainRequests.Add(1)
+	}))
+	defer plain.Close()
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	for name, addr := range map[string]string{
+		"unexpected status": failing.Listener.Addr().String(),
+		"plain HTTP":        plain.Listener.Addr().String(),
+		"connection error":  closedAddr,
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := checkManager(context.Background(), serverConfig(t, addr))
+			if err == nil {
+				t.Fatal("checkManager succeeded")
+			}
+			if strings.Contains(err.Error(), testAccessToken) {
+				t.Errorf("error contains the access token: %v", err)
+			}
+			if !strings.Contains(err.Error(), addr+ManagerVerifyPath) {
+				t.Errorf("error doesn't name the endpoint: %v", err)
+			}
+		})
+	}
+	if n := plainRequests.Load(); n != 0 {
+		t.Errorf("plain HTTP server got %d requests", n)
+	}
+}
+
+func TestCheckManagerRejectedToken(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	err := checkManager(context.Background(), serverConfig(t, srv.Listener.Addr().String()))
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("err = %v, want ErrUnauthorized", err)
+	}
+}
@@ -10,13 +10,27 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
+	"slices"
 	"time"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+const (
+	firewallName = "lantern-firewall" // Name of the Cloud Firewall shared by all Lantern droplets
+	lanternTag   = "lantern"          // Tag applied to Lantern droplets, used to attach the firewall
 )
 
+// DefaultRetryPolicy is the retry policy used by new APIClient instances.
+// DigitalOcean rate limits are per token (5000 requests/hour, 250/minute), so
+// rate-limited requests are retried after the RateLimit-Reset time.
+var DefaultRetryPolicy = common.DefaultRetryPolicy
+
 // APIClient implements DigitalOceanSession using the DigitalOcean REST API.
 type APIClient struct {
 	accessToken string
 	client      *http.Client
+	retryPolicy common.RetryPolicy
 }
 
 // NewRestApiSession creates a new APIClient with the given access token.
@@ -29,9 +43,15 @@ func NewRestApiSession(accessToken string, httpClient *http.Client) *APIClient {
 	return &APIClient{
 		accessToken: accessToken,
 		client:      client,
+		retryPolicy: DefaultRetryPolicy,
 	}
 }
 
+// SetRetryPolicy overrides the retry policy used for API requests.
+func (s *APIClient) SetRetryPolicy(policy common.RetryPolicy) {
+	s.retryPolicy = policy
+}
+
 // GetAccessToken returns the access token used by this session.
 func (s *APIClient) GetAccessToken() string {
 	return s.accessToken
@@ -64,59 +84,55 @@ func (s *APIClient) CreateDroplet(ctx context.Context, displayName, region, publ
 	return s.makeCreateDropletRequest(ctx, dropletName, region, keyID, dropletSpec)
 }
 
-// makeCreateDropletRequest makes the actual API request to create a droplet.
+// makeCreateDropletRequest makes the actual API request to create a droplet
+// and waits for it to become active.
 func (s *APIClient) makeCreateDropletRequest(ctx context.Context, dropletName, region string, keyID int, dropletSpec DropletSpecification) (*DropletInfo, error) {
-	maxRequests := 10
-	retryTimeoutMs := 5000
-	dropletID := 0
-	for requestCount := 0; requestCount < maxRequests; requestCount++ {
-		slog.Debug("Requesting droplet creation", "requestCount", requestCount, "maxRequests", maxRequests)
-
-		// See https://docs.digitalocean.com/reference/api/api-reference/#operation/droplets_create
-		data := map[string]interface{}{
-			"name":               dropletName,
-			"region":             region,
-			"size":               dropletSpec.Size,
-			"image":              dropletSpec.Image,
-			"ssh_keys":           []int{keyID},
-			"user_data":          dropletSpec.InstallCommand,
-			"tags":               dropletSpec.Tags,
-			"ipv6":               true,
-			"monitoring":         false,
-			"with_droplet_agent": false,
-		}
+	slog.Debug("Requesting droplet creation")
+
+	// See https://docs.digitalocean.com/reference/api/api-reference/#operation/droplets_create
+	data := map[string]interface{}{
+		"name":               dropletName,
+		"region":             region,
+		"size":               dropletSpec.Size,
+		"image":              dropletSpec.Image,
+		"ssh_keys":           []int{keyID},
+		"user_data":          dropletSpec.InstallCommand,
+		"tags":               dropletSpec.Tags,
+		"ipv6":               true,
+		"monitoring":         false,
+		"with_droplet_agent": false,
+	}
 
-		var response struct {
-			Droplet DropletInfo `json:"droplet"`
-		}
+	var response struct {
+		Droplet DropletInfo `json:"droplet"`
+	}
 
-		err := s.request(ctx, "POST", "droplets", data, &response)
-		if err == nil {
-			dropletID = response.Droplet.ID
-			break
-		}
-		slog.Error("Failed to create droplet", "error", err)
-		if requestCount == maxRequests-1 {
-			return nil, fmt.Errorf("failed to create droplet after %d attempts: %w", maxRequests, err)
-		} else {
-			time.Sleep(time.Duration(retryTimeoutMs) * time.Millisecond)
-		}
+	// request retries transient and rate limit errors; validation errors fail immediately.
+	if err := s.request(ctx, "POST", "droplets", data, &response); err != nil {
+		return nil, fmt.Errorf("failed to create droplet: %w", err)
 	}
+	dropletID := response.Droplet.ID
 	slog.Debug("Droplet creation request sent", "dropletID", dropletID)
 
+	maxRequests := 30
+	pollInterval := 5 * time.Second
 	for requestCount := 0; requestCount < maxRequests; requestCount++ {
 		slog.Debug("Requesting droplet state", "requestCount", requestCount, "maxRequests", maxRequests)
 
 		droplet, err := s.GetDroplet(ctx, dropletID)
 		if err != nil {
+			if !common.IsRetriable(err) {
+				return nil, fmt.Errorf("failed to get droplet %d: %w", dropletID, err)
+			}
 			slog.Error("Failed to get droplet", "error", err)
-			time.Sleep(time.Duration(retryTimeoutMs) * time.Millisecond)
 		} else {
 			slog.Debug("Droplet state", "status", droplet.Status)
 			if droplet.Status == "active" {
 				return droplet, nil
 			}
-			time.Sleep(time.Duration(retryTimeoutMs) * time.Millisecond)
+		}
+		if err := common.Sleep(ctx, pollInterval); err != nil {
+			return nil, err
 		}
 	}
 	return nil, fmt.Errorf("droplet didn't become active after %d attempts", maxRequests)
@@ -141,6 +157,19 @@ func (s *APIClient) GetRegionInfo(ctx context.Context) ([]RegionInfo, error) {
 	return response.Regions, nil
 }
 
+// GetDistributionImages retrieves the public base images of Linux distributions.
+func (s *APIClient) GetDistributionImages(ctx context.Context) ([]ImageInfo, error) {
+	slog.Debug("Requesting distribution images")
+	var response struct {
+		Images []ImageInfo `json:"images"`
+	}
+	err := s.request(ctx, "GET", "images?type=distribution&per_page=200", nil, &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Images, nil
+}
+
 // getSSHKeyID checks if an SSH key is already registered with DigitalOcean.
 // It returns the key ID if it exists, or 0 if it doesn't.
 func (s *APIClient) getSSHKeyID(ctx context.Context, publicSSHKey string) int {
@@ -250,17 +279,196 @@ func (s *APIClient) GetDroplets(ctx context.Context, tag string, name string) ([
 	return response.Droplets, nil
 }
 
-// request makes an HTTP request to the DigitalOcean API.
-func (s *APIClient) request(ctx context.Context, method, actionPath string, data interface{}, response interface{}) error {
-	apiURL := fmt.Sprintf("https://api.digitalocean.com/v2/%s", actionPath)
+// CreateFirewall creates a new Cloud Firewall.
+func (s *APIClient) CreateFirewall(ctx context.Context, firewall Firewall) (*Firewall, error) {
+	slog.Debug("Requesting firewall creation", "name", firewall.Name)
+	var response struct {
+		Firewall Firewall `json:"firewall"`
+	}
+	err := s.request(ctx, "POST", "firewalls", firewall, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response.Firewall, nil
+}
 
-	var reqBody io.Reader
-	if data != nil {
-		jsonData, err := json.Marshal(data)
+// GetFirewall retrieves a Cloud Firewall by ID.
+func (s *APIClient) GetFirewall(ctx context.Context, firewallID string) (*Firewall, error) {
+	slog.Debug("Requesting firewall", "id", firewallID)
+	var response struct {
+		Firewall Firewall `json:"firewall"`
+	}
+	err := s.request(ctx, "GET", "firewalls/"+url.PathEscape(firewallID), nil, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response.Firewall, nil
+}
+
+// GetFirewalls retrieves the account's Cloud Firewalls.
+func (s *APIClient) GetFirewalls(ctx context.Context) ([]Firewall, error) {
+	slog.Debug("Requesting firewalls")
+	var response struct {
+		Firewalls []Firewall `json:"firewalls"`
+	}
+	err := s.request(ctx, "GET", "firewalls?per_page=200", nil, &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Firewalls, nil
+}
+
+// GetFirewallByName returns the Cloud Firewall with the given name, or nil if there is none.
+func (s *APIClient) GetFirewallByName(ctx context.Context, name string) (*Firewall, error) {
+	firewalls, err := s.GetFirewalls(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, fw := range firewalls {
+		if fw.Name == name {
+			return &fw, nil
+		}
+	}
+	return nil, nil
+}
+
+// UpdateFirewall replaces the configuration of a Cloud Firewall.
+// The full firewall, including name, rules, droplets and tags, must be provided.
+func (s *APIClient) UpdateFirewall(ctx context.Context, firewallID string, firewall Firewall) (*Firewall, error) {
+	slog.Debug("Requesting firewall update", "id", firewallID)
+	var response struct {
+		Firewall Firewall `json:"firewall"`
+	}
+	err := s.request(ctx, "PUT", "firewalls/"+url.PathEscape(firewallID), firewall, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response.Firewall, nil
+}
+
+// AddTagsToFirewall applies a Cloud Firewall to all droplets with the given tags.
+func (s *APIClient) AddTagsToFirewall(ctx context.Context, firewallID string, tags []string) error {
+	slog.Debug("Requesting firewall tags", "id", firewallID, "tags", tags)
+	data := map[string][]string{"tags": tags}
+	return s.request(ctx, "POST", fmt.Sprintf("firewalls/%s/tags", url.PathEscape(firewallID)), data, nil)
+}
+
+// CreateReservedIP reserves a new IP address in the given region.
+func (s *APIClient) CreateReservedIP(ctx context.Context, region string) (*ReservedIP, error) {
+	slog.Debug("Requesting reserved IP", "region", region)
+	var response struct {
+		ReservedIP ReservedIP `json:"reserved_ip"`
+	}
+	err := s.request(ctx, "POST", "reserved_ips", map[string]string{"region": region}, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response.ReservedIP, nil
+}
+
+// GetReservedIP retrieves a reserved IP address.
+func (s *APIClient) GetReservedIP(ctx context.Context, ip string) (*ReservedIP, error) {
+	slog.Debug("Requesting reserved IP info", "ip", ip)
+	var response struct {
+		ReservedIP ReservedIP `json:"reserved_ip"`
+	}
+	err := s.request(ctx, "GET", "reserved_ips/"+url.PathEscape(ip), nil, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response.ReservedIP, nil
+}
+
+// DeleteReservedIP releases a reserved IP address. It must not be assigned to a droplet.
+func (s *APIClient) DeleteReservedIP(ctx context.Context, ip string) error {
+	slog.Debug("Requesting reserved IP deletion", "ip", ip)
+	return s.request(ctx, "DELETE", "reserved_ips/"+url.PathEscape(ip), nil, nil)
+}
+
+// AssignReservedIP assigns a reserved IP address to a droplet and waits for the action to complete.
+// An IP that is already assigned to another droplet is moved.
+func (s *APIClient) AssignReservedIP(ctx context.Context, ip string, dropletID int) error {
+	slog.Debug("Requesting reserved IP assignment", "ip", ip, "dropletID", dropletID)
+	data := map[string]interface{}{"type": "assign", "droplet_id": dropletID}
+	return s.reservedIPAction(ctx, ip, data)
+}
+
+// UnassignReservedIP unassigns a reserved IP address from its droplet and waits for the action to complete.
+func (s *APIClient) UnassignReservedIP(ctx context.Context, ip string) error {
+	slog.Debug("Requesting reserved IP unassignment", "ip", ip)
+	return s.reservedIPAction(ctx, ip, map[string]interface{}{"type": "unassign"})
+}
+
+// reservedIPAction starts an action on a reserved IP address and waits for it to complete.
+func (s *APIClient) reservedIPAction(ctx context.Context, ip string, data map[string]interface{}) error {
+	var response struct {
+		Action Action `json:"action"`
+	}
+	err := s.request(ctx, "POST", fmt.Sprintf("reserved_ips/%s/actions", url.PathEscape(ip)), data, &response)
+	if err != nil {
+		return err
+	}
+	_, err = s.WaitForAction(ctx, response.Action.ID)
+	return err
+}
+
+// GetAction retrieves the status of an action.
+func (s *APIClient) GetAction(ctx context.Context, actionID int) (*Action, error) {
+	var response struct {
+		Action Action `json:"action"`
+	}
+	err := s.request(ctx, "GET", fmt.Sprintf("actions/%d", actionID), nil, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response.Action, nil
+}
+
+// WaitForAction polls an action until it is completed or errored.
+func (s *APIClient) WaitForAction(ctx context.Context, actionID int) (*Action, error) {
+	maxRequests := 60
+	pollInterval := 2 * time.Second
+	for requestCount := 0; requestCount < maxRequests; requestCount++ {
+		action, err := s.GetAction(ctx, actionID)
 		if err != nil {
+			return nil, fmt.Errorf("failed to get action %d: %w", actionID, err)
+		}
+		slog.Debug("Action state", "id", actionID, "type", action.Type, "status", action.Status)
+		switch action.Status {
+		case "completed":
+			return action, nil
+		case "errored":
+			return action, fmt.Errorf("action %d (%s) errored", actionID, action.Type)
+		}
+		if err := common.Sleep(ctx, pollInterval); err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("action %d didn't complete after %d attempts", actionID, maxRequests)
+}
+
+// request makes an HTTP request to the DigitalOcean API, retrying it according to the client's retry policy
+// as adjusted for the method by common.RetryPolicy.ForMethod.
+func (s *APIClient) request(ctx context.Context, method, actionPath string, data interface{}, response interface{}) error {
+	var jsonData []byte
+	if data != nil {
+		var err error
+		if jsonData, err = json.Marshal(data); err != nil {
 			return err
 		}
-		reqBody = bytes.NewBuffer(jsonData)
+	}
+	return s.retryPolicy.ForMethod(method).Do(ctx, func(ctx context.Context) error {
+		return s.doRequest(ctx, method, actionPath, jsonData, response)
+	})
+}
+
+// doRequest performs a single HTTP request to the DigitalOcean API.
+func (s *APIClient) doRequest(ctx context.Context, method, actionPath string, jsonData []byte, response interface{}) error {
+	apiURL := fmt.Sprintf("https://api.digitalocean.com/v2/%s", actionPath)
+
+	var reqBody io.Reader
+	if jsonData != nil {
+		reqBody = bytes.NewReader(jsonData)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, method, apiURL, reqBody)
@@ -274,7 +482,11 @@ func (s *APIClient) request(ctx context.Context, method, actionPath string, data
 	resp, err := s.client.Do(req)
 	if err != nil {
 		slog.Debug("Failed to perform DigitalOcean request")
-		return fmt.Errorf("error performing DigitalOcean request: %w", err)
+		err = fmt.Errorf("error performing DigitalOcean request: %w", err)
+		if common.IsRetriable(err) {
+			return &common.ProviderError{Provider: providerName, Category: common.ErrTransient, Err: err}
+		}
+		return err
 	}
 	defer resp.Body.Close()
 
@@ -293,9 +505,11 @@ func (s *APIClient) request(ctx context.Context, method, actionPath string, data
 			}
 		}
 		return nil
-	} else if resp.StatusCode == 401 {
-		slog.Debug("DigitalOcean request failed with Unauthorized error")
-		return fmt.Errorf("DigitalOcean request failed with Unauthorized error")
+	}
+
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		retryAfter: common.ParseRetryAfter(resp.Header, time.Now()),
 	}
 
 	var responseJSON struct {
@@ -304,11 +518,128 @@ func (s *APIClient) request(ctx context.Context, method, actionPath string, data
 	}
 
 	if err = json.NewDecoder(resp.Body).Decode(&responseJSON); err != nil {
-		return fmt.Errorf("error parsing response body: %v", err)
+		// Gateways may return non-JSON bodies for 5xx errors; keep the status so the request can be retried.
+		apiErr.Message = fmt.Sprintf("error parsing response body: %v", err)
+		return toProviderError(apiErr)
 	}
+	apiErr.ID = responseJSON.ID
+	apiErr.Message = responseJSON.Message
 
 	slog.Debug("DigitalOcean request failed", "status", resp.StatusCode)
-	return fmt.Errorf("fetch %s failed with %d: %s", responseJSON.ID, resp.StatusCode, responseJSON.Message)
+	return toProviderError(apiErr)
+}
+
+// CreateFirewallIfNeeded makes sure the lantern Cloud Firewall allows inbound traffic to the given ports
+// from anywhere, over both IPv4 and IPv6, and applies to all droplets tagged with the Lantern tag. The ports
+// include SSH's, e.g. InstallProfile.SSHPort(), so a hardened server's port 22 isn't opened.
+// The firewall is created if it doesn't exist. An existing one is updated in place: rules are added for the
+// requested ports it doesn't allow yet. It is shared by all Lantern droplets, so its rules are left as they
+// are, including over-broad ones allowing all ports or other protocols. This mirrors gcp.CreateFirewallIfNeeded.
+func CreateFirewallIfNeeded(ctx context.Context, client *APIClient, ports []common.PortRange) error {
+	existing, err := client.GetFirewallByName(ctx, firewallName)
+	if err != nil {
+		return fmt.Errorf("failed to get firewall: %w", err)
+	}
+	if existing != nil {
+		if _, broad := firewallRulePorts(existing.InboundRules); len(broad) > 0 {
+			slog.Warn("Firewall allows more than specific ports, leaving those rules in place", "firewallName", firewallName, "rules", len(broad))
+		}
+		inbound, changed := mergeInboundRules(existing.InboundRules, ports)
+		if !changed && slices.Contains(existing.Tags, lanternTag) {
+			slog.Info("Firewall already up to date", "firewallName", firewallName)
+			return nil
+		}
+		slog.Info("Updating firewall", "firewallName", firewallName, "ports", ports)
+		update := *existing
+		update.InboundRules = inbound
+		if !slices.Contains(update.Tags, lanternTag) {
+			update.Tags = append(update.Tags, lanternTag)
+		}
+		if len(update.OutboundRules) == 0 {
+			update.OutboundRules = firewallOutboundRules()
+		}
+		if _, err := client.UpdateFirewall(ctx, existing.ID, update); err != nil {
+			return fmt.Errorf("failed to update firewall: %w", err)
+		}
+		return nil
+	}
+
+	fw, err := client.CreateFirewall(ctx, Firewall{
+		Name:          firewallName,
+		InboundRules:  firewallInboundRules(ports),
+		OutboundRules: firewallOutboundRules(),
+		Tags:          []string{lanternTag},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create firewall: %w", err)
+	}
+	slog.Info("Firewall created successfully", "firewallID", fw.ID, "ports", ports)
+	return nil
+}
+
+// firewallInboundRules returns one inbound rule per port range, open to all IPv4 and IPv6 addresses.
+func firewallInboundRules(ports []common.PortRange) []FirewallRule {
+	var rules []FirewallRule
+	for _, p := range common.MergePorts(ports) {
+		rules = append(rules, FirewallRule{
+			Protocol: p.Protocol,
+			Ports:    p.Ports(),
+			Sources:  &FirewallTargets{Addresses: []string{common.AnyIPv4, common.AnyIPv6}},
+		})
+	}
+	return rules
+}
+
+// firewallOutboundRules allows all outbound traffic. Without outbound rules DigitalOcean blocks it all.
+func firewallOutboundRules() []FirewallRule {
+	anywhere := &FirewallTargets{Addresses: []string{common.AnyIPv4, common.AnyIPv6}}
+	return []FirewallRule{
+		{Protocol: "tcp", Ports: "0", Destinations: anywhere},
+		{Protocol: "udp", Ports: "0", Destinations: anywhere},
+		{Protocol: "icmp", Destinations: anywhere},
+	}
+}
+
+// firewallRulePorts converts inbound rules back to ports. Rules that can't be expressed as ports are
+// returned as broad: those allowing all ports, or a protocol other than TCP and UDP.
+func firewallRulePorts(rules []FirewallRule) (ports []common.PortRange, broad []FirewallRule) {
+	for _, rule := range rules {
+		if rule.Protocol != "tcp" && rule.Protocol != "udp" {
+			broad = append(broad, rule)
+			continue
+		}
+		port, err := common.ParsePortRange(rule.Ports + "/" + rule.Protocol)
+		if err != nil {
+			// "0", "all" or empty mean all ports
+			broad = append(broad, rule)
+			continue
+		}
+		ports = append(ports, port)
+	}
+	return common.MergePorts(ports), broad
+}
+
+// mergeInboundRules adds rules for the ports the inbound rules of the shared firewall don't allow yet.
+// Existing rules are kept as they are, other droplets may rely on them. changed is false if the rules
+// already allow all ports.
+func mergeInboundRules(existing []FirewallRule, ports []common.PortRange) (rules []FirewallRule, changed bool) {
+	current, broad := firewallRulePorts(existing)
+	allowed := func(p common.PortRange) bool {
+		return slices.ContainsFunc(current, func(c common.PortRange) bool { return c.Contains(p) }) ||
+			slices.ContainsFunc(broad, func(r FirewallRule) bool {
+				return r.Protocol == p.Protocol // A TCP or UDP rule is only broad if it allows all ports
+			})
+	}
+	var missing []common.PortRange
+	for _, p := range ports {
+		if !allowed(p) {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) == 0 {
+		return existing, false
+	}
+	return append(slices.Clone(existing), firewallInboundRules(missing)...), true
 }
 
 // makeValidDropletName removes invalid characters from input name so it can be used with
@@ -0,0 +1,104 @@
+package digitalocean
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+func inbound(protocol, ports string) FirewallRule {
+	return FirewallRule{Protocol: protocol, Ports: ports, Sources: &FirewallTargets{Addresses: []string{common.AnyIPv4, common.AnyIPv6}}}
+}
+
+func TestFirewallRulePorts(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []FirewallRule
+		ports []common.PortRange
+		broad []FirewallRule
+	}{
+		{
+			name:  "specific ports",
+			rules: []FirewallRule{inbound("tcp", "443"), inbound("tcp", "22"), inbound("udp", "30000-30100")},
+			ports: []common.PortRange{common.Port("tcp", 22), common.Port("tcp", 443), {Protocol: "udp", From: 30000, To: 30100}},
+		},
+		{
+			name:  "all ports",
+			rules: []FirewallRule{inbound("tcp", "0"), inbound("udp", "all"), inbound("tcp", "22")},
+			ports: []common.PortRange{common.SSHPort},
+			broad: []FirewallRule{inbound("tcp", "0"), inbound("udp", "all")},
+		},
+		{
+			name:  "other protocol",
+			rules: []FirewallRule{inbound("icmp", "")},
+			broad: []FirewallRule{inbound("icmp", "")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ports, broad := firewallRulePorts(tt.rules)
+			if !reflect.DeepEqual(ports, tt.ports) {
+				t.Errorf("ports = %v, want %v", ports, tt.ports)
+			}
+			if !reflect.DeepEqual(broad, tt.broad) {
+				t.Errorf("broad = %v, want %v", broad, tt.broad)
+			}
+		})
+	}
+}
+
+func TestMergeInboundRules(t *testing.T) {
+	restricted := FirewallRule{Protocol: "tcp", Ports: "8080", Sources: &FirewallTargets{Addresses: []string{"203.0.113.0/24"}}}
+	tests := []struct {
+		name     string
+		existing []FirewallRule
+		ports    []common.PortRange
+		want     []FirewallRule
+		changed  bool
+	}{
+		{
+			name:     "ports already allowed",
+			existing: []FirewallRule{inbound("tcp", "22"), inbound("tcp", "443")},
+			ports:    []common.PortRange{common.SSHPort, common.Port("tcp", 443)},
+			want:     []FirewallRule{inbound("tcp", "22"), inbound("tcp", "443")},
+		},
+		{
+			name:     "rules of other droplets are kept",
+			existing: []FirewallRule{inbound("tcp", "22"), inbound("tcp", "443"), restricted},
+			ports:    []common.PortRange{common.SSHPort, common.Port("udp", 8443)},
+			want:     []FirewallRule{inbound("tcp", "22"), inbound("tcp", "443"), restricted, inbound("udp", "8443")},
+			changed:  true,
+		},
+		{
+			name:     "covered by a range",
+			existing: []FirewallRule{inbound("udp", "30000-30100")},
+			ports:    []common.PortRange{common.Port("udp", 30050)},
+			want:     []FirewallRule{inbound("udp", "30000-30100")},
+		},
+		{
+			name:     "covered by a broad rule",
+			existing: []FirewallRule{inbound("tcp", "0")},
+			ports:    []common.PortRange{common.SSHPort, common.Port("tcp", 443)},
+			want:     []FirewallRule{inbound("tcp", "0")},
+		},
+		{
+			name:     "broad rules are kept when adding ports",
+			existing: []FirewallRule{inbound("icmp", ""), inbound("udp", "0")},
+			ports:    []common.PortRange{common.SSHPort, common.Port("udp", 8443)},
+			want:     []FirewallRule{inbound("icmp", ""), inbound("udp", "0"), inbound("tcp", "22")},
+			changed:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed := mergeInboundRules(tt.existing, tt.ports)
+			if changed != tt.changed {
+				t.Errorf("changed = %v, want %v", changed, tt.changed)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rules = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package digitalocean
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+const providerName = "digitalocean"
+
+// APIError represents an error response returned by the DigitalOcean API.
+// See https://docs.digitalocean.com/reference/api/api-reference/#section/Introduction/HTTP-Statuses
+type APIError struct {
+	StatusCode int           // HTTP status code of the response
+	ID         string        // Error identifier, e.g. "unauthorized" or "too_many_requests"
+	Message    string        // Human-readable error message
+	retryAfter time.Duration // Delay requested via Retry-After or RateLimit-Reset headers
+}
+
+func (e *APIError) Error() string {
+	if e.StatusCode == http.StatusUnauthorized {
+		return "DigitalOcean request failed with Unauthorized error"
+	}
+	return fmt.Sprintf("fetch %s failed with %d: %s", e.ID, e.StatusCode, e.Message)
+}
+
+// Retriable implements common.Retriable.
+func (e *APIError) Retriable() bool {
+	return common.IsRetriableStatus(e.StatusCode)
+}
+
+// RetryAfter implements common.RetryAfterHint.
+func (e *APIError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// toProviderError classifies a DigitalOcean API error into the common error taxonomy.
+func toProviderError(e *APIError) *common.ProviderError {
+	category := common.CategoryForStatus(e.StatusCode)
+	msg := strings.ToLower(e.Message)
+	switch {
+	case e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusTooManyRequests:
+		// Categorized by status, whatever the message says
+	case strings.Contains(msg, "limit") && (strings.Contains(msg, "droplet") || strings.Contains(msg, "exceed")):
+		// e.g. "creating this/these droplet(s) will exceed your droplet limit"
+		category = common.ErrQuotaExceeded
+	case strings.Contains(msg, "billing") || strings.Contains(msg, "payment"):
+		category = common.ErrBillingDisabled
+	case e.StatusCode == http.StatusUnprocessableEntity &&
+		(strings.Contains(msg, "not available") || (strings.Contains(msg, "region") && strings.Contains(msg, "unavailable"))):
+		// e.g. "Region is not available", "Size is not available in this region" or "Region is unavailable"
+		category = common.ErrRegionUnavailable
+	}
+	return &common.ProviderError{
+		Provider:   providerName,
+		Category:   category,
+		StatusCode: e.StatusCode,
+		Code:       e.ID,
+		Message:    e.Message,
+		Err:        e,
+	}
+}
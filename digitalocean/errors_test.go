@@ -0,0 +1,44 @@
+package digitalocean
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+func TestToProviderError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  APIError
+		want error
+	}{
+		{"unauthorized", APIError{StatusCode: http.StatusUnauthorized, ID: "Unauthorized", Message: "Unable to authenticate you"}, common.ErrUnauthorized},
+		{"unauthorized mentioning billing", APIError{StatusCode: http.StatusUnauthorized, ID: "Unauthorized", Message: "token revoked after a billing change"}, common.ErrUnauthorized},
+		{"rate limit", APIError{StatusCode: http.StatusTooManyRequests, ID: "too_many_requests", Message: "API Rate limit exceeded."}, common.ErrRateLimited},
+		{"droplet limit", APIError{StatusCode: http.StatusUnprocessableEntity, ID: "unprocessable_entity", Message: "creating this/these droplet(s) will exceed your droplet limit"}, common.ErrQuotaExceeded},
+		{"billing", APIError{StatusCode: http.StatusForbidden, ID: "forbidden", Message: "You must add a payment method to create droplets."}, common.ErrBillingDisabled},
+		{"region unavailable", APIError{StatusCode: http.StatusUnprocessableEntity, ID: "unprocessable_entity", Message: "Region is not available"}, common.ErrRegionUnavailable},
+		{"region unavailable, another wording", APIError{StatusCode: http.StatusUnprocessableEntity, ID: "unprocessable_entity", Message: "The region nyc2 is currently unavailable"}, common.ErrRegionUnavailable},
+		{"size unavailable", APIError{StatusCode: http.StatusUnprocessableEntity, ID: "unprocessable_entity", Message: "Size is not available in this region."}, common.ErrRegionUnavailable},
+		{"not found", APIError{StatusCode: http.StatusNotFound, ID: "not_found", Message: "The resource you were accessing could not be found."}, common.ErrNotFound},
+		{"forbidden", APIError{StatusCode: http.StatusForbidden, ID: "forbidden", Message: "You are not authorized to perform this operation"}, common.ErrPermissionDenied},
+		{"server error", APIError{StatusCode: http.StatusServiceUnavailable, ID: "service_unavailable", Message: "error parsing response body: invalid character '<'"}, common.ErrTransient},
+		{"invalid request", APIError{StatusCode: http.StatusUnprocessableEntity, ID: "unprocessable_entity", Message: "Name is invalid"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := toProviderError(&tt.err)
+			if err.Category != tt.want {
+				t.Errorf("category = %v, want %v", err.Category, tt.want)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("%v isn't %v", err, tt.want)
+			}
+			if err.Code != tt.err.ID || err.StatusCode != tt.err.StatusCode {
+				t.Errorf("code = %s %d, want %s %d", err.Code, err.StatusCode, tt.err.ID, tt.err.StatusCode)
+			}
+		})
+	}
+}
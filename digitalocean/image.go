@@ -0,0 +1,37 @@
+package digitalocean
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+// imageSlugs are the slugs of the distribution images, which DigitalOcean keeps pointing to the latest build.
+var imageSlugs = map[common.OSTarget]string{
+	common.OSUbuntu2204: "ubuntu-22-04-x64",
+	common.OSUbuntu2404: "ubuntu-24-04-x64",
+	common.OSDebian12:   "debian-12-x64",
+}
+
+// LatestImage returns the slug of the latest image of target, checking that it is still offered in region.
+// Retired distributions are removed from the list, so a droplet is never created with an EOL image.
+func LatestImage(ctx context.Context, client *APIClient, target common.OSTarget, region string) (string, error) {
+	slug, ok := imageSlugs[target]
+	if !ok {
+		return "", fmt.Errorf("no DigitalOcean image for %s", target)
+	}
+	images, err := client.GetDistributionImages(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to list images: %w", err)
+	}
+	i := slices.IndexFunc(images, func(image ImageInfo) bool { return image.Slug == slug })
+	if i < 0 {
+		return "", fmt.Errorf("image %s is no longer offered", slug)
+	}
+	if image := images[i]; image.Status != "available" || !slices.Contains(image.Regions, region) {
+		return "", fmt.Errorf("image %s is not available in %s", slug, region)
+	}
+	return slug, nil
+}
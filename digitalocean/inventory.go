@@ -0,0 +1,165 @@
+package digitalocean
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+// RefreshServer reconciles an inventory record with the droplet's actual state.
+// It updates the record's status and addresses; a deleted droplet is marked missing.
+func RefreshServer(ctx context.Context, client *APIClient, record *common.InventoryRecord) error {
+	server := &record.Server
+	dropletID, err := strconv.Atoi(server.InstanceID)
+	if err != nil {
+		return fmt.Errorf("invalid droplet ID %q: %w", server.InstanceID, err)
+	}
+	di, err := client.GetDroplet(ctx, dropletID)
+	if errors.Is(err, common.ErrNotFound) {
+		record.Status = common.ServerStatusMissing
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	record.Status = common.RefreshedStatus(record.Status, dropletStatus(di))
+	if server.StaticIPName != "" {
+		rip, err := client.GetReservedIP(ctx, server.StaticIPName)
+		if err != nil && !errors.Is(err, common.ErrNotFound) {
+			return err
+		}
+		if rip == nil || rip.Droplet == nil || rip.Droplet.ID != dropletID {
+			// Released or moved outside of the library
+			server.StaticIPName = ""
+		}
+	}
+	// A reserved IP is the server's public address, not the droplet's own one
+	if server.StaticIPName == "" {
+		if ip := di.PublicIPv4(); ip != "" {
+			server.Config.ExternalIp = ip
+		}
+	}
+	server.Config.ExternalIpv6 = di.PublicIPv6()
+	return nil
+}
+
+// dropletStatus maps a droplet status to the inventory status.
+func dropletStatus(di *DropletInfo) common.ServerStatus {
+	switch di.Status {
+	case "off", "archive":
+		return common.ServerStatusStopped
+	}
+	return common.ServerStatusActive
+}
+
+// DestroyDroplet deletes a droplet and releases its reserved IP.
+// A droplet that no longer exists is not an error, so a partially destroyed server can be cleaned up.
+func DestroyDroplet(ctx context.Context, client *APIClient, server common.Server, progress common.ProgressFunc) error {
+	if progress == nil {
+		progress = func(string) {}
+	}
+	dropletID, err := strconv.Atoi(server.InstanceID)
+	if err != nil {
+		return fmt.Errorf("invalid droplet ID %q: %w", server.InstanceID, err)
+	}
+	if server.StaticIPName != "" {
+		// A reserved IP can't be deleted while it is assigned
+		if err := client.UnassignReservedIP(ctx, server.StaticIPName); err != nil {
+			slog.Warn("Failed to unassign reserved IP", "ip", server.StaticIPName, "err", err)
+		}
+	}
+	progress(fmt.Sprintf("Deleting droplet %s", server.InstanceName))
+	if err := client.DeleteDroplet(ctx, dropletID); err != nil && !errors.Is(err, common.ErrNotFound) {
+		return fmt.Errorf("failed to delete droplet %d: %w", dropletID, err)
+	}
+	if server.StaticIPName != "" {
+		progress("Releasing the reserved IP")
+		releaseReservedIP(ctx, client, server.StaticIPName)
+	}
+	return nil
+}
+
+// SetInventory implements common.ServerManager.
+func (p *Provisioner) SetInventory(inventory common.Inventory) {
+	p.inventory = inventory
+}
+
+// Inventory implements common.ServerManager.
+func (p *Provisioner) Inventory() common.Inventory {
+	return p.inventory
+}
+
+// SetSecretStore implements common.ServerManager.
+func (p *Provisioner) SetSecretStore(store common.SecretStore) {
+	p.secrets = store
+}
+
+// SecretStore implements common.ServerManager.
+func (p *Provisioner) SecretStore() common.SecretStore {
+	return p.secrets
+}
+
+// DestroyServer implements common.ServerManager.
+func (p *Provisioner) DestroyServer(ctx context.Context, id string) {
+	go func() {
+		p.session.Events <- common.Event{Type: common.EventTypeDestroyStarted, Message: id}
+		record, err := p.loadServer(ctx, id)
+		if err != nil {
+			slog.Error("Failed to load server", "id", id, "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeDestroyError, Error: err}
+			return
+		}
+		if err := DestroyDroplet(ctx, p.client, record.Server, p.progress); err != nil {
+			slog.Error("Failed to destroy server", "id", id, "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeDestroyError, Error: err}
+			return
+		}
+		if err := common.ForgetServer(p.inventory, p.secrets, id); err != nil {
+			slog.Error("Failed to remove server from inventory", "id", id, "err", err)
+		}
+		p.session.Events <- common.Event{Type: common.EventTypeDestroyCompleted, Message: id, Server: &record.Server}
+	}()
+}
+
+// RotateServerIP implements common.ServerManager.
+func (p *Provisioner) RotateServerIP(ctx context.Context, id string) {
+	go func() {
+		p.session.Events <- common.Event{Type: common.EventTypeIPRotationStarted, Message: id}
+		record, err := p.loadServer(ctx, id)
+		if err == nil && record.Status == common.ServerStatusMissing {
+			err = fmt.Errorf("droplet %s no longer exists: %w", record.Server.InstanceID, common.ErrNotFound)
+		}
+		if err != nil {
+			slog.Error("Failed to load server", "id", id, "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeIPRotationError, Message: id, Error: err}
+			return
+		}
+		p.rotateIP(ctx, record.Server)
+	}()
+}
+
+// loadServer gets a server from the inventory, reconciled with the droplet's actual state.
+func (p *Provisioner) loadServer(ctx context.Context, id string) (*common.InventoryRecord, error) {
+	return common.LoadServer(p.inventory, id, func(record *common.InventoryRecord) error {
+		return RefreshServer(ctx, p.client, record)
+	})
+}
+
+// recordServer assigns the server a local ID, adds it to the inventory and stores its SSH key.
+// Failing to add the server to the inventory doesn't fail provisioning, the server is usable either way.
+// Failing to store the SSH key does: without it the server can't be installed or maintained.
+func (p *Provisioner) recordServer(server *common.Server, sshUser, sshPrivateKey string, status common.ServerStatus, profile *common.InstallProfile) error {
+	record := common.NewInventoryRecord(server, sshUser)
+	record.Status = status
+	record.Profile = profile
+	if p.inventory != nil {
+		if err := p.inventory.Put(record); err != nil {
+			slog.Error("Failed to add server to inventory", "id", server.ID, "err", err)
+		}
+	}
+	return common.StoreSSHKey(p.secrets, server.ID, sshPrivateKey)
+}
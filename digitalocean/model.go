@@ -45,7 +45,8 @@ type DropletSpecification struct {
 // https://developers.digitalocean.com/documentation/v2/#retrieve-an-existing-droplet-by-id
 type DropletInfo struct {
 	ID     int      `json:"id"`
-	Status string   `json:"status"` // 'new' or 'active'
+	Name   string   `json:"name"`
+	Status string   `json:"status"` // 'new', 'active', 'off' or 'archive'
 	Tags   []string `json:"tags"`
 	Region struct {
 		Slug string `json:"slug"`
@@ -55,13 +56,39 @@ type DropletInfo struct {
 		PriceMonthly float64 `json:"price_monthly"`
 	} `json:"size"`
 	Networks struct {
-		V4 []struct {
-			Type      string `json:"type"`
-			IPAddress string `json:"ip_address"`
-		} `json:"v4"`
+		V4 []DropletNetwork `json:"v4"`
+		V6 []DropletNetwork `json:"v6"`
 	} `json:"networks"`
 }
 
+// DropletNetwork represents an IPv4 or IPv6 address of a droplet.
+type DropletNetwork struct {
+	Type      string `json:"type"` // 'public' or 'private'
+	IPAddress string `json:"ip_address"`
+	Netmask   any    `json:"netmask"` // Dotted netmask for IPv4, prefix length for IPv6
+	Gateway   string `json:"gateway"`
+}
+
+// PublicIPv4 returns the droplet's public IPv4 address, or an empty string if it has none yet.
+// Droplets in a VPC also have a private address, which may be listed first.
+func (d *DropletInfo) PublicIPv4() string {
+	return publicAddress(d.Networks.V4)
+}
+
+// PublicIPv6 returns the droplet's public IPv6 address, or an empty string if IPv6 is not enabled.
+func (d *DropletInfo) PublicIPv6() string {
+	return publicAddress(d.Networks.V6)
+}
+
+func publicAddress(networks []DropletNetwork) string {
+	for _, n := range networks {
+		if n.Type == "public" {
+			return n.IPAddress
+		}
+	}
+	return ""
+}
+
 // Account represents a DigitalOcean account.
 // Reference:
 // https://developers.digitalocean.com/documentation/v2/#get-user-information
@@ -85,6 +112,19 @@ type RegionInfo struct {
 	Features  []string `json:"features"`
 }
 
+// ImageInfo represents a DigitalOcean image.
+// Reference:
+// https://docs.digitalocean.com/reference/api/api-reference/#tag/Images
+type ImageInfo struct {
+	ID           int      `json:"id"`
+	Name         string   `json:"name"`
+	Distribution string   `json:"distribution"`
+	Slug         string   `json:"slug"` // Set for public images, e.g. "debian-12-x64"
+	Public       bool     `json:"public"`
+	Regions      []string `json:"regions"`
+	Status       string   `json:"status"` // 'NEW', 'available', 'pending', 'retired' or 'deleted'
+}
+
 // ProjectInfo represents information about a DigitalOcean project.
 // Reference:
 // https://developers.digitalocean.com/documentation/v2/#projects
@@ -100,3 +140,56 @@ type ProjectInfo struct {
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
+
+// Firewall represents a DigitalOcean Cloud Firewall.
+// Reference:
+// https://docs.digitalocean.com/reference/api/api-reference/#tag/Firewalls
+type Firewall struct {
+	ID            string         `json:"id,omitempty"`
+	Name          string         `json:"name"`
+	Status        string         `json:"status,omitempty"` // 'waiting', 'succeeded', or 'failed'
+	InboundRules  []FirewallRule `json:"inbound_rules"`
+	OutboundRules []FirewallRule `json:"outbound_rules"`
+	DropletIDs    []int          `json:"droplet_ids"`
+	Tags          []string       `json:"tags"`
+	CreatedAt     *time.Time     `json:"created_at,omitempty"`
+}
+
+// FirewallRule represents an inbound or outbound Cloud Firewall rule.
+type FirewallRule struct {
+	Protocol     string           `json:"protocol"`        // 'tcp', 'udp', or 'icmp'
+	Ports        string           `json:"ports,omitempty"` // e.g. "22", "8000-9000", or "0" for all ports
+	Sources      *FirewallTargets `json:"sources,omitempty"`
+	Destinations *FirewallTargets `json:"destinations,omitempty"`
+}
+
+// FirewallTargets lists the sources or destinations of a Cloud Firewall rule.
+type FirewallTargets struct {
+	Addresses  []string `json:"addresses,omitempty"`
+	DropletIDs []int    `json:"droplet_ids,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+}
+
+// ReservedIP represents a DigitalOcean reserved (formerly floating) IP address.
+// Reference:
+// https://docs.digitalocean.com/reference/api/api-reference/#tag/Reserved-IPs
+type ReservedIP struct {
+	IP     string `json:"ip"`
+	Region struct {
+		Slug string `json:"slug"`
+	} `json:"region"`
+	Droplet   *DropletInfo `json:"droplet"` // nil if not assigned
+	Locked    bool         `json:"locked"`
+	ProjectID string       `json:"project_id"`
+}
+
+// Action represents an asynchronous DigitalOcean action, such as assigning a reserved IP.
+// Reference:
+// https://docs.digitalocean.com/reference/api/api-reference/#tag/Actions
+type Action struct {
+	ID           int    `json:"id"`
+	Status       string `json:"status"` // 'in-progress', 'completed', or 'errored'
+	Type         string `json:"type"`
+	ResourceID   int    `json:"resource_id"`
+	ResourceType string `json:"resource_type"`
+}
@@ -93,8 +93,20 @@ func GetAccount(accessToken string) (*DOAccount, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
+		var errResp struct {
+			ID      string `json:"id"`
+			Message string `json:"message"`
+		}
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("api request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+		if json.Unmarshal(bodyBytes, &errResp) != nil {
+			errResp.Message = string(bodyBytes)
+		}
+		return nil, toProviderError(&APIError{
+			StatusCode: resp.StatusCode,
+			ID:         errResp.ID,
+			Message:    errResp.Message,
+			retryAfter: common.ParseRetryAfter(resp.Header, time.Now()),
+		})
 	}
 
 	bodyBytes, err := io.ReadAll(resp.Body)
@@ -2,21 +2,30 @@ package digitalocean
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"strconv"
+	"time"
 
 	"github.com/getlantern/lantern-server-provisioner/common"
 )
 
 type Provisioner struct {
-	accounts []common.Compartment
-	client   *APIClient
-	session  *common.Session
+	accounts  []common.Compartment
+	client    *APIClient
+	session   *common.Session
+	inventory common.Inventory
+	secrets   common.SecretStore
 }
 
-func GetProvisioner(ctx context.Context, browserStart common.BrowserOpener) *Provisioner {
+// GetProvisioner returns a DigitalOcean provisioner and starts its OAuth flow. Without an OS keychain,
+// SSH keys are kept in a file encrypted with the passphrase returned by passphrase, see common.DefaultSecretStore.
+func GetProvisioner(ctx context.Context, browserStart common.BrowserOpener, passphrase common.PassphraseFunc) *Provisioner {
 	session := RunOauth(ctx, browserStart)
 	return &Provisioner{
-		session: session,
+		session:   session,
+		inventory: common.DefaultInventory(),
+		secrets:   common.DefaultSecretStore(passphrase),
 	}
 }
 
@@ -78,7 +87,8 @@ func (p *Provisioner) Session() *common.Session {
 	return p.session
 }
 
-func (p *Provisioner) Provision(ctx context.Context, placementID string, locationID string) {
+func (p *Provisioner) Provision(ctx context.Context, placementID string, locationID string, opts ...common.ProvisionOption) {
+	options := common.NewProvisionOptions(opts...)
 	go func() {
 		p.session.Events <- common.Event{Type: common.EventTypeProvisioningStarted, Message: placementID}
 
@@ -89,10 +99,34 @@ func (p *Provisioner) Provision(ctx context.Context, placementID string, locatio
 			return
 		}
 
+		recipe, err := common.Recipe(options.OS)
+		if err == nil {
+			err = recipe.CheckSupported(time.Now())
+		}
+		if err != nil {
+			p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err}
+			return
+		}
+		if err := common.CheckSecretStore(p.secrets); err != nil {
+			err = fmt.Errorf("SSH keys can't be stored: %w", err)
+			slog.Error("Secret store is unusable", "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err}
+			return
+		}
+		image, err := LatestImage(ctx, p.client, recipe.Target, locationID)
+		if err != nil {
+			slog.Error("Failed to get image", "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err}
+			return
+		}
+
 		name := common.MakeInstanceName()
 		if di, err := p.client.CreateDroplet(ctx, name, locationID, publicSSHKey, DropletSpecification{
-			Size:  "s-1vcpu-1gb",
-			Image: "ubuntu-22-04-x64",
+			// Moves sshd to a hardened port before the firewall, which only allows that port, lets SSH in
+			InstallCommand: recipe.FirstBootScript(options.InstallProfile()),
+			Size:           "s-1vcpu-1gb",
+			Image:          image,
+			Tags:           []string{lanternTag},
 		}); err != nil {
 			slog.Error("Failed to create droplet", "err", err)
 			p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err}
@@ -100,18 +134,162 @@ func (p *Provisioner) Provision(ctx context.Context, placementID string, locatio
 		} else {
 			slog.Debug("Created droplet", "dropletID", di.ID)
 			dropletID := di.ID
-			ip := di.Networks.V4[0].IPAddress
-			if conf, err := common.InstallServer(ip, privateSSHKey, "root"); err != nil {
-				slog.Error("Failed to install server", "err", err)
+			// The droplet is tagged, so the firewall applies to it. Only SSH is needed until the server is installed.
+			if err := CreateFirewallIfNeeded(ctx, p.client, []common.PortRange{options.InstallProfile().SSHPort()}); err != nil {
+				slog.Error("Failed to create firewall", "err", err)
 				p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err}
 				return
-			} else {
-				slog.Debug("Installed server on droplet", "id", dropletID, "conf", conf)
-				p.session.Events <- common.Event{
-					Type:    common.EventTypeProvisioningCompleted,
-					Message: conf.Encode(),
+			}
+			ip := di.PublicIPv4()
+			if ip == "" {
+				err := fmt.Errorf("droplet %d has no public IPv4 address", dropletID)
+				slog.Error("Failed to get droplet IP", "err", err)
+				p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err}
+				return
+			}
+			reservedIP := ""
+			if options.StaticIP {
+				if ip, err = p.reserveIP(ctx, di); err != nil {
+					slog.Error("Failed to reserve IP", "err", err)
+					p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err}
+					return
 				}
+				reservedIP = ip
 			}
+			server := &common.Server{
+				Provider:     providerName,
+				PlacementID:  placementID,
+				LocationID:   locationID,
+				InstanceID:   strconv.Itoa(dropletID),
+				InstanceName: name,
+				StaticIPName: reservedIP,
+				OS:           recipe.Target,
+				Config:       common.ServerConfiguration{ExternalIp: ip, ExternalIpv6: di.PublicIPv6()},
+			}
+			// Recorded before it is installed, so a failed installation can be resumed
+			if err := p.recordServer(server, "root", privateSSHKey, common.ServerStatusInstalling, options.Profile); err != nil {
+				slog.Error("Failed to record server", "id", server.ID, "err", err)
+				p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err, Server: server}
+				return
+			}
+			p.installServer(ctx, server, privateSSHKey, "root", options.InstallProfile())
+		}
+	}()
+}
+
+// ResumeInstall implements common.InstallResumer.
+func (p *Provisioner) ResumeInstall(ctx context.Context, id string) {
+	go func() {
+		p.session.Events <- common.Event{Type: common.EventTypeProvisioningStarted, Message: id}
+		record, err := p.loadServer(ctx, id)
+		if err == nil && record.Status != common.ServerStatusInstalling {
+			err = fmt.Errorf("server %s is %s, not installing", id, record.Status)
+		}
+		if err != nil {
+			slog.Error("Failed to load server", "id", id, "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err}
+			return
 		}
+		server := record.Server
+		privateSSHKey, err := common.LoadSSHKey(p.secrets, id)
+		if err != nil {
+			slog.Error("Failed to load SSH key", "id", id, "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err, Server: &server}
+			return
+		}
+		p.installServer(ctx, &server, privateSSHKey, record.SSHUser, record.InstallProfile())
 	}()
 }
+
+// installServer installs a recorded server, opens its ports in the Cloud Firewall and verifies it.
+// The result is reported with EventTypeProvisioningCompleted or EventTypeProvisioningError; the server
+// stays installing in the inventory on failure.
+func (p *Provisioner) installServer(ctx context.Context, server *common.Server, privateSSHKey, sshUser string, profile common.InstallProfile) {
+	fail := func(msg string, err error) {
+		slog.Error(msg, "id", server.ID, "err", err)
+		p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err, Server: server}
+	}
+	conf, err := common.InstallServer(ctx, server.Config.ExternalIp, privateSSHKey, sshUser, server.OS, profile, p.log)
+	if err != nil {
+		fail("Failed to install server", err)
+		return
+	}
+	if err := CreateFirewallIfNeeded(ctx, p.client, conf.FirewallPorts()); err != nil {
+		fail("Failed to update firewall", err)
+		return
+	}
+	// lantern-server-manager detects the droplet's own address, which differs from a reserved IP
+	conf.ExternalIp = server.Config.ExternalIp
+	conf.ExternalIpv6 = server.Config.ExternalIpv6
+	p.progress("Verifying the server")
+	if err := common.VerifyServer(ctx, *conf, privateSSHKey, sshUser); err != nil {
+		fail("Server verification failed", err)
+		return
+	}
+	slog.Debug("Installed server on droplet", "droplet", server.InstanceID, "conf", conf)
+	server.Config = *conf
+	if err := common.UpdateServer(p.inventory, *server, common.ServerStatusActive); err != nil {
+		slog.Error("Failed to update server in inventory", "id", server.ID, "err", err)
+	}
+	p.session.Events <- common.Event{
+		Type:    common.EventTypeProvisioningCompleted,
+		Message: conf.Encode(),
+		Server:  server,
+	}
+}
+
+// reserveIP reserves an IP address in the droplet's region and assigns it to the droplet.
+// The reserved IP is released again if it can't be assigned.
+func (p *Provisioner) reserveIP(ctx context.Context, di *DropletInfo) (string, error) {
+	rip, err := p.client.CreateReservedIP(ctx, di.Region.Slug)
+	if err != nil {
+		return "", fmt.Errorf("failed to create reserved IP: %w", err)
+	}
+	if err := p.client.AssignReservedIP(ctx, rip.IP, di.ID); err != nil {
+		if delErr := p.client.DeleteReservedIP(ctx, rip.IP); delErr != nil {
+			slog.Error("Failed to release reserved IP", "ip", rip.IP, "err", delErr)
+		}
+		return "", fmt.Errorf("failed to assign reserved IP %s to droplet %d: %w", rip.IP, di.ID, err)
+	}
+	slog.Debug("Assigned reserved IP", "ip", rip.IP, "dropletID", di.ID)
+	return rip.IP, nil
+}
+
+// RotateIP implements common.IPRotator. It moves the droplet to a new reserved IP address
+// and releases the old one.
+func (p *Provisioner) RotateIP(ctx context.Context, server common.Server) {
+	go func() {
+		p.session.Events <- common.Event{Type: common.EventTypeIPRotationStarted, Message: server.ID, Server: &server}
+		p.rotateIP(ctx, server)
+	}()
+}
+
+// rotateIP rotates the server's IP, updates the inventory and reports the result.
+func (p *Provisioner) rotateIP(ctx context.Context, server common.Server) {
+	rotated, err := RotateDropletIP(ctx, p.client, server, p.progress)
+	if err != nil {
+		slog.Error("Failed to rotate IP", "id", server.ID, "droplet", server.InstanceID, "err", err)
+		p.session.Events <- common.Event{Type: common.EventTypeIPRotationError, Message: server.ID, Error: err, Server: &server}
+		return
+	}
+	slog.Debug("Rotated IP", "droplet", server.InstanceID, "ip", rotated.Config.ExternalIp)
+	if err := common.UpdateServer(p.inventory, *rotated, common.ServerStatusActive); err != nil {
+		slog.Error("Failed to update server in inventory", "id", rotated.ID, "err", err)
+	}
+	p.session.Events <- common.Event{
+		Type:    common.EventTypeIPRotationCompleted,
+		Message: rotated.Config.Encode(),
+		Server:  rotated,
+	}
+}
+
+// progress reports a progress message on the session.
+func (p *Provisioner) progress(message string) {
+	slog.Debug("Progress", "message", message)
+	p.session.Events <- common.Event{Type: common.EventTypeProgress, Message: message}
+}
+
+// log reports a line of remote command output on the session.
+func (p *Provisioner) log(line string) {
+	p.session.Events <- common.Event{Type: common.EventTypeLog, Message: line}
+}
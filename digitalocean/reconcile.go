@@ -0,0 +1,112 @@
+package digitalocean
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+// ReconcileServers compares the inventory's DigitalOcean servers with the live droplets.
+// Droplets tagged for Lantern that are not in the inventory are reported as orphaned. A server that
+// can't be checked doesn't stop the others: the drifts found are returned along with the joined errors.
+func ReconcileServers(ctx context.Context, client *APIClient, records []common.InventoryRecord) ([]common.Drift, error) {
+	var drifts []common.Drift
+	var errs []error
+	known := make(map[string]bool) // Droplet IDs of recorded servers
+	for _, record := range records {
+		if record.Server.Provider != providerName {
+			continue
+		}
+		known[record.Server.InstanceID] = true
+		actual := record
+		if err := RefreshServer(ctx, client, &actual); err != nil {
+			errs = append(errs, fmt.Errorf("failed to refresh server %s: %w", record.Server.ID, err))
+			continue
+		}
+		drifts = append(drifts, common.DetectDrift(record, actual)...)
+	}
+
+	droplets, err := client.GetDroplets(ctx, lanternTag, "")
+	if err != nil {
+		return drifts, errors.Join(append(errs, fmt.Errorf("failed to list droplets: %w", err))...)
+	}
+	for _, di := range droplets {
+		id := strconv.Itoa(di.ID)
+		if known[id] {
+			continue
+		}
+		server := common.Server{
+			Provider:     providerName,
+			LocationID:   di.Region.Slug,
+			InstanceID:   id,
+			InstanceName: di.Name,
+			Config: common.ServerConfiguration{
+				ExternalIp:   di.PublicIPv4(),
+				ExternalIpv6: di.PublicIPv6(),
+			},
+		}
+		drifts = append(drifts, common.Drift{
+			Kind:    common.DriftOrphaned,
+			Server:  server,
+			Status:  dropletStatus(&di),
+			Current: server.Config.ExternalIp,
+			Message: fmt.Sprintf("Droplet %s (%s) in %s is not in the inventory", di.Name, id, di.Region.Slug),
+		})
+	}
+	return drifts, errors.Join(errs...)
+}
+
+// Reconcile implements common.Reconciler.
+func (p *Provisioner) Reconcile(ctx context.Context) {
+	go func() {
+		p.session.Events <- common.Event{Type: common.EventTypeReconcileStarted}
+		if p.inventory == nil {
+			p.session.Events <- common.Event{Type: common.EventTypeReconcileError, Error: fmt.Errorf("no inventory configured")}
+			return
+		}
+		records, err := p.inventory.List()
+		if err != nil {
+			slog.Error("Failed to list inventory", "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeReconcileError, Error: err}
+			return
+		}
+		p.progress("Comparing the inventory with DigitalOcean")
+		drifts, err := ReconcileServers(ctx, p.client, records)
+		if err != nil {
+			slog.Warn("Failed to check some servers", "err", err)
+		}
+		slog.Debug("Reconciled inventory", "drifts", len(drifts))
+		p.session.Events <- common.Event{Type: common.EventTypeReconcileCompleted, Drifts: drifts, Error: err}
+	}()
+}
+
+// Adopt implements common.Reconciler.
+func (p *Provisioner) Adopt(ctx context.Context, drift common.Drift) {
+	go func() {
+		if err := common.AdoptDrift(p.inventory, drift); err != nil {
+			slog.Error("Failed to adopt drift", "kind", drift.Kind, "id", drift.ServerID, "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeDriftResolutionError, Error: err}
+			return
+		}
+		p.session.Events <- common.Event{Type: common.EventTypeDriftResolved, Message: string(drift.Kind), Server: &drift.Server}
+	}()
+}
+
+// Purge implements common.Reconciler.
+func (p *Provisioner) Purge(ctx context.Context, drift common.Drift) {
+	go func() {
+		err := common.PurgeDrift(p.inventory, p.secrets, drift, func() error {
+			return DestroyDroplet(ctx, p.client, drift.Server, p.progress)
+		})
+		if err != nil {
+			slog.Error("Failed to purge drift", "kind", drift.Kind, "id", drift.ServerID, "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeDriftResolutionError, Error: err}
+			return
+		}
+		p.session.Events <- common.Event{Type: common.EventTypeDriftResolved, Message: string(drift.Kind), Server: &drift.Server}
+	}()
+}
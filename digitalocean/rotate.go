@@ -0,0 +1,73 @@
+package digitalocean
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+// RotateDropletIP moves a droplet to a new reserved IP address.
+// It reserves a new address in the droplet's region, moves the droplet to it and releases the old
+// reserved IP, if any. If the assignment fails, the old reserved IP is assigned back. The droplet
+// itself is not touched, so the installed Lantern server keeps its state. It returns the updated server.
+func RotateDropletIP(ctx context.Context, client *APIClient, server common.Server, progress common.ProgressFunc) (*common.Server, error) {
+	if progress == nil {
+		progress = func(string) {}
+	}
+	dropletID, err := strconv.Atoi(server.InstanceID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid droplet ID %q: %w", server.InstanceID, err)
+	}
+	droplet, err := client.GetDroplet(ctx, dropletID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get droplet %d: %w", dropletID, err)
+	}
+
+	progress("Reserving a new IP address")
+	rip, err := client.CreateReservedIP(ctx, droplet.Region.Slug)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reserved IP: %w", err)
+	}
+	slog.Debug("Reserved new IP", "ip", rip.IP)
+
+	progress(fmt.Sprintf("Moving droplet %d to %s", dropletID, rip.IP))
+	oldIP := server.StaticIPName
+	if oldIP != "" {
+		// A droplet can only have one reserved IP
+		if err := client.UnassignReservedIP(ctx, oldIP); err != nil {
+			releaseReservedIP(ctx, client, rip.IP)
+			return nil, fmt.Errorf("failed to unassign reserved IP %s: %w", oldIP, err)
+		}
+	}
+	if err := client.AssignReservedIP(ctx, rip.IP, dropletID); err != nil {
+		if oldIP != "" {
+			if restoreErr := client.AssignReservedIP(ctx, oldIP, dropletID); restoreErr != nil {
+				slog.Error("Failed to restore the previous reserved IP", "ip", oldIP, "err", restoreErr)
+			}
+		}
+		releaseReservedIP(ctx, client, rip.IP)
+		return nil, fmt.Errorf("failed to assign reserved IP %s to droplet %d: %w", rip.IP, dropletID, err)
+	}
+
+	if oldIP != "" {
+		progress("Releasing the old IP address")
+		releaseReservedIP(ctx, client, oldIP)
+	}
+
+	rotated := server
+	rotated.StaticIPName = rip.IP
+	rotated.Config.ExternalIp = rip.IP
+	return &rotated, nil
+}
+
+// releaseReservedIP deletes a reserved IP, logging failures. A leftover reserved IP only costs money,
+// so it doesn't fail the calling operation. A reserved IP that doesn't exist is ignored.
+func releaseReservedIP(ctx context.Context, client *APIClient, ip string) {
+	if err := client.DeleteReservedIP(ctx, ip); err != nil && !errors.Is(err, common.ErrNotFound) {
+		slog.Error("Failed to release reserved IP", "ip", ip, "err", err)
+	}
+}
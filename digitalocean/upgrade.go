@@ -0,0 +1,28 @@
+package digitalocean
+
+import (
+	"context"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+// UpgradeServer implements common.Upgrader. If the server's ports changed, the Cloud Firewall is updated to match.
+func (p *Provisioner) UpgradeServer(ctx context.Context, id string) {
+	p.maintainer().UpgradeServer(ctx, id)
+}
+
+// maintainer returns the maintenance operations for the provisioner's servers.
+func (p *Provisioner) maintainer() *common.Maintainer {
+	return &common.Maintainer{
+		Provider:   providerName,
+		Events:     p.session.Events,
+		Inventory:  p.inventory,
+		Secrets:    p.secrets,
+		Progress:   p.progress,
+		Log:        p.log,
+		LoadServer: p.loadServer,
+		UpdateFirewall: func(ctx context.Context, _ common.InventoryRecord, ports []common.PortRange) error {
+			return CreateFirewallIfNeeded(ctx, p.client, ports)
+		},
+	}
+}
@@ -28,14 +28,20 @@ const (
 	billingV1API         = "https://cloudbilling.googleapis.com/v1"
 	openidConnectV1API   = "https://openidconnect.googleapis.com/v1"
 	firewallName         = "lantern-firewall" // Default firewall name for GCP
+	firewallTag          = firewallName       // Network tag of Lantern instances, targeted by the firewall rules
+	firewallDescription  = "Allow SSH and Lantern server ports"
 	machineSize          = "e2-micro"
 )
 
 var requiredServices = []string{"compute.googleapis.com"}
 
+// DefaultRetryPolicy is the retry policy used by new APIClient instances.
+var DefaultRetryPolicy = common.DefaultRetryPolicy
+
 // APIClient interacts with Google Cloud Platform APIs.
 type APIClient struct {
-	httpClient *http.Client // Automatically handles auth via oauth2 package
+	httpClient  *http.Client // Automatically handles auth via oauth2 package
+	retryPolicy common.RetryPolicy
 }
 
 // NewAPIClient creates a new client using a refresh token.
@@ -63,7 +69,12 @@ func NewAPIClient(ctx context.Context, refreshToken string) *APIClient {
 	// Create an HTTP client that automatically uses the token and refreshes it
 	httpClient := conf.Client(ctx, token)
 
-	return &APIClient{httpClient: httpClient}
+	return &APIClient{httpClient: httpClient, retryPolicy: DefaultRetryPolicy}
+}
+
+// SetRetryPolicy overrides the retry policy used for API requests.
+func (c *APIClient) SetRetryPolicy(policy common.RetryPolicy) {
+	c.retryPolicy = policy
 }
 
 // --- Helper Methods ---
@@ -85,14 +96,15 @@ func instanceURL(loc Locator) string {
 }
 
 // doRequest is a helper to make authenticated HTTP requests and handle responses.
+// Transient failures are retried according to the client's retry policy, as adjusted for the method by
+// common.RetryPolicy.ForMethod.
 func (c *APIClient) doRequest(ctx context.Context, method, urlStr string, queryParams url.Values, reqBody interface{}, respBody interface{}) error {
-	var bodyReader io.Reader
+	var jsonData []byte
 	if reqBody != nil {
-		jsonData, err := json.Marshal(reqBody)
-		if err != nil {
+		var err error
+		if jsonData, err = json.Marshal(reqBody); err != nil {
 			return fmt.Errorf("failed to marshal request body: %w", err)
 		}
-		bodyReader = bytes.NewBuffer(jsonData)
 	}
 
 	reqURL, err := url.Parse(urlStr)
@@ -103,7 +115,19 @@ func (c *APIClient) doRequest(ctx context.Context, method, urlStr string, queryP
 		reqURL.RawQuery = queryParams.Encode()
 	}
 
-	req, err := http.NewRequestWithContext(ctx, method, reqURL.String(), bodyReader)
+	return c.retryPolicy.ForMethod(method).Do(ctx, func(ctx context.Context) error {
+		return c.doSingleRequest(ctx, method, reqURL.String(), jsonData, respBody)
+	})
+}
+
+// doSingleRequest performs a single HTTP request attempt.
+func (c *APIClient) doSingleRequest(ctx context.Context, method, reqURL string, jsonData []byte, respBody interface{}) error {
+	var bodyReader io.Reader
+	if jsonData != nil {
+		bodyReader = bytes.NewReader(jsonData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, bodyReader)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -113,7 +137,11 @@ func (c *APIClient) doRequest(ctx context.Context, method, urlStr string, queryP
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to execute request: %w", err)
+		err = fmt.Errorf("failed to execute request: %w", err)
+		if common.IsRetriable(err) {
+			return &common.ProviderError{Provider: providerName, Category: common.ErrTransient, Err: err}
+		}
+		return err
 	}
 	defer func(Body io.ReadCloser) {
 		_ = Body.Close()
@@ -125,20 +153,23 @@ func (c *APIClient) doRequest(ctx context.Context, method, urlStr string, queryP
 	}
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		retryAfter := common.ParseRetryAfter(resp.Header, time.Now())
 		// Try to parse a GCP error format first
 		var gcpErrResp struct {
 			Error Status `json:"error"`
 		}
 		if json.Unmarshal(bodyBytes, &gcpErrResp) == nil && gcpErrResp.Error.Code != 0 {
 			// Prefer the structured GcpError if available
-			return &Error{Code: gcpErrResp.Error.Code, Message: gcpErrResp.Error.Message}
+			return newStatusError(resp.StatusCode, gcpErrResp.Error,
+				&Error{Code: gcpErrResp.Error.Code, Message: gcpErrResp.Error.Message, retryAfter: retryAfter})
 		}
 		// Otherwise, return generic HttpError
-		return &HttpError{
+		return newHTTPError(&HttpError{
 			StatusCode: resp.StatusCode,
 			Status:     resp.Status,
 			Body:       string(bodyBytes),
-		}
+			retryAfter: retryAfter,
+		})
 	}
 
 	// Handle 204 No Content specifically
@@ -187,6 +218,62 @@ func (c *APIClient) GetInstance(ctx context.Context, instance Locator) (*Instanc
 	return &inst, nil
 }
 
+// StartInstance starts a stopped GCE VM instance.
+func (c *APIClient) StartInstance(ctx context.Context, instance Locator) (*ComputeEngineOperation, error) {
+	var op ComputeEngineOperation
+	err := c.doRequest(ctx, http.MethodPost, fmt.Sprintf("%s/start", instanceURL(instance)), nil, nil, &op)
+	if err != nil {
+		return nil, err
+	}
+	return &op, nil
+}
+
+// ListZoneOperations lists recent operations in a zone, following all result pages. filter is optional.
+func (c *APIClient) ListZoneOperations(ctx context.Context, zone Locator, filter string) ([]ComputeEngineOperation, error) {
+	params := url.Values{}
+	if filter != "" {
+		params.Set("filter", filter)
+	}
+	var ops []ComputeEngineOperation
+	for {
+		var resp listOperationsResponse
+		err := c.doRequest(ctx, http.MethodGet, fmt.Sprintf("%s/operations", zoneURL(zone)), params, nil, &resp)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, resp.Items...)
+		if resp.NextPageToken == "" {
+			return ops, nil
+		}
+		params.Set("pageToken", resp.NextPageToken)
+	}
+}
+
+// AddAccessConfig adds an external access config (public IP) to an instance's network interface.
+func (c *APIClient) AddAccessConfig(ctx context.Context, instance Locator, networkInterface string, data NetworkInterfaceAccessConfig) (*ComputeEngineOperation, error) {
+	params := url.Values{}
+	params.Set("networkInterface", networkInterface)
+	var op ComputeEngineOperation
+	err := c.doRequest(ctx, http.MethodPost, fmt.Sprintf("%s/addAccessConfig", instanceURL(instance)), params, data, &op)
+	if err != nil {
+		return nil, err
+	}
+	return &op, nil
+}
+
+// DeleteAccessConfig removes an external access config from an instance's network interface.
+func (c *APIClient) DeleteAccessConfig(ctx context.Context, instance Locator, networkInterface, accessConfig string) (*ComputeEngineOperation, error) {
+	params := url.Values{}
+	params.Set("networkInterface", networkInterface)
+	params.Set("accessConfig", accessConfig)
+	var op ComputeEngineOperation
+	err := c.doRequest(ctx, http.MethodPost, fmt.Sprintf("%s/deleteAccessConfig", instanceURL(instance)), params, nil, &op)
+	if err != nil {
+		return nil, err
+	}
+	return &op, nil
+}
+
 // ListInstances lists GCE VM instances in a specified zone. filter is optional.
 // Currently only returns the first page
 func (c *APIClient) ListInstances(ctx context.Context, zone Locator, filter string) ([]Instance, error) {
@@ -258,10 +345,8 @@ func (c *APIClient) GetStaticIP(ctx context.Context, region RegionLocator, addre
 	var ip StaticIp
 
 	if err := c.doRequest(ctx, http.MethodGet, fmt.Sprintf("%s/addresses/%s", regionURL(region), addressName), nil, nil, &ip); err != nil {
-		// Handle 404 Not Found specifically if needed
-		var httpErr *HttpError
-		if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound {
-			return nil, nil // Indicate not found without erroring? Or return a specific error.
+		if errors.Is(err, common.ErrNotFound) {
+			return nil, nil // Indicate not found without erroring
 		}
 		return nil, err
 	}
@@ -276,9 +361,7 @@ func (c *APIClient) GetGuestAttributes(ctx context.Context, instance Locator, na
 	var attrs GuestAttributes
 	err := c.doRequest(ctx, http.MethodGet, fmt.Sprintf("%s/getGuestAttributes", instanceURL(instance)), params, nil, &attrs)
 	if err != nil {
-		// Check for 404 specifically if needed (guest attributes not set)
-		var httpErr *HttpError
-		if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound {
+		if errors.Is(err, common.ErrNotFound) {
 			return nil, nil // Return nil to indicate attributes not found/set
 		}
 		return nil, err
@@ -303,14 +386,30 @@ func (c *APIClient) CreateFirewall(ctx context.Context, projectID string, data F
 	return c.GetFirewall(ctx, projectID, data.Name)
 }
 
+// UpdateFirewall patches an existing firewall rule. Fields set in data replace the current ones.
+// It waits for the operation to complete.
+func (c *APIClient) UpdateFirewall(ctx context.Context, projectID, firewallName string, data Firewall) (*Firewall, error) {
+	var op ComputeEngineOperation
+	err := c.doRequest(ctx, http.MethodPatch, fmt.Sprintf("%s/global/firewalls/%s", projectURL(projectID), firewallName), nil, data, &op)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initiate firewall update: %w", err)
+	}
+
+	_, err = c.ComputeEngineOperationGlobalWait(ctx, projectID, op.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed waiting for firewall update: %w", err)
+	}
+
+	return c.GetFirewall(ctx, projectID, firewallName)
+}
+
 // GetFirewall retrieves details of a specific firewall rule.
 func (c *APIClient) GetFirewall(ctx context.Context, projectID, firewallName string) (*Firewall, error) {
 	var fw Firewall
 	err := c.doRequest(ctx, http.MethodGet, fmt.Sprintf("%s/global/firewalls/%s", projectURL(projectID), firewallName), nil, nil, &fw)
 	if err != nil {
-		var httpErr *HttpError
-		if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound {
-			return nil, nil // Or a specific "not found" error
+		if errors.Is(err, common.ErrNotFound) {
+			return nil, nil // Firewall does not exist
 		}
 		return nil, err
 	}
@@ -332,6 +431,122 @@ func (c *APIClient) ListFirewalls(ctx context.Context, projectID string, filter
 	return resp.Items, nil
 }
 
+// CreateNetwork creates a VPC network.
+// It waits for the operation to complete.
+func (c *APIClient) CreateNetwork(ctx context.Context, projectID string, data Network) (*Network, error) {
+	var op ComputeEngineOperation
+	err := c.doRequest(ctx, http.MethodPost, fmt.Sprintf("%s/global/networks", projectURL(projectID)), nil, data, &op)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initiate network creation: %w", err)
+	}
+	if _, err = c.ComputeEngineOperationGlobalWait(ctx, projectID, op.Name); err != nil {
+		return nil, fmt.Errorf("failed waiting for network creation: %w", err)
+	}
+	return c.GetNetwork(ctx, projectID, data.Name)
+}
+
+// GetNetwork retrieves a VPC network. It returns nil if the network doesn't exist.
+func (c *APIClient) GetNetwork(ctx context.Context, projectID, networkName string) (*Network, error) {
+	var network Network
+	err := c.doRequest(ctx, http.MethodGet, fmt.Sprintf("%s/global/networks/%s", projectURL(projectID), networkName), nil, nil, &network)
+	if err != nil {
+		if errors.Is(err, common.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &network, nil
+}
+
+// GetImageFromFamily returns the latest image of an image family in a project, e.g. "debian-12" in "debian-cloud".
+func (c *APIClient) GetImageFromFamily(ctx context.Context, projectID, family string) (*Image, error) {
+	var image Image
+	err := c.doRequest(ctx, http.MethodGet, fmt.Sprintf("%s/global/images/family/%s", projectURL(projectID), family), nil, nil, &image)
+	if err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+// CreateSubnetwork creates a subnet in a region.
+// It waits for the operation to complete.
+func (c *APIClient) CreateSubnetwork(ctx context.Context, region RegionLocator, data Subnetwork) (*Subnetwork, error) {
+	var op ComputeEngineOperation
+	err := c.doRequest(ctx, http.MethodPost, fmt.Sprintf("%s/subnetworks", regionURL(region)), nil, data, &op)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initiate subnetwork creation: %w", err)
+	}
+	if _, err = c.ComputeEngineOperationRegionWait(ctx, region, op.Name); err != nil {
+		return nil, fmt.Errorf("failed waiting for subnetwork creation: %w", err)
+	}
+	return c.GetSubnetwork(ctx, region, data.Name)
+}
+
+// UpdateSubnetwork patches a subnet. data must include the current fingerprint.
+// It waits for the operation to complete.
+func (c *APIClient) UpdateSubnetwork(ctx context.Context, region RegionLocator, subnetworkName string, data Subnetwork) (*Subnetwork, error) {
+	var op ComputeEngineOperation
+	err := c.doRequest(ctx, http.MethodPatch, fmt.Sprintf("%s/subnetworks/%s", regionURL(region), subnetworkName), nil, data, &op)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initiate subnetwork update: %w", err)
+	}
+	if _, err = c.ComputeEngineOperationRegionWait(ctx, region, op.Name); err != nil {
+		return nil, fmt.Errorf("failed waiting for subnetwork update: %w", err)
+	}
+	return c.GetSubnetwork(ctx, region, subnetworkName)
+}
+
+// GetSubnetwork retrieves a subnet. It returns nil if the subnet doesn't exist.
+func (c *APIClient) GetSubnetwork(ctx context.Context, region RegionLocator, subnetworkName string) (*Subnetwork, error) {
+	var subnet Subnetwork
+	err := c.doRequest(ctx, http.MethodGet, fmt.Sprintf("%s/subnetworks/%s", regionURL(region), subnetworkName), nil, nil, &subnet)
+	if err != nil {
+		if errors.Is(err, common.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &subnet, nil
+}
+
+// ListAllStaticIPs lists addresses in all regions of a project. filter is optional.
+// Currently only returns the first page
+func (c *APIClient) ListAllStaticIPs(ctx context.Context, projectID string, filter string) ([]StaticIp, error) {
+	params := url.Values{}
+	if filter != "" {
+		params.Set("filter", filter)
+	}
+	var resp listAllAddressesResponse
+	err := c.doRequest(ctx, http.MethodGet, fmt.Sprintf("%s/aggregated/addresses", projectURL(projectID)), params, nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	var result []StaticIp
+	for _, val := range resp.Items {
+		result = append(result, val.Addresses...)
+	}
+	return result, nil
+}
+
+// ListAllSubnetworks lists subnets in all regions of a project. filter is optional.
+// Currently only returns the first page
+func (c *APIClient) ListAllSubnetworks(ctx context.Context, projectID string, filter string) ([]Subnetwork, error) {
+	params := url.Values{}
+	if filter != "" {
+		params.Set("filter", filter)
+	}
+	var resp listAllSubnetworksResponse
+	err := c.doRequest(ctx, http.MethodGet, fmt.Sprintf("%s/aggregated/subnetworks", projectURL(projectID)), params, nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	var result []Subnetwork
+	for _, val := range resp.Items {
+		result = append(result, val.Subnetworks...)
+	}
+	return result, nil
+}
+
 // ListZones lists available zones for a project.
 // TODO: Implement pagination.
 func (c *APIClient) ListZones(ctx context.Context, projectID string) ([]Zone, error) {
@@ -383,9 +598,9 @@ type CreateProjectRequest struct {
 type CreateProjectRequestParent struct {
 	Type string `json:"type"`
 	ID   string `json:"id"`
-} // e.g., "organization", "folder"
+} // Type is "organization" or "folder"
 
-// CreateProject creates a new GCP project. data should be a struct like:
+// CreateProject creates a new GCP project.
 func (c *APIClient) CreateProject(ctx context.Context, data CreateProjectRequest) (*ResourceManagerOperation, error) {
 	var op ResourceManagerOperation
 	err := c.doRequest(ctx, http.MethodPost, fmt.Sprintf("%s/projects", resourceManagerV1API), nil, data, &op)
@@ -417,20 +632,22 @@ func (c *APIClient) GetProjectBillingInfo(ctx context.Context, projectID string)
 	var info ProjectBillingInfo
 	err := c.doRequest(ctx, http.MethodGet, fmt.Sprintf("%s/projects/%s/billingInfo", billingV1API, projectID), nil, nil, &info)
 	if err != nil {
-		var httpErr *HttpError
-		if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound {
+		if errors.Is(err, common.ErrNotFound) {
 			// API returns 403 if billing API not enabled, 404 might mean project doesn't exist
 			// or sometimes if no billing account is linked. Treat as potentially "no info".
-			return nil, nil // Or return a specific error type
+			return nil, nil
 		}
 		return nil, err
 	}
 	return &info, nil
 }
 
-// UpdateProjectBillingInfo links a project to a billing account. data should be struct like:
-// type UpdateBillingInfoRequest struct { BillingAccountName string `json:"billingAccountName"` }
-func (c *APIClient) UpdateProjectBillingInfo(ctx context.Context, projectID string, data interface{}) (*ProjectBillingInfo, error) {
+type UpdateBillingInfoRequest struct {
+	BillingAccountName string `json:"billingAccountName"` // Format: billingAccounts/{billing_account_id}
+}
+
+// UpdateProjectBillingInfo links a project to a billing account.
+func (c *APIClient) UpdateProjectBillingInfo(ctx context.Context, projectID string, data UpdateBillingInfoRequest) (*ProjectBillingInfo, error) {
 	var info ProjectBillingInfo
 	err := c.doRequest(ctx, http.MethodPut, fmt.Sprintf("%s/projects/%s/billingInfo", billingV1API, projectID), nil, data, &info)
 	if err != nil {
@@ -474,17 +691,14 @@ const (
 )
 
 // checkOperationError checks a completed operation for API errors.
-func checkOperationError(status string, opError *computeEngineOperationError) error {
-	if status == "DONE" && opError != nil && len(opError.Errors) > 0 {
-		// Return the first error
-		return &Error{
-			Code:    opError.Errors[0].Code, // Note: GCE uses numeric codes in `errors.code`, often matching HTTP status
-			Message: opError.Errors[0].Message,
-		}
+func checkOperationError(op *ComputeEngineOperation) error {
+	if op.Status == "DONE" && op.Error != nil && len(op.Error.Errors) > 0 {
+		// Return the first error. GCE uses string codes in `errors.code`, e.g. "QUOTA_EXCEEDED"
+		return newOperationError(op)
 	}
-	if status != "DONE" {
+	if op.Status != "DONE" {
 		// Should not happen if polling logic is correct, but safeguard
-		return fmt.Errorf("operation finished polling but status is %s", status)
+		return fmt.Errorf("operation finished polling but status is %s", op.Status)
 	}
 	return nil
 }
@@ -499,22 +713,21 @@ func (c *APIClient) computeEngineOperationScopeWait(ctx context.Context, scope,
 				return nil, fmt.Errorf("timeout waiting for %s operation %s", scope, operationName)
 			}
 			var op ComputeEngineOperation
+			// doRequest already retried transient errors, so anything left is fatal for this operation.
 			err := c.doRequest(ctx, http.MethodGet, url, nil, nil, &op)
 			if err != nil {
-				// Don't necessarily stop polling on transient HTTP errors, but log/handle
 				// If it's a 404, the operation might be invalid.
-				var httpErr *HttpError
-				if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound {
+				if errors.Is(err, common.ErrNotFound) {
 					return nil, fmt.Errorf("%s operation %s not found: %w", scope, operationName, err)
 				}
-				// Maybe retry a few times before failing hard
-				slog.Debug("Warning: error polling operation. Retrying...", "op", operationName, "scope", scope, "err", err)
-			} else {
-				if op.Status == "DONE" {
-					return &op, checkOperationError(op.Status, op.Error)
-				}
+				return nil, fmt.Errorf("failed to poll %s operation %s: %w", scope, operationName, err)
+			}
+			if op.Status == "DONE" {
+				return &op, checkOperationError(&op)
+			}
+			if err := common.Sleep(ctx, operationPollInterval); err != nil {
+				return nil, fmt.Errorf("context cancelled/timed out while waiting for %s operation %s: %w", scope, operationName, err)
 			}
-			time.Sleep(operationPollInterval)
 		}
 	}
 }
@@ -545,7 +758,7 @@ func (c *APIClient) ResourceManagerOperationGet(ctx context.Context, operationNa
 		return nil, err
 	}
 	if op.Done && op.Error != nil {
-		return &op, &Error{Code: op.Error.Code, Message: op.Error.Message}
+		return &op, newRPCError(op.Error)
 	}
 	return &op, nil
 }
@@ -559,11 +772,47 @@ func (c *APIClient) ServiceUsageOperationGet(ctx context.Context, operationName
 		return nil, err
 	}
 	if op.Done && op.Error != nil {
-		return &op, &Error{Code: op.Error.Code, Message: op.Error.Message}
+		return &op, newRPCError(op.Error)
 	}
 	return &op, nil
 }
 
+// ResourceManagerOperationWait polls a Resource Manager operation until it's done.
+func (c *APIClient) ResourceManagerOperationWait(ctx context.Context, operationName string) (*ResourceManagerOperation, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationPollTimeout)
+	defer cancel()
+	for {
+		op, err := c.ResourceManagerOperationGet(ctx, operationName)
+		if err != nil {
+			return op, fmt.Errorf("resource manager operation %s failed: %w", operationName, err)
+		}
+		if op.Done {
+			return op, nil
+		}
+		if err := common.Sleep(ctx, operationPollInterval); err != nil {
+			return nil, fmt.Errorf("context cancelled/timed out while waiting for resource manager operation %s: %w", operationName, err)
+		}
+	}
+}
+
+// ServiceUsageOperationWait polls a Service Usage operation until it's done.
+func (c *APIClient) ServiceUsageOperationWait(ctx context.Context, operationName string) (*ServiceUsageOperation, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationPollTimeout)
+	defer cancel()
+	for {
+		op, err := c.ServiceUsageOperationGet(ctx, operationName)
+		if err != nil {
+			return op, fmt.Errorf("service usage operation %s failed: %w", operationName, err)
+		}
+		if op.Done {
+			return op, nil
+		}
+		if err := common.Sleep(ctx, operationPollInterval); err != nil {
+			return nil, fmt.Errorf("context cancelled/timed out while waiting for service usage operation %s: %w", operationName, err)
+		}
+	}
+}
+
 // --- User Info ---
 
 // GetUserInfo fetches OpenID Connect user profile information.
@@ -577,83 +826,174 @@ func (c *APIClient) GetUserInfo(ctx context.Context) (*UserInfo, error) {
 	return &userInfo, nil
 }
 
-func IsProjectHealthy(ctx context.Context, client *APIClient, projectID string) bool {
-	bi, err := client.GetProjectBillingInfo(ctx, projectID)
-	if err != nil {
-		slog.Error("Error getting project billing info", "projectID", projectID, "error", err)
-		return false
-	}
-	if bi == nil || bi.BillingEnabled == nil || !*bi.BillingEnabled {
-		slog.Error("Project billing not enabled", "projectID", projectID)
-		return false
-	}
-	services, err := client.ListEnabledServices(ctx, projectID)
-	if err != nil {
-		slog.Error("Error listing enabled services", "projectID", projectID, "error", err)
-		return false
-	}
-	for _, service := range requiredServices {
-		if !slices.ContainsFunc(services, func(s Service) bool {
-			return strings.HasSuffix(s.Name, service)
-		}) {
-			slog.Error("Required service not enabled", "projectID", projectID, "service", service)
-			return false
-		}
-	}
-	return true
+// CreateFirewallIfNeeded makes sure the lantern firewall rule allows ingress to the given ports from anywhere,
+// over both IPv4 and IPv6. The ports include SSH's, e.g. InstallProfile.SSHPort(), so a hardened server's
+// port 22 isn't opened. The rule is created if it doesn't exist. An existing rule is updated in place:
+// the requested ports are added to the ports it already allows. It is shared by all Lantern instances in
+// the project, so nothing it allows is removed, including over-broad entries allowing all protocols or ports.
+func CreateFirewallIfNeeded(ctx context.Context, client *APIClient, projectID string, ports []common.PortRange) error {
+	return CreateNetworkFirewallIfNeeded(ctx, client, projectID, defaultNetwork, ports)
 }
 
-func CreateFirewallIfNeeded(ctx context.Context, client *APIClient, projectID string) error {
-	// Check if the firewall already exists
-	firewalls, err := client.ListFirewalls(ctx, projectID, fmt.Sprintf("name=%s", firewallName))
+// CreateNetworkFirewallIfNeeded is like CreateFirewallIfNeeded for a specific VPC network.
+// Firewall rules belong to a single network, so each network gets its own lantern rule.
+func CreateNetworkFirewallIfNeeded(ctx context.Context, client *APIClient, projectID, network string, ports []common.PortRange) error {
+	sourceRanges := []string{common.AnyIPv4, common.AnyIPv6}
+	ruleName := networkFirewallName(network)
+
+	existing, err := client.GetFirewall(ctx, projectID, ruleName)
 	if err != nil {
-		return fmt.Errorf("failed to list firewalls: %w", err)
+		return fmt.Errorf("failed to get firewall: %w", err)
 	}
-	if len(firewalls) > 0 {
-		slog.Info("Firewall already exists", "firewallName", firewallName)
+	if existing != nil {
+		if _, broad := firewallRulePorts(existing.Allowed); len(broad) > 0 {
+			slog.Warn("Firewall allows more than specific ports, leaving those entries in place", "firewallName", ruleName, "rules", broad)
+		}
+		allowed, changed := mergeFirewallRules(existing.Allowed, ports)
+		if !changed && slices.Equal(existing.SourceRanges, sourceRanges) {
+			slog.Info("Firewall already up to date", "firewallName", ruleName)
+			return nil
+		}
+		slog.Info("Updating firewall", "firewallName", ruleName, "ports", ports)
+		if _, err := client.UpdateFirewall(ctx, projectID, ruleName, Firewall{
+			Name:         ruleName,
+			Description:  firewallDescription,
+			Priority:     existing.Priority,
+			Direction:    "INGRESS",
+			Allowed:      allowed,
+			SourceRanges: sourceRanges,
+			TargetTags:   []string{firewallTag},
+		}); err != nil {
+			return fmt.Errorf("failed to update firewall: %w", err)
+		}
 		return nil
 	}
 
 	// Create the firewall
 	firewall := Firewall{
-		Name:        firewallName,
-		TargetTags:  []string{firewallName},
-		Description: "Allow all",
-		Priority:    1000,
-		Direction:   "INGRESS",
-		Allowed: []Rule{
-			{IPProtocol: "all"},
-		},
-		SourceRanges: []string{"0.0.0.0/0"},
+		Name:         ruleName,
+		Network:      networkURL(network),
+		TargetTags:   []string{firewallTag},
+		Description:  firewallDescription,
+		Priority:     1000,
+		Direction:    "INGRESS",
+		Allowed:      firewallRules(ports),
+		SourceRanges: sourceRanges,
 	}
-	op, err := client.CreateFirewall(ctx, projectID, firewall)
+	fw, err := client.CreateFirewall(ctx, projectID, firewall)
 	if err != nil {
 		return fmt.Errorf("failed to create firewall: %w", err)
 	}
-	slog.Info("Firewall created successfully", "operationName", op.Name)
+	slog.Info("Firewall created successfully", "firewallName", fw.Name, "ports", ports)
 	return nil
 }
 
-func CreateInstance(ctx context.Context, client *APIClient, projectID string, zoneID string, publicSSHKey string) (string, string, error) {
+// networkFirewallName returns the name of the lantern firewall rule for a network.
+func networkFirewallName(network string) string {
+	if network == defaultNetwork {
+		return firewallName
+	}
+	return network + "-firewall"
+}
+
+// firewallRules groups ports by protocol into GCP firewall rules.
+func firewallRules(ports []common.PortRange) []Rule {
+	var rules []Rule
+	for _, p := range common.MergePorts(ports) {
+		if len(rules) == 0 || rules[len(rules)-1].IPProtocol != p.Protocol {
+			rules = append(rules, Rule{IPProtocol: p.Protocol})
+		}
+		rules[len(rules)-1].Ports = append(rules[len(rules)-1].Ports, p.Ports())
+	}
+	return rules
+}
+
+// firewallRulePorts converts GCP firewall rules back to ports. Rules that can't be expressed as ports are
+// returned as broad: those allowing all protocols, all ports of a protocol, or a protocol other than TCP
+// and UDP.
+func firewallRulePorts(rules []Rule) (ports []common.PortRange, broad []Rule) {
+	for _, rule := range rules {
+		if (rule.IPProtocol != "tcp" && rule.IPProtocol != "udp") || len(rule.Ports) == 0 {
+			broad = append(broad, rule)
+			continue
+		}
+		var rulePorts []common.PortRange
+		for _, p := range rule.Ports {
+			port, err := common.ParsePortRange(p + "/" + rule.IPProtocol)
+			if err != nil {
+				break
+			}
+			rulePorts = append(rulePorts, port)
+		}
+		if len(rulePorts) < len(rule.Ports) {
+			broad = append(broad, rule)
+			continue
+		}
+		ports = append(ports, rulePorts...)
+	}
+	return common.MergePorts(ports), broad
+}
+
+// mergeFirewallRules adds ports to the rules of the shared firewall rule. Broad rules are kept as they are,
+// other instances may rely on them. changed is false if the rules already allow all ports.
+func mergeFirewallRules(existing []Rule, ports []common.PortRange) (rules []Rule, changed bool) {
+	current, broad := firewallRulePorts(existing)
+	allowed := func(p common.PortRange) bool {
+		return slices.ContainsFunc(current, func(c common.PortRange) bool { return c.Contains(p) }) ||
+			slices.ContainsFunc(broad, func(r Rule) bool {
+				return r.IPProtocol == "all" || (r.IPProtocol == p.Protocol && len(r.Ports) == 0)
+			})
+	}
+	var missing []common.PortRange
+	for _, p := range ports {
+		if !allowed(p) {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) == 0 {
+		return existing, false
+	}
+	return append(slices.Clone(broad), firewallRules(common.MergePorts(current, missing))...), true
+}
+
+// CreateInstance creates a Lantern instance and waits for it to be created.
+// With opts.IPv6 the instance is attached to the dual-stack lantern subnet, which must exist
+// (see EnsureDualStackNetwork), and gets an external IPv6 address. With opts.Spot it is a SPOT
+// instance that is stopped when preempted, so it can be restarted with its disk and address intact.
+func CreateInstance(ctx context.Context, client *APIClient, projectID string, zoneID string, publicSSHKey string, opts common.ProvisionOptions) (string, string, error) {
+	recipe, err := common.Recipe(opts.OS)
+	if err != nil {
+		return "", "", err
+	}
+	image, err := LatestImage(ctx, client, recipe.Target)
+	if err != nil {
+		return "", "", err
+	}
 	name := common.MakeInstanceName()
+	nic := InstanceNetworkInterface{
+		Network: networkURL(defaultNetwork),
+		// Empty accessConfigs necessary to allocate ephemeral IP
+		AccessConfigs: []NetworkInterfaceAccessConfig{{}},
+	}
+	if opts.IPv6 {
+		nic.Network = networkURL(lanternNetwork)
+		nic.Subnetwork = subnetworkURL(GetZoneRegionID(zoneID), lanternSubnet)
+		nic.StackType = "IPV4_IPV6"
+		nic.Ipv6AccessConfigs = []NetworkInterfaceAccessConfig{{Type: "DIRECT_IPV6", NetworkTier: "PREMIUM"}}
+	}
 	instance := Instance{
 		Name:        name,
 		MachineType: fmt.Sprintf("zones/%s/machineTypes/%s", zoneID, machineSize),
 		Disks: []InstanceDisk{
 			{
 				Boot:             true,
-				InitializeParams: DiskInitParams{SourceImage: "projects/ubuntu-os-cloud/global/images/family/ubuntu-2204-lts"},
+				InitializeParams: DiskInitParams{SourceImage: image},
 			},
 		},
-		NetworkInterfaces: []InstanceNetworkInterface{
-			{
-				Network: "global/networks/default",
-				// Empty accessConfigs necessary to allocate ephemeral IP
-				AccessConfigs: []NetworkInterfaceAccessConfig{{}},
-			},
-		},
-		Tags:   &InstanceTags{Items: []string{firewallName}},
-		Labels: map[string]string{},
+		NetworkInterfaces: []InstanceNetworkInterface{nic},
+		Tags:              &InstanceTags{Items: []string{firewallTag}},
+		Labels:            map[string]string{},
+		Scheduling:        instanceScheduling(opts),
 		Metadata: InstanceMetadata{Items: []InstanceMetadataItem{
 			{
 				Key:   "enable-guest-attributes",
@@ -661,10 +1001,14 @@ func CreateInstance(ctx context.Context, client *APIClient, projectID string, zo
 			},
 			{
 				Key:   "ssh-keys",
-				Value: "ubuntu:" + publicSSHKey,
+				Value: recipe.DefaultUser + ":" + publicSSHKey,
 			},
 		}},
 	}
+	if script := recipe.FirstBootScript(opts.InstallProfile()); script != "" {
+		// Run by the guest environment, which all images have, unlike cloud-init
+		instance.Metadata.Items = append(instance.Metadata.Items, InstanceMetadataItem{Key: "startup-script", Value: script})
+	}
 	loc := Locator{ZoneID: zoneID, ProjectID: projectID}
 	op, err := client.CreateInstance(ctx, loc, instance)
 	if err != nil {
@@ -0,0 +1,115 @@
+package gcp
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+func TestFirewallRulePorts(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []Rule
+		ports []common.PortRange
+		broad []Rule
+	}{
+		{
+			name:  "specific ports",
+			rules: []Rule{{IPProtocol: "tcp", Ports: []string{"22", "443"}}, {IPProtocol: "udp", Ports: []string{"30000-30100"}}},
+			ports: []common.PortRange{common.Port("tcp", 22), common.Port("tcp", 443), {Protocol: "udp", From: 30000, To: 30100}},
+		},
+		{
+			name:  "all protocols",
+			rules: []Rule{{IPProtocol: "all"}, {IPProtocol: "tcp", Ports: []string{"22"}}},
+			ports: []common.PortRange{common.SSHPort},
+			broad: []Rule{{IPProtocol: "all"}},
+		},
+		{
+			name:  "all ports of a protocol",
+			rules: []Rule{{IPProtocol: "udp"}},
+			broad: []Rule{{IPProtocol: "udp"}},
+		},
+		{
+			name:  "other protocol",
+			rules: []Rule{{IPProtocol: "icmp"}},
+			broad: []Rule{{IPProtocol: "icmp"}},
+		},
+		{
+			name:  "unparseable port keeps the whole rule",
+			rules: []Rule{{IPProtocol: "tcp", Ports: []string{"443", "0"}}},
+			broad: []Rule{{IPProtocol: "tcp", Ports: []string{"443", "0"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ports, broad := firewallRulePorts(tt.rules)
+			if !reflect.DeepEqual(ports, tt.ports) {
+				t.Errorf("ports = %v, want %v", ports, tt.ports)
+			}
+			if !reflect.DeepEqual(broad, tt.broad) {
+				t.Errorf("broad = %v, want %v", broad, tt.broad)
+			}
+		})
+	}
+}
+
+func TestMergeFirewallRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []Rule
+		ports    []common.PortRange
+		want     []Rule
+		changed  bool
+	}{
+		{
+			name:     "ports already allowed",
+			existing: []Rule{{IPProtocol: "tcp", Ports: []string{"22", "443"}}},
+			ports:    []common.PortRange{common.Port("tcp", 443)},
+			want:     []Rule{{IPProtocol: "tcp", Ports: []string{"22", "443"}}},
+		},
+		{
+			name:     "ports of other instances are kept",
+			existing: []Rule{{IPProtocol: "tcp", Ports: []string{"22", "443"}}},
+			ports:    []common.PortRange{common.SSHPort, common.Port("udp", 8443)},
+			want:     []Rule{{IPProtocol: "tcp", Ports: []string{"22", "443"}}, {IPProtocol: "udp", Ports: []string{"8443"}}},
+			changed:  true,
+		},
+		{
+			name:     "covered by a broad rule",
+			existing: []Rule{{IPProtocol: "udp"}, {IPProtocol: "tcp", Ports: []string{"22"}}},
+			ports:    []common.PortRange{common.SSHPort, common.Port("udp", 8443)},
+			want:     []Rule{{IPProtocol: "udp"}, {IPProtocol: "tcp", Ports: []string{"22"}}},
+		},
+		{
+			name:     "covered by a range",
+			existing: []Rule{{IPProtocol: "udp", Ports: []string{"30000-30100"}}},
+			ports:    []common.PortRange{common.Port("udp", 30050)},
+			want:     []Rule{{IPProtocol: "udp", Ports: []string{"30000-30100"}}},
+		},
+		{
+			name:     "broad rules are kept when adding ports",
+			existing: []Rule{{IPProtocol: "all"}},
+			ports:    []common.PortRange{common.SSHPort},
+			want:     []Rule{{IPProtocol: "all"}},
+		},
+		{
+			name:     "broad rules of other protocols are kept when adding ports",
+			existing: []Rule{{IPProtocol: "icmp"}, {IPProtocol: "udp"}, {IPProtocol: "tcp", Ports: []string{"443"}}},
+			ports:    []common.PortRange{common.SSHPort},
+			want:     []Rule{{IPProtocol: "icmp"}, {IPProtocol: "udp"}, {IPProtocol: "tcp", Ports: []string{"22", "443"}}},
+			changed:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed := mergeFirewallRules(tt.existing, tt.ports)
+			if changed != tt.changed {
+				t.Errorf("changed = %v, want %v", changed, tt.changed)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rules = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
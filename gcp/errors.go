@@ -0,0 +1,139 @@
+package gcp
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+const providerName = "gcp"
+
+// Canonical google.rpc.Code values, used by long-running operation errors.
+// See https://cloud.google.com/apis/design/errors#handling_errors
+const (
+	rpcNotFound           = 5
+	rpcPermissionDenied   = 7
+	rpcResourceExhausted  = 8
+	rpcFailedPrecondition = 9
+	rpcUnavailable        = 14
+	rpcUnauthenticated    = 16
+)
+
+// reasonCategories maps GCP error reasons and codes to the common error taxonomy.
+var reasonCategories = map[string]error{
+	"BILLING_DISABLED":                          common.ErrBillingDisabled,
+	"billingNotEnabled":                         common.ErrBillingDisabled,
+	"accountDisabled":                           common.ErrBillingDisabled,
+	"UREQ_PROJECT_BILLING_NOT_FOUND":            common.ErrBillingDisabled,
+	"SERVICE_DISABLED":                          common.ErrServiceDisabled,
+	"accessNotConfigured":                       common.ErrServiceDisabled,
+	"RATE_LIMIT_EXCEEDED":                       common.ErrRateLimited,
+	"rateLimitExceeded":                         common.ErrRateLimited,
+	"userRateLimitExceeded":                     common.ErrRateLimited,
+	"QUOTA_EXCEEDED":                            common.ErrQuotaExceeded,
+	"quotaExceeded":                             common.ErrQuotaExceeded,
+	"ZONE_RESOURCE_POOL_EXHAUSTED":              common.ErrRegionUnavailable,
+	"ZONE_RESOURCE_POOL_EXHAUSTED_WITH_DETAILS": common.ErrRegionUnavailable,
+	"RESOURCE_NOT_READY":                        common.ErrRegionUnavailable,
+	"UNSUPPORTED_OPERATION":                     common.ErrRegionUnavailable,
+	"ACCESS_TOKEN_EXPIRED":                      common.ErrUnauthorized,
+	"CREDENTIALS_MISSING":                       common.ErrUnauthorized,
+	"authError":                                 common.ErrUnauthorized,
+	"notFound":                                  common.ErrNotFound,
+	"RESOURCE_NOT_FOUND":                        common.ErrNotFound,
+	"forbidden":                                 common.ErrPermissionDenied,
+	"IAM_PERMISSION_DENIED":                     common.ErrPermissionDenied,
+}
+
+// classify returns the common category for a GCP error.
+// statusCode is an HTTP status code; reasons are checked first as they are the most specific.
+// Messages aren't looked at: they are meant for people and mention e.g. billing in unrelated errors.
+func classify(statusCode int, status string, reasons []string) error {
+	for _, reason := range reasons {
+		if category, ok := reasonCategories[reason]; ok {
+			return category
+		}
+	}
+	switch status {
+	case "UNAUTHENTICATED":
+		return common.ErrUnauthorized
+	case "PERMISSION_DENIED":
+		return common.ErrPermissionDenied
+	case "UNAVAILABLE":
+		return common.ErrTransient
+	}
+	return common.CategoryForStatus(statusCode)
+}
+
+// newStatusError converts a GCP error status returned with an HTTP response into a classified error.
+func newStatusError(statusCode int, st Status, raw error) *common.ProviderError {
+	reasons := st.reasons()
+	code := st.Status
+	if len(reasons) > 0 {
+		code = reasons[0]
+	}
+	var gcpErr *Error
+	if errors.As(raw, &gcpErr) {
+		gcpErr.Status = st.Status
+		gcpErr.Reason = code
+	}
+	return &common.ProviderError{
+		Provider:   providerName,
+		Category:   classify(statusCode, st.Status, reasons),
+		StatusCode: statusCode,
+		Code:       code,
+		Message:    st.Message,
+		Err:        raw,
+	}
+}
+
+// newHTTPError classifies a non-JSON HTTP error response.
+func newHTTPError(e *HttpError) *common.ProviderError {
+	return &common.ProviderError{
+		Provider:   providerName,
+		Category:   common.CategoryForStatus(e.StatusCode),
+		StatusCode: e.StatusCode,
+		Code:       http.StatusText(e.StatusCode),
+		Message:    e.Body,
+		Err:        e,
+	}
+}
+
+// newRPCError classifies an error reported by a long-running Resource Manager or Service Usage operation,
+// which uses google.rpc.Code values instead of HTTP status codes.
+func newRPCError(st *Status) *common.ProviderError {
+	rpc := *st
+	var statusCode int
+	switch st.Code {
+	case rpcNotFound:
+		statusCode, rpc.Status = http.StatusNotFound, "NOT_FOUND"
+	case rpcPermissionDenied:
+		statusCode, rpc.Status = http.StatusForbidden, "PERMISSION_DENIED"
+	case rpcResourceExhausted:
+		statusCode, rpc.Status = http.StatusTooManyRequests, "RESOURCE_EXHAUSTED"
+	case rpcFailedPrecondition:
+		statusCode, rpc.Status = http.StatusBadRequest, "FAILED_PRECONDITION"
+	case rpcUnavailable:
+		statusCode, rpc.Status = http.StatusServiceUnavailable, "UNAVAILABLE"
+	case rpcUnauthenticated:
+		statusCode, rpc.Status = http.StatusUnauthorized, "UNAUTHENTICATED"
+	}
+	if st.Status != "" {
+		rpc.Status = st.Status
+	}
+	return newStatusError(statusCode, rpc, &Error{Code: st.Code, Message: st.Message})
+}
+
+// newOperationError classifies the first error of a failed Compute Engine operation.
+func newOperationError(op *ComputeEngineOperation) *common.ProviderError {
+	first := op.Error.Errors[0]
+	return &common.ProviderError{
+		Provider:   providerName,
+		Category:   classify(op.HttpErrorStatusCode, "", []string{first.Code}),
+		StatusCode: op.HttpErrorStatusCode,
+		Code:       first.Code,
+		Message:    first.Message,
+		Err:        &Error{Code: op.HttpErrorStatusCode, Message: first.Message, Reason: first.Code},
+	}
+}
@@ -0,0 +1,163 @@
+package gcp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+func TestNewStatusError(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		want       error
+	}{
+		{
+			name:       "service disabled",
+			statusCode: http.StatusForbidden,
+			body: `{"code": 403, "message": "Compute Engine API has not been used in project 123 before or it is disabled.", "status": "PERMISSION_DENIED",
+				"details": [{"@type": "type.googleapis.com/google.rpc.ErrorInfo", "reason": "SERVICE_DISABLED", "domain": "googleapis.com"}]}`,
+			want: common.ErrServiceDisabled,
+		},
+		{
+			name:       "legacy service disabled",
+			statusCode: http.StatusForbidden,
+			body:       `{"code": 403, "message": "Access Not Configured.", "errors": [{"reason": "accessNotConfigured", "domain": "usageLimits"}]}`,
+			want:       common.ErrServiceDisabled,
+		},
+		{
+			name:       "billing disabled",
+			statusCode: http.StatusForbidden,
+			body: `{"code": 403, "message": "This API method requires billing to be enabled.", "status": "PERMISSION_DENIED",
+				"details": [{"@type": "type.googleapis.com/google.rpc.ErrorInfo", "reason": "BILLING_DISABLED"}]}`,
+			want: common.ErrBillingDisabled,
+		},
+		{
+			name:       "billing mentioned without a reason",
+			statusCode: http.StatusForbidden,
+			body:       `{"code": 403, "message": "Permission 'billing.resourceAssociations.create' denied on the billing account.", "status": "PERMISSION_DENIED"}`,
+			want:       common.ErrPermissionDenied,
+		},
+		{
+			name:       "billing mentioned in an invalid request",
+			statusCode: http.StatusBadRequest,
+			body:       `{"code": 400, "message": "Invalid value for field 'labels.billing': 'Team A'.", "status": "INVALID_ARGUMENT"}`,
+			want:       nil,
+		},
+		{
+			name:       "quota",
+			statusCode: http.StatusForbidden,
+			body:       `{"code": 403, "message": "Quota 'CPUS' exceeded. Limit: 8.0 in region us-central1.", "errors": [{"reason": "quotaExceeded"}]}`,
+			want:       common.ErrQuotaExceeded,
+		},
+		{
+			name:       "rate limit",
+			statusCode: http.StatusTooManyRequests,
+			body: `{"code": 429, "message": "Quota exceeded for quota metric 'Queries'.", "status": "RESOURCE_EXHAUSTED",
+				"details": [{"@type": "type.googleapis.com/google.rpc.ErrorInfo", "reason": "RATE_LIMIT_EXCEEDED"}]}`,
+			want: common.ErrRateLimited,
+		},
+		{
+			name:       "resource exhausted without a reason",
+			statusCode: http.StatusTooManyRequests,
+			body:       `{"code": 429, "message": "Quota exceeded.", "status": "RESOURCE_EXHAUSTED"}`,
+			want:       common.ErrRateLimited,
+		},
+		{
+			name:       "unauthenticated",
+			statusCode: http.StatusUnauthorized,
+			body:       `{"code": 401, "message": "Request had invalid authentication credentials.", "status": "UNAUTHENTICATED"}`,
+			want:       common.ErrUnauthorized,
+		},
+		{
+			name:       "not found",
+			statusCode: http.StatusNotFound,
+			body:       `{"code": 404, "message": "The resource 'projects/p/global/firewalls/lantern' was not found", "errors": [{"reason": "notFound"}]}`,
+			want:       common.ErrNotFound,
+		},
+		{
+			name:       "unavailable",
+			statusCode: http.StatusServiceUnavailable,
+			body:       `{"code": 503, "message": "The service is currently unavailable.", "status": "UNAVAILABLE"}`,
+			want:       common.ErrTransient,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var st Status
+			if err := json.Unmarshal([]byte(tt.body), &st); err != nil {
+				t.Fatal(err)
+			}
+			err := newStatusError(tt.statusCode, st, &Error{Code: st.Code, Message: st.Message})
+			if err.Category != tt.want {
+				t.Errorf("category = %v, want %v", err.Category, tt.want)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("%v isn't %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRPCError(t *testing.T) {
+	tests := []struct {
+		name string
+		st   Status
+		want error
+	}{
+		{
+			name: "billing not enabled",
+			st:   Status{Code: rpcFailedPrecondition, Message: "Billing must be enabled for activation of service 'compute.googleapis.com'.", Details: []StatusInfo{{Reason: "UREQ_PROJECT_BILLING_NOT_FOUND"}}},
+			want: common.ErrBillingDisabled,
+		},
+		{
+			name: "billing disabled",
+			st:   Status{Code: rpcFailedPrecondition, Message: "Billing account is disabled.", Details: []StatusInfo{{Reason: "BILLING_DISABLED"}}},
+			want: common.ErrBillingDisabled,
+		},
+		{name: "permission denied", st: Status{Code: rpcPermissionDenied, Message: "Permission denied on billing account."}, want: common.ErrPermissionDenied},
+		{name: "unavailable", st: Status{Code: rpcUnavailable}, want: common.ErrTransient},
+		{name: "unauthenticated", st: Status{Code: rpcUnauthenticated}, want: common.ErrUnauthorized},
+		{name: "not found", st: Status{Code: rpcNotFound}, want: common.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newRPCError(&tt.st).Category; got != tt.want {
+				t.Errorf("category = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewOperationError(t *testing.T) {
+	tests := []struct {
+		code       string
+		statusCode int
+		want       error
+	}{
+		{"QUOTA_EXCEEDED", http.StatusForbidden, common.ErrQuotaExceeded},
+		{"ZONE_RESOURCE_POOL_EXHAUSTED", http.StatusServiceUnavailable, common.ErrRegionUnavailable},
+		{"RESOURCE_NOT_FOUND", http.StatusNotFound, common.ErrNotFound},
+		{"INVALID_FIELD_VALUE", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			op := &ComputeEngineOperation{HttpErrorStatusCode: tt.statusCode, Error: &computeEngineOperationError{}}
+			op.Error.Errors = append(op.Error.Errors, struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			}{Code: tt.code, Message: "Operation failed; billing account 123 is active."})
+			err := newOperationError(op)
+			if err.Category != tt.want {
+				t.Errorf("category = %v, want %v", err.Category, tt.want)
+			}
+			if err.Code != tt.code {
+				t.Errorf("code = %s, want %s", err.Code, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+// imageFamily is a public image family, which always points to the latest image of a distribution.
+type imageFamily struct {
+	project string
+	family  string
+}
+
+var imageFamilies = map[common.OSTarget]imageFamily{
+	common.OSUbuntu2204: {project: "ubuntu-os-cloud", family: "ubuntu-2204-lts"},
+	common.OSUbuntu2404: {project: "ubuntu-os-cloud", family: "ubuntu-2404-lts-amd64"},
+	common.OSDebian12:   {project: "debian-cloud", family: "debian-12"},
+}
+
+// LatestImage returns the relative URL of the latest image of target, for DiskInitParams.SourceImage.
+// The image is resolved when the instance is created, so all instances created at the same time get the
+// same image, and an image family whose latest image was deprecated fails instead of installing it.
+func LatestImage(ctx context.Context, client *APIClient, target common.OSTarget) (string, error) {
+	f, ok := imageFamilies[target]
+	if !ok {
+		return "", fmt.Errorf("no GCP image for %s", target)
+	}
+	image, err := client.GetImageFromFamily(ctx, f.project, f.family)
+	if err != nil {
+		return "", fmt.Errorf("failed to get latest %s image: %w", target, err)
+	}
+	if image.Deprecated != nil && image.Deprecated.State != "" && image.Deprecated.State != "ACTIVE" {
+		return "", fmt.Errorf("latest %s image %s is %s", target, image.Name, image.Deprecated.State)
+	}
+	return fmt.Sprintf("projects/%s/global/images/%s", f.project, image.Name), nil
+}
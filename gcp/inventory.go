@@ -0,0 +1,160 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+// RefreshServer reconciles an inventory record with the instance's actual state.
+// It updates the record's status and addresses; a deleted instance is marked missing.
+func RefreshServer(ctx context.Context, client *APIClient, record *common.InventoryRecord) error {
+	server := &record.Server
+	loc := Locator{ProjectID: server.PlacementID, ZoneID: server.LocationID, InstanceID: server.InstanceName}
+	instance, err := client.GetInstance(ctx, loc)
+	if errors.Is(err, common.ErrNotFound) {
+		record.Status = common.ServerStatusMissing
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	record.Status = common.RefreshedStatus(record.Status, instanceStatus(instance))
+	if server.StaticIPName != "" {
+		region := RegionLocator{ProjectID: server.PlacementID, RegionID: GetZoneRegionID(server.LocationID)}
+		sip, err := client.GetStaticIP(ctx, region, server.StaticIPName)
+		if err != nil {
+			return err
+		}
+		if sip == nil {
+			// Released outside of the library, the instance keeps its address only until it is stopped
+			server.StaticIPName = ""
+		}
+	}
+	if ip := instance.ExternalIPv4(); ip != "" {
+		server.Config.ExternalIp = ip
+	}
+	server.Config.ExternalIpv6 = instance.ExternalIPv6()
+	return nil
+}
+
+// instanceStatus maps an instance status to the inventory status.
+func instanceStatus(instance *Instance) common.ServerStatus {
+	switch instance.Status {
+	case "STOPPING", "STOPPED", "SUSPENDING", "SUSPENDED", "TERMINATED":
+		return common.ServerStatusStopped
+	}
+	return common.ServerStatusActive
+}
+
+// DestroyInstance deletes an instance and releases its static IP address.
+// An instance that no longer exists is not an error, so a partially destroyed server can be cleaned up.
+func DestroyInstance(ctx context.Context, client *APIClient, server common.Server, progress common.ProgressFunc) error {
+	if progress == nil {
+		progress = func(string) {}
+	}
+	loc := Locator{ProjectID: server.PlacementID, ZoneID: server.LocationID, InstanceID: server.InstanceName}
+	progress(fmt.Sprintf("Deleting instance %s", server.InstanceName))
+	err := waitZoneOp(ctx, client, loc, func() (*ComputeEngineOperation, error) {
+		return client.DeleteInstance(ctx, loc)
+	})
+	if err != nil && !errors.Is(err, common.ErrNotFound) {
+		return fmt.Errorf("failed to delete instance %s: %w", server.InstanceName, err)
+	}
+	if server.StaticIPName != "" {
+		progress("Releasing the IP address")
+		releaseStaticIP(ctx, client, RegionLocator{ProjectID: server.PlacementID, RegionID: GetZoneRegionID(server.LocationID)}, server.StaticIPName)
+	}
+	return nil
+}
+
+// SetInventory implements common.ServerManager.
+func (p *Provisioner) SetInventory(inventory common.Inventory) {
+	p.inventory = inventory
+}
+
+// Inventory implements common.ServerManager.
+func (p *Provisioner) Inventory() common.Inventory {
+	return p.inventory
+}
+
+// SetSecretStore implements common.ServerManager.
+func (p *Provisioner) SetSecretStore(store common.SecretStore) {
+	p.secrets = store
+}
+
+// SecretStore implements common.ServerManager.
+func (p *Provisioner) SecretStore() common.SecretStore {
+	return p.secrets
+}
+
+// DestroyServer implements common.ServerManager.
+func (p *Provisioner) DestroyServer(ctx context.Context, id string) {
+	go func() {
+		p.session.Events <- common.Event{Type: common.EventTypeDestroyStarted, Message: id}
+		record, err := p.loadServer(ctx, id)
+		if err != nil {
+			slog.Error("Failed to load server", "id", id, "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeDestroyError, Error: err}
+			return
+		}
+		if err := DestroyInstance(ctx, p.client, record.Server, p.progress); err != nil {
+			slog.Error("Failed to destroy server", "id", id, "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeDestroyError, Error: err}
+			return
+		}
+		if err := common.ForgetServer(p.inventory, p.secrets, id); err != nil {
+			slog.Error("Failed to remove server from inventory", "id", id, "err", err)
+		}
+		p.session.Events <- common.Event{Type: common.EventTypeDestroyCompleted, Message: id, Server: &record.Server}
+	}()
+}
+
+// RotateServerIP implements common.ServerManager.
+func (p *Provisioner) RotateServerIP(ctx context.Context, id string) {
+	go func() {
+		p.session.Events <- common.Event{Type: common.EventTypeIPRotationStarted, Message: id}
+		record, err := p.loadServer(ctx, id)
+		if err == nil && record.Status == common.ServerStatusMissing {
+			err = fmt.Errorf("instance %s no longer exists: %w", record.Server.InstanceName, common.ErrNotFound)
+		}
+		if err != nil {
+			slog.Error("Failed to load server", "id", id, "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeIPRotationError, Message: id, Error: err}
+			return
+		}
+		p.rotateIP(ctx, record.Server)
+	}()
+}
+
+// loadServer gets a server from the inventory, reconciled with the instance's actual state.
+func (p *Provisioner) loadServer(ctx context.Context, id string) (*common.InventoryRecord, error) {
+	return common.LoadServer(p.inventory, id, func(record *common.InventoryRecord) error {
+		return RefreshServer(ctx, p.client, record)
+	})
+}
+
+// recordServer assigns the server a local ID, adds it to the inventory and stores its SSH key.
+// Failing to add the server to the inventory doesn't fail provisioning, the server is usable either way.
+// Failing to store the SSH key does: without it the server can't be installed or maintained.
+func (p *Provisioner) recordServer(server *common.Server, sshUser, sshPrivateKey string, status common.ServerStatus, profile *common.InstallProfile) error {
+	record := common.NewInventoryRecord(server, sshUser)
+	record.Status = status
+	record.Profile = profile
+	if p.inventory != nil {
+		if err := p.inventory.Put(record); err != nil {
+			slog.Error("Failed to add server to inventory", "id", server.ID, "err", err)
+		}
+	}
+	return common.StoreSSHKey(p.secrets, server.ID, sshPrivateKey)
+}
+
+// updateServer saves a changed server in the inventory.
+func (p *Provisioner) updateServer(server common.Server, status common.ServerStatus) {
+	if err := common.UpdateServer(p.inventory, server, status); err != nil {
+		slog.Error("Failed to update server in inventory", "id", server.ID, "err", err)
+	}
+}
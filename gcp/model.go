@@ -3,6 +3,7 @@ package gcp
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/getlantern/lantern-server-provisioner/common"
 )
@@ -11,30 +12,86 @@ import (
 
 // Error represents a specific error returned by a GCP API operation.
 type Error struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Code       int           `json:"code"`
+	Message    string        `json:"message"`
+	Status     string        `json:"status,omitempty"` // Canonical status, e.g. "PERMISSION_DENIED"
+	Reason     string        `json:"reason,omitempty"` // Most specific error reason, e.g. "SERVICE_DISABLED" or "quotaExceeded"
+	retryAfter time.Duration // Delay requested via the Retry-After header, if any
 }
 
 func (e *Error) Error() string {
 	return fmt.Sprintf("GCP API Error %d: %s", e.Code, e.Message)
 }
 
+// Retriable implements common.Retriable. GCP error codes mirror HTTP status codes.
+func (e *Error) Retriable() bool {
+	return common.IsRetriableStatus(e.Code)
+}
+
+// RetryAfter implements common.RetryAfterHint.
+func (e *Error) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
 // HttpError represents an HTTP error encountered during an API call.
 type HttpError struct {
 	StatusCode int
 	Status     string
-	Body       string // Optionally include response body for debugging
+	Body       string        // Optionally include response body for debugging
+	retryAfter time.Duration // Delay requested via the Retry-After header, if any
 }
 
 func (e *HttpError) Error() string {
 	return fmt.Sprintf("HTTP Error %d: %s", e.StatusCode, e.Status)
 }
 
+// Retriable implements common.Retriable.
+func (e *HttpError) Retriable() bool {
+	return common.IsRetriableStatus(e.StatusCode)
+}
+
+// RetryAfter implements common.RetryAfterHint.
+func (e *HttpError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
 // --- Data Structures (Translated from TypeScript types) ---
 
 type Status struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Code    int           `json:"code"`
+	Message string        `json:"message"`
+	Status  string        `json:"status,omitempty"`  // e.g. "PERMISSION_DENIED", "RESOURCE_EXHAUSTED"
+	Errors  []StatusError `json:"errors,omitempty"`  // Legacy error list, e.g. reason "accessNotConfigured"
+	Details []StatusInfo  `json:"details,omitempty"` // e.g. google.rpc.ErrorInfo with reason "SERVICE_DISABLED"
+}
+
+type StatusError struct {
+	Reason  string `json:"reason"`
+	Domain  string `json:"domain,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type StatusInfo struct {
+	Type     string            `json:"@type"`
+	Reason   string            `json:"reason,omitempty"`
+	Domain   string            `json:"domain,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// reasons returns all error reasons reported in the status, most specific first.
+func (s Status) reasons() []string {
+	var res []string
+	for _, d := range s.Details {
+		if d.Reason != "" {
+			res = append(res, d.Reason)
+		}
+	}
+	for _, e := range s.Errors {
+		if e.Reason != "" {
+			res = append(res, e.Reason)
+		}
+	}
+	return res
 }
 
 type DiskInitParams struct {
@@ -47,22 +104,26 @@ type InstanceDisk struct {
 }
 
 type NetworkInterfaceAccessConfig struct {
-	Type                string `json:"type,omitempty"` // e.g., "ONE_TO_ONE_NAT"
-	Name                string `json:"name,omitempty"`
-	NatIP               string `json:"natIP,omitempty"`
-	SetPublicPtr        bool   `json:"setPublicPtr,omitempty"`
-	PublicPtrDomainName string `json:"publicPtrDomainName,omitempty"`
-	NetworkTier         string `json:"networkTier,omitempty"` // e.g., "PREMIUM"
-	Kind                string `json:"kind,omitempty"`        // e.g., "compute#accessConfig"
+	Type                     string `json:"type,omitempty"` // e.g., "ONE_TO_ONE_NAT" or "DIRECT_IPV6"
+	Name                     string `json:"name,omitempty"`
+	NatIP                    string `json:"natIP,omitempty"`
+	ExternalIpv6             string `json:"externalIpv6,omitempty"`
+	ExternalIpv6PrefixLength int    `json:"externalIpv6PrefixLength,omitempty"`
+	SetPublicPtr             bool   `json:"setPublicPtr,omitempty"`
+	PublicPtrDomainName      string `json:"publicPtrDomainName,omitempty"`
+	NetworkTier              string `json:"networkTier,omitempty"` // e.g., "PREMIUM"
+	Kind                     string `json:"kind,omitempty"`        // e.g., "compute#accessConfig"
 }
 
 type InstanceNetworkInterface struct {
-	Network       string                         `json:"network"`              // URL format
-	Subnetwork    string                         `json:"subnetwork,omitempty"` // URL format
-	NetworkIP     string                         `json:"networkIP,omitempty"`
-	Ipv6Address   string                         `json:"ipv6Address,omitempty"`
-	Name          string                         `json:"name,omitempty"`
-	AccessConfigs []NetworkInterfaceAccessConfig `json:"accessConfigs"`
+	Network           string                         `json:"network"`              // URL format
+	Subnetwork        string                         `json:"subnetwork,omitempty"` // URL format
+	NetworkIP         string                         `json:"networkIP,omitempty"`
+	Ipv6Address       string                         `json:"ipv6Address,omitempty"`
+	Name              string                         `json:"name,omitempty"`
+	StackType         string                         `json:"stackType,omitempty"` // "IPV4_ONLY" or "IPV4_IPV6"
+	AccessConfigs     []NetworkInterfaceAccessConfig `json:"accessConfigs"`
+	Ipv6AccessConfigs []NetworkInterfaceAccessConfig `json:"ipv6AccessConfigs,omitempty"`
 }
 
 type InstanceMetadataItem struct {
@@ -87,6 +148,40 @@ type Instance struct {
 	Disks             []InstanceDisk             `json:"disks"`
 	Metadata          InstanceMetadata           `json:"metadata"`
 	Labels            map[string]string          `json:"labels,omitempty"` // Key-value pairs
+	Scheduling        *InstanceScheduling        `json:"scheduling,omitempty"`
+	Status            string                     `json:"status,omitempty"` // e.g. "RUNNING", "STOPPING" or "TERMINATED"
+}
+
+// InstanceScheduling controls how an instance is placed and what happens when it is interrupted.
+type InstanceScheduling struct {
+	ProvisioningModel         string `json:"provisioningModel,omitempty"`         // "STANDARD" or "SPOT"
+	InstanceTerminationAction string `json:"instanceTerminationAction,omitempty"` // "STOP" or "DELETE", for SPOT instances
+	OnHostMaintenance         string `json:"onHostMaintenance,omitempty"`         // "MIGRATE" or "TERMINATE"
+	AutomaticRestart          *bool  `json:"automaticRestart,omitempty"`
+}
+
+// ExternalIPv4 returns the instance's external IPv4 address, or an empty string if it has none.
+func (i *Instance) ExternalIPv4() string {
+	for _, nic := range i.NetworkInterfaces {
+		for _, ac := range nic.AccessConfigs {
+			if ac.NatIP != "" {
+				return ac.NatIP
+			}
+		}
+	}
+	return ""
+}
+
+// ExternalIPv6 returns the instance's external IPv6 address, or an empty string if it has none.
+func (i *Instance) ExternalIPv6() string {
+	for _, nic := range i.NetworkInterfaces {
+		for _, ac := range nic.Ipv6AccessConfigs {
+			if ac.ExternalIpv6 != "" {
+				return ac.ExternalIpv6
+			}
+		}
+	}
+	return ""
 }
 
 type InstanceTags struct {
@@ -150,17 +245,24 @@ type Zone struct {
 }
 
 type computeEngineOperationError struct {
-	Errors []Status `json:"errors"`
+	Errors []struct {
+		Code    string `json:"code"` // e.g. "QUOTA_EXCEEDED", "ZONE_RESOURCE_POOL_EXHAUSTED"
+		Message string `json:"message"`
+	} `json:"errors"`
 }
 
 type ComputeEngineOperation struct {
-	ID         string                       `json:"id"`
-	Name       string                       `json:"name"`
-	TargetID   string                       `json:"targetId,omitempty"` // Sometimes present
-	TargetLink string                       `json:"targetLink,omitempty"`
-	Status     string                       `json:"status"` // "PENDING", "RUNNING", "DONE"
-	Error      *computeEngineOperationError `json:"error,omitempty"`
-	Kind       string                       `json:"kind"` // e.g. "compute#operation"
+	ID                  string                       `json:"id"`
+	Name                string                       `json:"name"`
+	TargetID            string                       `json:"targetId,omitempty"` // Sometimes present
+	TargetLink          string                       `json:"targetLink,omitempty"`
+	Status              string                       `json:"status"`                  // "PENDING", "RUNNING", "DONE"
+	OperationType       string                       `json:"operationType,omitempty"` // e.g. "insert" or "compute.instances.preempted"
+	InsertTime          string                       `json:"insertTime,omitempty"`    // RFC 3339
+	Error               *computeEngineOperationError `json:"error,omitempty"`
+	HttpErrorStatusCode int                          `json:"httpErrorStatusCode,omitempty"` // Set if the operation failed
+	HttpErrorMessage    string                       `json:"httpErrorMessage,omitempty"`
+	Kind                string                       `json:"kind"` // e.g. "compute#operation"
 }
 
 type ResourceManagerOperation struct {
@@ -176,6 +278,33 @@ type ServiceUsageOperation struct {
 	// Could also include Metadata and Response fields if needed
 }
 
+// Network represents a VPC network.
+type Network struct {
+	ID                    string   `json:"id,omitempty"`
+	CreationTimestamp     string   `json:"creationTimestamp,omitempty"`
+	Name                  string   `json:"name"`
+	Description           string   `json:"description,omitempty"`
+	AutoCreateSubnetworks bool     `json:"autoCreateSubnetworks"`
+	Subnetworks           []string `json:"subnetworks,omitempty"` // URL format
+	SelfLink              string   `json:"selfLink,omitempty"`
+}
+
+// Subnetwork represents a regional subnet of a VPC network.
+type Subnetwork struct {
+	ID                 string `json:"id,omitempty"`
+	CreationTimestamp  string `json:"creationTimestamp,omitempty"`
+	Name               string `json:"name"`
+	Description        string `json:"description,omitempty"`
+	Network            string `json:"network"` // URL format
+	Region             string `json:"region,omitempty"`
+	IpCidrRange        string `json:"ipCidrRange"`
+	StackType          string `json:"stackType,omitempty"`      // "IPV4_ONLY" or "IPV4_IPV6"
+	Ipv6AccessType     string `json:"ipv6AccessType,omitempty"` // "EXTERNAL" or "INTERNAL"
+	ExternalIpv6Prefix string `json:"externalIpv6Prefix,omitempty"`
+	Fingerprint        string `json:"fingerprint,omitempty"` // Needed for updates
+	SelfLink           string `json:"selfLink,omitempty"`
+}
+
 type Project struct {
 	ProjectNumber  string `json:"projectNumber"`
 	ProjectID      string `json:"projectId"`
@@ -187,7 +316,7 @@ type Project struct {
 
 type Firewall struct {
 	ID                string   `json:"id,omitempty"`
-	CreationTimestamp string   `json:"creationTimestamp"`
+	CreationTimestamp string   `json:"creationTimestamp,omitempty"`
 	Name              string   `json:"name"`
 	Description       string   `json:"description,omitempty"`
 	Network           string   `json:"network,omitempty"` // URL format
@@ -260,6 +389,25 @@ type listAllInstancesResponse struct {
 	Kind          string `json:"kind"` // e.g. compute#instanceAggregatedList
 }
 
+type listAllAddressesResponse struct {
+	Items map[string]struct {
+		Addresses []StaticIp `json:"addresses,omitempty"`
+	} `json:"items"` // Key is "regions/{region_name}" or "global"
+	NextPageToken string `json:"nextPageToken,omitempty"`
+}
+
+type listOperationsResponse struct {
+	Items         []ComputeEngineOperation `json:"items"`
+	NextPageToken string                   `json:"nextPageToken,omitempty"`
+}
+
+type listAllSubnetworksResponse struct {
+	Items map[string]struct {
+		Subnetworks []Subnetwork `json:"subnetworks,omitempty"`
+	} `json:"items"` // Key is "regions/{region_name}"
+	NextPageToken string `json:"nextPageToken,omitempty"`
+}
+
 type listZonesResponse struct {
 	Items         []Zone `json:"items"`
 	NextPageToken string `json:"nextPageToken,omitempty"`
@@ -345,3 +493,18 @@ func (z Zone) GetLocation() *common.GeoLocation {
 	}
 	return nil
 }
+
+// Image is a GCE disk image.
+type Image struct {
+	Name       string            `json:"name"`
+	SelfLink   string            `json:"selfLink,omitempty"`
+	Family     string            `json:"family,omitempty"`
+	Status     string            `json:"status,omitempty"` // e.g. "READY"
+	Deprecated *ImageDeprecation `json:"deprecated,omitempty"`
+}
+
+// ImageDeprecation is set on images that were replaced by a newer one.
+type ImageDeprecation struct {
+	State       string `json:"state"` // "DEPRECATED", "OBSOLETE" or "DELETED"
+	Replacement string `json:"replacement,omitempty"`
+}
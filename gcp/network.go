@@ -0,0 +1,116 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+const (
+	defaultNetwork = "default"
+	lanternNetwork = "lantern-network" // Custom-mode VPC with dual-stack subnets
+	lanternSubnet  = "lantern-subnet"  // Name of the dual-stack subnet in each region
+)
+
+// networkURL returns the relative URL of a VPC network, as used in instances and firewall rules.
+func networkURL(network string) string {
+	return fmt.Sprintf("global/networks/%s", network)
+}
+
+// instanceNetwork returns the name of the network an instance is attached to.
+func instanceNetwork(instance *Instance) string {
+	if len(instance.NetworkInterfaces) > 0 {
+		return path.Base(instance.NetworkInterfaces[0].Network)
+	}
+	return defaultNetwork
+}
+
+// subnetworkURL returns the relative URL of a regional subnet.
+func subnetworkURL(regionID, subnet string) string {
+	return fmt.Sprintf("regions/%s/subnetworks/%s", regionID, subnet)
+}
+
+// EnsureDualStackNetwork creates or reuses the lantern VPC network and its dual-stack subnet in the given region,
+// so instances can get an external IPv6 address. An existing IPv4-only lantern subnet is converted to dual-stack.
+// It returns the relative URLs of the network and the subnet.
+func EnsureDualStackNetwork(ctx context.Context, client *APIClient, projectID, regionID string, progress common.ProgressFunc) (string, string, error) {
+	if progress == nil {
+		progress = func(string) {}
+	}
+	network, err := client.GetNetwork(ctx, projectID, lanternNetwork)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get network %s: %w", lanternNetwork, err)
+	}
+	if network == nil {
+		progress(fmt.Sprintf("Creating network %s", lanternNetwork))
+		if _, err = client.CreateNetwork(ctx, projectID, Network{
+			Name:                  lanternNetwork,
+			Description:           "Lantern servers",
+			AutoCreateSubnetworks: false,
+		}); err != nil {
+			return "", "", fmt.Errorf("failed to create network %s: %w", lanternNetwork, err)
+		}
+	}
+
+	region := RegionLocator{ProjectID: projectID, RegionID: regionID}
+	subnet, err := client.GetSubnetwork(ctx, region, lanternSubnet)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get subnetwork %s: %w", lanternSubnet, err)
+	}
+	switch {
+	case subnet == nil:
+		cidr, err := nextSubnetRange(ctx, client, projectID)
+		if err != nil {
+			return "", "", err
+		}
+		progress(fmt.Sprintf("Creating dual-stack subnet %s in %s", lanternSubnet, regionID))
+		if _, err = client.CreateSubnetwork(ctx, region, Subnetwork{
+			Name:           lanternSubnet,
+			Description:    "Dual-stack subnet for Lantern servers",
+			Network:        networkURL(lanternNetwork),
+			IpCidrRange:    cidr,
+			StackType:      "IPV4_IPV6",
+			Ipv6AccessType: "EXTERNAL",
+		}); err != nil {
+			return "", "", fmt.Errorf("failed to create subnetwork %s: %w", lanternSubnet, err)
+		}
+	case subnet.StackType != "IPV4_IPV6":
+		progress(fmt.Sprintf("Enabling IPv6 on subnet %s in %s", lanternSubnet, regionID))
+		if _, err = client.UpdateSubnetwork(ctx, region, lanternSubnet, Subnetwork{
+			Name:           lanternSubnet,
+			Network:        subnet.Network,
+			IpCidrRange:    subnet.IpCidrRange,
+			StackType:      "IPV4_IPV6",
+			Ipv6AccessType: "EXTERNAL",
+			Fingerprint:    subnet.Fingerprint,
+		}); err != nil {
+			return "", "", fmt.Errorf("failed to enable IPv6 on subnetwork %s: %w", lanternSubnet, err)
+		}
+	}
+	slog.Debug("Dual-stack network ready", "network", lanternNetwork, "region", regionID)
+	return networkURL(lanternNetwork), subnetworkURL(regionID, lanternSubnet), nil
+}
+
+// nextSubnetRange returns an unused /20 IPv4 range for a new lantern subnet.
+// Subnets in different regions of the same VPC must not overlap.
+func nextSubnetRange(ctx context.Context, client *APIClient, projectID string) (string, error) {
+	subnets, err := client.ListAllSubnetworks(ctx, projectID, fmt.Sprintf("name=%s", lanternSubnet))
+	if err != nil {
+		return "", fmt.Errorf("failed to list subnetworks: %w", err)
+	}
+	used := make(map[string]bool, len(subnets))
+	for _, s := range subnets {
+		used[s.IpCidrRange] = true
+	}
+	// 10.128.0.0/9 holds 2048 /20 ranges, more than there are regions
+	for i := 0; i < 2048; i++ {
+		cidr := fmt.Sprintf("10.%d.%d.0/20", 128+i/16, (i%16)*16)
+		if !used[cidr] {
+			return cidr, nil
+		}
+	}
+	return "", fmt.Errorf("no free IPv4 range left for subnet %s", lanternSubnet)
+}
@@ -0,0 +1,261 @@
+package gcp
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+// propagationPolicy retries calls while a freshly linked billing account or enabled service propagates.
+// Until they do, the APIs keep answering with BILLING_DISABLED or SERVICE_DISABLED.
+var propagationPolicy = common.RetryPolicy{
+	MaxAttempts:     6,
+	InitialInterval: 5 * time.Second,
+	MaxInterval:     30 * time.Second,
+	Multiplier:      2,
+	Jitter:          0.2,
+	MaxElapsed:      3 * time.Minute,
+	Classify: func(err error) bool {
+		return errors.Is(err, common.ErrBillingDisabled) || errors.Is(err, common.ErrServiceDisabled) || common.IsRetriable(err)
+	},
+}
+
+// ProjectHealth describes whether a project is ready to host Lantern servers.
+type ProjectHealth struct {
+	ProjectID       string
+	BillingEnabled  bool
+	BillingAccount  string   // Linked billing account name (billingAccounts/{id}), empty if none
+	MissingServices []string // Required services that are not enabled
+}
+
+// Healthy reports whether the project has billing enabled and all required services.
+func (h *ProjectHealth) Healthy() bool {
+	return h.BillingEnabled && len(h.MissingServices) == 0
+}
+
+// CheckProjectHealth reports the billing and service state of a project.
+func CheckProjectHealth(ctx context.Context, client *APIClient, projectID string) (*ProjectHealth, error) {
+	health := &ProjectHealth{ProjectID: projectID}
+	bi, err := client.GetProjectBillingInfo(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get billing info for project %s: %w", projectID, err)
+	}
+	if bi != nil {
+		health.BillingEnabled = bi.BillingEnabled != nil && *bi.BillingEnabled
+		if bi.BillingAccountName != nil {
+			health.BillingAccount = *bi.BillingAccountName
+		}
+	}
+	services, err := client.ListEnabledServices(ctx, projectID)
+	if err != nil {
+		if errors.Is(err, common.ErrBillingDisabled) || errors.Is(err, common.ErrServiceDisabled) {
+			// Service Usage itself is unusable, so nothing can be considered enabled.
+			health.MissingServices = slices.Clone(requiredServices)
+			return health, nil
+		}
+		return nil, fmt.Errorf("failed to list enabled services for project %s: %w", projectID, err)
+	}
+	for _, service := range requiredServices {
+		if !slices.ContainsFunc(services, func(s Service) bool {
+			return strings.HasSuffix(s.Name, service)
+		}) {
+			health.MissingServices = append(health.MissingServices, service)
+		}
+	}
+	return health, nil
+}
+
+func IsProjectHealthy(ctx context.Context, client *APIClient, projectID string) bool {
+	health, err := CheckProjectHealth(ctx, client, projectID)
+	if err != nil {
+		slog.Error("Error checking project health", "projectID", projectID, "error", err)
+		return false
+	}
+	if !health.BillingEnabled {
+		slog.Error("Project billing not enabled", "projectID", projectID)
+	}
+	for _, service := range health.MissingServices {
+		slog.Error("Required service not enabled", "projectID", projectID, "service", service)
+	}
+	return health.Healthy()
+}
+
+// RemediateProject makes an unhealthy project usable for Lantern servers.
+// It links the project to billingAccountName if billing is not enabled, and enables the required services,
+// waiting for each long-running operation to finish. billingAccountName may be empty if billing is already enabled.
+// progress is optional and receives a message before each step.
+func RemediateProject(ctx context.Context, client *APIClient, projectID, billingAccountName string, progress common.ProgressFunc) error {
+	if progress == nil {
+		progress = func(string) {}
+	}
+	health, err := CheckProjectHealth(ctx, client, projectID)
+	if err != nil {
+		return err
+	}
+	if health.Healthy() {
+		return nil
+	}
+
+	if !health.BillingEnabled {
+		if billingAccountName == "" {
+			return &common.ProviderError{
+				Provider: providerName,
+				Category: common.ErrBillingDisabled,
+				Message:  fmt.Sprintf("billing is not enabled for project %s and no billing account was selected", projectID),
+			}
+		}
+		progress(fmt.Sprintf("Linking project %s to billing account %s", projectID, billingAccountName))
+		bi, err := client.UpdateProjectBillingInfo(ctx, projectID, UpdateBillingInfoRequest{BillingAccountName: billingAccountName})
+		if err != nil {
+			return fmt.Errorf("failed to link project %s to billing account %s: %w", projectID, billingAccountName, err)
+		}
+		if bi.BillingEnabled == nil || !*bi.BillingEnabled {
+			// Happens when the billing account is closed or has no valid payment method.
+			return &common.ProviderError{
+				Provider: providerName,
+				Category: common.ErrBillingDisabled,
+				Message:  fmt.Sprintf("billing account %s did not enable billing for project %s", billingAccountName, projectID),
+			}
+		}
+		slog.Debug("Linked project to billing account", "projectID", projectID, "account", billingAccountName)
+	}
+
+	if len(health.MissingServices) > 0 {
+		if err := EnableRequiredServices(ctx, client, projectID, health.MissingServices, progress); err != nil {
+			return err
+		}
+	}
+
+	health, err = CheckProjectHealth(ctx, client, projectID)
+	if err != nil {
+		return err
+	}
+	if !health.Healthy() {
+		return fmt.Errorf("project %s is still not healthy after remediation (billing enabled: %t, missing services: %v)",
+			projectID, health.BillingEnabled, health.MissingServices)
+	}
+	progress(fmt.Sprintf("Project %s is ready", projectID))
+	return nil
+}
+
+// EnableRequiredServices enables the given services on a project and waits for the operation to finish.
+func EnableRequiredServices(ctx context.Context, client *APIClient, projectID string, services []string, progress common.ProgressFunc) error {
+	if progress == nil {
+		progress = func(string) {}
+	}
+	progress(fmt.Sprintf("Enabling %s in project %s", strings.Join(services, ", "), projectID))
+	var op *ServiceUsageOperation
+	err := propagationPolicy.Do(ctx, func(ctx context.Context) error {
+		var err error
+		op, err = client.EnableServices(ctx, projectID, EnableServicesRequest{ServiceIDs: services})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enable services %v for project %s: %w", services, projectID, err)
+	}
+	if !op.Done {
+		progress("Waiting for services to be enabled, this can take a few minutes")
+		if _, err := client.ServiceUsageOperationWait(ctx, op.Name); err != nil {
+			return fmt.Errorf("failed waiting for services %v in project %s: %w", services, projectID, err)
+		}
+	}
+	slog.Debug("Enabled services", "projectID", projectID, "services", services)
+	return nil
+}
+
+// projectIDPrefix is the prefix of projects created for Lantern servers.
+const projectIDPrefix = "lantern-"
+
+// makeProjectID returns a random project ID. Project IDs are globally unique, 6 to 30 characters long,
+// and may contain lowercase letters, digits and hyphens, starting with a letter.
+func makeProjectID() (string, error) {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+	suffix := make([]byte, 10)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	for i, b := range suffix {
+		suffix[i] = alphabet[int(b)%len(alphabet)]
+	}
+	return projectIDPrefix + string(suffix), nil
+}
+
+// CreateLanternProject creates a dedicated project for Lantern servers, links it to billingAccountName
+// and enables the required services. parent is optional and places the project under an organization or folder.
+// The returned entry has the project's zones populated and can be used for provisioning right away.
+func CreateLanternProject(ctx context.Context, client *APIClient, billingAccountName string, parent *CreateProjectRequestParent, progress common.ProgressFunc) (*common.CompartmentEntry, error) {
+	if progress == nil {
+		progress = func(string) {}
+	}
+
+	var projectID string
+	for attempt := 0; ; attempt++ {
+		var err error
+		if projectID, err = makeProjectID(); err != nil {
+			return nil, fmt.Errorf("failed to generate project ID: %w", err)
+		}
+		progress(fmt.Sprintf("Creating project %s", projectID))
+		op, err := client.CreateProject(ctx, CreateProjectRequest{
+			ProjectID: projectID,
+			Name:      "Lantern Servers",
+			Parent:    parent,
+		})
+		var pe *common.ProviderError
+		if errors.As(err, &pe) && pe.StatusCode == http.StatusConflict && attempt < 2 {
+			slog.Debug("Project ID already taken, trying another one", "projectID", projectID)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create project: %w", err)
+		}
+		if !op.Done {
+			progress("Waiting for the project to be created")
+			if _, err := client.ResourceManagerOperationWait(ctx, op.Name); err != nil {
+				return nil, fmt.Errorf("failed waiting for project %s creation: %w", projectID, err)
+			}
+		} else if op.Error != nil {
+			return nil, fmt.Errorf("failed to create project %s: %w", projectID, newRPCError(op.Error))
+		}
+		break
+	}
+	slog.Debug("Created project", "projectID", projectID)
+
+	if err := RemediateProject(ctx, client, projectID, billingAccountName, progress); err != nil {
+		return nil, err
+	}
+
+	progress(fmt.Sprintf("Listing zones for project %s", projectID))
+	var zones []Zone
+	err := propagationPolicy.Do(ctx, func(ctx context.Context) error {
+		var err error
+		zones, err = client.ListZones(ctx, projectID)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list zones for project %s: %w", projectID, err)
+	}
+	return &common.CompartmentEntry{
+		ID:        projectID,
+		Locations: zoneLocations(zones),
+	}, nil
+}
+
+// zoneLocations returns the zones that map to a known location.
+func zoneLocations(zones []Zone) []common.CloudLocation {
+	var res []common.CloudLocation
+	for _, zone := range zones {
+		// Ensure the zone has a valid location before adding it
+		if zone.GetLocation() != nil {
+			res = append(res, zone)
+		}
+	}
+	return res
+}
@@ -3,21 +3,47 @@ package gcp
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/getlantern/lantern-server-provisioner/common"
 )
 
 type Provisioner struct {
 	session      *common.Session
+	mu           sync.Mutex // Guards compartments
 	compartments []common.Compartment
 	client       *APIClient
+	inventory    common.Inventory
+	secrets      common.SecretStore
 }
 
-func (p *Provisioner) Provision(ctx context.Context, projectID string, locationID string) {
+// Provision creates an instance in the project projectID and zone locationID, and installs it.
+// A project that isn't ready is remediated first: it is linked to its billing account and the required
+// services are enabled, see RemediateProject.
+func (p *Provisioner) Provision(ctx context.Context, projectID string, locationID string, opts ...common.ProvisionOption) {
+	options := common.NewProvisionOptions(opts...)
 	go func() {
 		p.session.Events <- common.Event{Type: common.EventTypeProvisioningStarted, Message: projectID}
 
+		recipe, err := common.Recipe(options.OS)
+		if err == nil {
+			err = recipe.CheckSupported(time.Now())
+		}
+		if err != nil {
+			p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err}
+			return
+		}
+		if err := common.CheckSecretStore(p.secrets); err != nil {
+			err = fmt.Errorf("SSH keys can't be stored: %w", err)
+			slog.Error("Secret store is unusable", "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err}
+			return
+		}
+
 		publicSSHKey, privateSSHKey, err := common.MakeSSHKeyPair()
 		if err != nil {
 			slog.Error("Failed to create SSH key pair", "err", err)
@@ -26,18 +52,31 @@ func (p *Provisioner) Provision(ctx context.Context, projectID string, locationI
 		}
 
 		if !IsProjectHealthy(ctx, p.client, projectID) {
-			p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: errors.New("project is not healthy")}
-			slog.Error("Project is not healthy", "projectID", projectID)
-			return
+			if err := RemediateProject(ctx, p.client, projectID, p.billingAccountFor(projectID), p.progress); err != nil {
+				p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: fmt.Errorf("project is not healthy: %w", err)}
+				slog.Error("Project is not healthy", "projectID", projectID, "err", err)
+				return
+			}
 		}
 
-		if err := CreateFirewallIfNeeded(ctx, p.client, projectID); err != nil {
+		network := defaultNetwork
+		if options.IPv6 {
+			if _, _, err := EnsureDualStackNetwork(ctx, p.client, projectID, GetZoneRegionID(locationID), p.progress); err != nil {
+				p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err}
+				slog.Error("Failed to set up dual-stack network", "err", err)
+				return
+			}
+			network = lanternNetwork
+		}
+
+		// Only SSH is needed until the server is installed and its service ports are known
+		if err := CreateNetworkFirewallIfNeeded(ctx, p.client, projectID, network, []common.PortRange{options.InstallProfile().SSHPort()}); err != nil {
 			p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err}
 			slog.Error("Failed to create firewall", "err", err)
 			return
 		}
 
-		instanceName, instanceID, err := CreateInstance(ctx, p.client, projectID, locationID, publicSSHKey)
+		instanceName, instanceID, err := CreateInstance(ctx, p.client, projectID, locationID, publicSSHKey, options)
 		if err != nil {
 			p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err}
 			slog.Error("Failed to create instance", "err", err)
@@ -53,12 +92,12 @@ func (p *Provisioner) Provision(ctx context.Context, projectID string, locationI
 		regionLocator := RegionLocator{ProjectID: projectID, RegionID: GetZoneRegionID(locationID)}
 		var ip string
 		if sip, err := p.client.GetStaticIP(ctx, regionLocator, instanceName); err != nil {
-			natIP := ""
-			if len(instance.NetworkInterfaces[0].AccessConfigs) > 0 {
-				natIP = instance.NetworkInterfaces[0].AccessConfigs[0].NatIP
-			}
+			slog.Error("Failed to get static IP", "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err}
+			return
+		} else if sip == nil {
 			createData := StaticIpCreate{
-				Address:     natIP,
+				Address:     instance.ExternalIPv4(),
 				Name:        instanceName,
 				Description: instance.Description,
 			}
@@ -74,19 +113,122 @@ func (p *Provisioner) Provision(ctx context.Context, projectID string, locationI
 			ip = sip.Address
 		}
 
-		var sc *common.ServerConfiguration
-		if sc, err = common.InstallServer(ip, privateSSHKey, "ubuntu"); err != nil {
-			slog.Error("Failed to install server", "err", err)
+		server := &common.Server{
+			Provider:     providerName,
+			PlacementID:  projectID,
+			LocationID:   locationID,
+			InstanceID:   instanceID,
+			InstanceName: instanceName,
+			StaticIPName: instanceName,
+			Spot:         options.Spot,
+			OS:           recipe.Target,
+			Config:       common.ServerConfiguration{ExternalIp: ip, ExternalIpv6: instance.ExternalIPv6()},
+		}
+		// Recorded before it is installed, so a failed installation can be resumed
+		if err := p.recordServer(server, recipe.DefaultUser, privateSSHKey, common.ServerStatusInstalling, options.Profile); err != nil {
+			slog.Error("Failed to record server", "id", server.ID, "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err, Server: server}
 			return
 		}
+		p.installServer(ctx, server, network, privateSSHKey, recipe.DefaultUser, options.InstallProfile())
+	}()
+}
 
-		p.session.Events <- common.Event{
-			Type:    common.EventTypeProvisioningCompleted,
-			Message: sc.Encode(),
+// ResumeInstall implements common.InstallResumer.
+func (p *Provisioner) ResumeInstall(ctx context.Context, id string) {
+	go func() {
+		p.session.Events <- common.Event{Type: common.EventTypeProvisioningStarted, Message: id}
+		record, err := p.loadServer(ctx, id)
+		if err == nil && record.Status != common.ServerStatusInstalling {
+			err = fmt.Errorf("server %s is %s, not installing", id, record.Status)
+		}
+		if err != nil {
+			slog.Error("Failed to load server", "id", id, "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err}
+			return
+		}
+		server := record.Server
+		privateSSHKey, err := common.LoadSSHKey(p.secrets, id)
+		if err != nil {
+			slog.Error("Failed to load SSH key", "id", id, "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err, Server: &server}
+			return
+		}
+		instance, err := p.client.GetInstance(ctx, Locator{ProjectID: server.PlacementID, ZoneID: server.LocationID, InstanceID: server.InstanceName})
+		if err != nil {
+			slog.Error("Failed to get instance", "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err, Server: &server}
+			return
 		}
+		p.installServer(ctx, &server, instanceNetwork(instance), privateSSHKey, record.SSHUser, record.InstallProfile())
+	}()
+}
+
+// installServer installs a recorded server, opens its ports in the network's firewall and verifies it.
+// The result is reported with EventTypeProvisioningCompleted or EventTypeProvisioningError; the server
+// stays installing in the inventory on failure. Spot servers are watched for preemption until ctx is done.
+func (p *Provisioner) installServer(ctx context.Context, server *common.Server, network, privateSSHKey, sshUser string, profile common.InstallProfile) {
+	fail := func(msg string, err error) {
+		slog.Error(msg, "id", server.ID, "err", err)
+		p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err, Server: server}
+	}
+	sc, err := common.InstallServer(ctx, server.Config.ExternalIp, privateSSHKey, sshUser, server.OS, profile, p.log)
+	if err != nil {
+		fail("Failed to install server", err)
+		return
+	}
+	sc.ExternalIp = server.Config.ExternalIp
+	sc.ExternalIpv6 = server.Config.ExternalIpv6
+
+	if err := CreateNetworkFirewallIfNeeded(ctx, p.client, server.PlacementID, network, sc.FirewallPorts()); err != nil {
+		fail("Failed to update firewall", err)
+		return
+	}
+
+	p.progress("Verifying the server")
+	if err := common.VerifyServer(ctx, *sc, privateSSHKey, sshUser); err != nil {
+		fail("Server verification failed", err)
+		return
+	}
+
+	server.Config = *sc
+	p.updateServer(*server, common.ServerStatusActive)
+	p.session.Events <- common.Event{
+		Type:    common.EventTypeProvisioningCompleted,
+		Message: sc.Encode(),
+		Server:  server,
+	}
+	if server.Spot {
+		p.WatchServer(ctx, *server)
+	}
+}
+
+// RotateIP implements common.IPRotator. It moves the instance to a new static IP address
+// and releases the old one.
+func (p *Provisioner) RotateIP(ctx context.Context, server common.Server) {
+	go func() {
+		p.session.Events <- common.Event{Type: common.EventTypeIPRotationStarted, Message: server.ID, Server: &server}
+		p.rotateIP(ctx, server)
 	}()
 }
 
+// rotateIP rotates the server's IP, updates the inventory and reports the result.
+func (p *Provisioner) rotateIP(ctx context.Context, server common.Server) {
+	rotated, err := RotateInstanceIP(ctx, p.client, server, p.progress)
+	if err != nil {
+		slog.Error("Failed to rotate IP", "id", server.ID, "instance", server.InstanceName, "err", err)
+		p.session.Events <- common.Event{Type: common.EventTypeIPRotationError, Message: server.ID, Error: err, Server: &server}
+		return
+	}
+	slog.Debug("Rotated IP", "instance", server.InstanceName, "ip", rotated.Config.ExternalIp)
+	p.updateServer(*rotated, common.ServerStatusActive)
+	p.session.Events <- common.Event{
+		Type:    common.EventTypeIPRotationCompleted,
+		Message: rotated.Config.Encode(),
+		Server:  rotated,
+	}
+}
+
 func (p *Provisioner) Validate(ctx context.Context, token string) {
 	p.client = NewAPIClient(ctx, token)
 	go func(resultChan chan<- common.Event) {
@@ -108,58 +250,158 @@ func (p *Provisioner) Validate(ctx context.Context, token string) {
 			}
 			slog.Debug("Projects retrieved for billing account", "account", account.Name, "projects", projects)
 			if len(projects) == 0 {
-				slog.Warn("No projects found for billing account. Skipping", "account", account.Name)
-				continue
+				// Keep the account, a project can be created for it with CreateCompartmentEntry
+				slog.Warn("No projects found for billing account", "account", account.Name)
 			}
 			var entries []common.CompartmentEntry
 			for _, project := range projects {
 				zones, err := p.client.ListZones(ctx, project)
+				if errors.Is(err, common.ErrServiceDisabled) {
+					// First-time users usually haven't enabled Compute Engine yet. Enabling it is left
+					// to EnableServices or Provision for the project the user picks.
+					missing := p.missingServices(ctx, project)
+					slog.Warn("Required services are disabled for project", "project", project, "services", missing)
+					entries = append(entries, common.CompartmentEntry{ID: project, MissingServices: missing})
+					continue
+				}
 				if err != nil {
 					slog.Error("Failed to list zones for project. Skipping", "project", project, "err", err)
 					continue
 				}
 				if len(zones) > 0 {
-					var res []common.CloudLocation
-					for _, zone := range zones {
-						// Ensure the zone has a valid location before adding it
-						if zone.GetLocation() != nil {
-							res = append(res, zone)
-						}
-					}
-
 					entries = append(entries, common.CompartmentEntry{
 						ID:        project,
-						Locations: res,
+						Locations: zoneLocations(zones),
 					})
 				} else {
 					slog.Warn("No zones found for project. Skipping", "project", project)
 				}
 			}
 
+			p.mu.Lock()
 			p.compartments = append(p.compartments, common.Compartment{
 				ID:      account.Name,
 				Name:    account.DisplayName,
 				Entries: entries,
 			})
+			p.mu.Unlock()
 		}
 		resultChan <- common.Event{Type: common.EventTypeValidationCompleted}
 
 	}(p.session.Events)
 }
 
+// CreateCompartmentEntry implements common.CompartmentEntryCreator.
+// It creates a dedicated Lantern project linked to the billing account identified by compartmentID.
+func (p *Provisioner) CreateCompartmentEntry(ctx context.Context, compartmentID string) {
+	p.CreateProject(ctx, compartmentID, nil)
+}
+
+// CreateProject creates a dedicated Lantern project linked to billingAccountName, optionally
+// under an organization or folder, and adds it to the billing account's compartment.
+// The result is reported with EventTypeCompartmentEntryCreated or EventTypeCompartmentEntryError.
+func (p *Provisioner) CreateProject(ctx context.Context, billingAccountName string, parent *CreateProjectRequestParent) {
+	go func() {
+		entry, err := CreateLanternProject(ctx, p.client, billingAccountName, parent, p.progress)
+		if err != nil {
+			slog.Error("Failed to create project", "account", billingAccountName, "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeCompartmentEntryError, Error: err}
+			return
+		}
+		p.mu.Lock()
+		for i := range p.compartments {
+			if p.compartments[i].ID == billingAccountName {
+				p.compartments[i].Entries = append(p.compartments[i].Entries, *entry)
+			}
+		}
+		p.mu.Unlock()
+		slog.Debug("Project created", "projectID", entry.ID, "locations", len(entry.Locations))
+		p.session.Events <- common.Event{Type: common.EventTypeCompartmentEntryCreated, Message: entry.ID}
+	}()
+}
+
+// progress reports a progress message on the session.
+func (p *Provisioner) progress(message string) {
+	slog.Debug("Progress", "message", message)
+	p.session.Events <- common.Event{Type: common.EventTypeProgress, Message: message}
+}
+
+// log reports a line of remote command output on the session.
+func (p *Provisioner) log(line string) {
+	p.session.Events <- common.Event{Type: common.EventTypeLog, Message: line}
+}
+
+// missingServices returns the required services that aren't enabled for a project.
+func (p *Provisioner) missingServices(ctx context.Context, projectID string) []string {
+	health, err := CheckProjectHealth(ctx, p.client, projectID)
+	if err != nil || len(health.MissingServices) == 0 {
+		slog.Debug("Failed to check services of project", "project", projectID, "err", err)
+		return slices.Clone(requiredServices)
+	}
+	return health.MissingServices
+}
+
+// EnableServices implements common.ServiceEnabler. It also links the project to its billing account
+// if billing isn't enabled, see RemediateProject.
+func (p *Provisioner) EnableServices(ctx context.Context, projectID string) {
+	go func() {
+		fail := func(err error) {
+			slog.Error("Failed to enable services", "project", projectID, "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeServicesError, Error: err}
+		}
+		if err := RemediateProject(ctx, p.client, projectID, p.billingAccountFor(projectID), p.progress); err != nil {
+			fail(err)
+			return
+		}
+		zones, err := p.client.ListZones(ctx, projectID)
+		if err != nil {
+			fail(err)
+			return
+		}
+		p.mu.Lock()
+		for i := range p.compartments {
+			for j := range p.compartments[i].Entries {
+				if entry := &p.compartments[i].Entries[j]; entry.ID == projectID {
+					entry.Locations = zoneLocations(zones)
+					entry.MissingServices = nil
+				}
+			}
+		}
+		p.mu.Unlock()
+		slog.Debug("Enabled services", "project", projectID, "locations", len(zones))
+		p.session.Events <- common.Event{Type: common.EventTypeServicesEnabled, Message: projectID}
+	}()
+}
+
+// billingAccountFor returns the name of the billing account whose compartment contains the project, if any.
+func (p *Provisioner) billingAccountFor(projectID string) string {
+	for _, c := range p.Compartments() {
+		if common.CompartmentEntryByID(c.Entries, projectID) != nil {
+			return c.ID
+		}
+	}
+	return ""
+}
+
 func (p *Provisioner) Session() *common.Session {
 	return p.session
 }
 
 func (p *Provisioner) Compartments() []common.Compartment {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return p.compartments
 }
 
-func GetProvisioner(ctx context.Context, startBrowser common.BrowserOpener) common.Provisioner {
+// GetProvisioner returns a GCP provisioner and starts its OAuth flow. Without an OS keychain, SSH keys
+// are kept in a file encrypted with the passphrase returned by passphrase, see common.DefaultSecretStore.
+func GetProvisioner(ctx context.Context, startBrowser common.BrowserOpener, passphrase common.PassphraseFunc) common.Provisioner {
 	session := RunOauth(ctx, startBrowser)
 	return &Provisioner{
 		session:      session,
 		compartments: []common.Compartment{},
 		client:       nil,
+		inventory:    common.DefaultInventory(),
+		secrets:      common.DefaultSecretStore(passphrase),
 	}
 }
@@ -0,0 +1,203 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+// ReconcileServers compares the inventory's GCP servers with the live instances and static IPs.
+// Besides the projects of recorded servers, the projects in extraProjects are searched for
+// orphaned Lantern instances and unused Lantern static IPs; errors listing those are only logged,
+// as the user may not have Compute Engine enabled in all of them. A server or recorded project that
+// can't be checked doesn't stop the others: the drifts found are returned along with the joined errors.
+func ReconcileServers(ctx context.Context, client *APIClient, records []common.InventoryRecord, extraProjects []string) ([]common.Drift, error) {
+	var drifts []common.Drift
+	var errs []error
+	known := make(map[string]bool)    // project/zone/instance names of recorded servers
+	knownIPs := make(map[string]bool) // project/address names of recorded static IPs
+	var projects []string
+	for _, record := range records {
+		if record.Server.Provider != providerName {
+			continue
+		}
+		server := record.Server
+		known[path.Join(server.PlacementID, server.LocationID, server.InstanceName)] = true
+		if server.StaticIPName != "" {
+			knownIPs[path.Join(server.PlacementID, server.StaticIPName)] = true
+		}
+		if !slices.Contains(projects, server.PlacementID) {
+			projects = append(projects, server.PlacementID)
+		}
+
+		actual := record
+		if err := RefreshServer(ctx, client, &actual); err != nil {
+			errs = append(errs, fmt.Errorf("failed to refresh server %s: %w", server.ID, err))
+			continue
+		}
+		drifts = append(drifts, common.DetectDrift(record, actual)...)
+	}
+	recordedProjects := len(projects)
+	for _, project := range extraProjects {
+		if !slices.Contains(projects, project) {
+			projects = append(projects, project)
+		}
+	}
+
+	for i, project := range projects {
+		orphans, err := findOrphans(ctx, client, project, known, knownIPs)
+		if err != nil {
+			if i < recordedProjects {
+				errs = append(errs, err)
+				continue
+			}
+			slog.Warn("Failed to look for orphaned resources", "project", project, "err", err)
+			continue
+		}
+		drifts = append(drifts, orphans...)
+	}
+	return drifts, errors.Join(errs...)
+}
+
+// findOrphans returns Lantern instances and unused Lantern static IPs in a project that are not in the inventory.
+func findOrphans(ctx context.Context, client *APIClient, projectID string, known, knownIPs map[string]bool) ([]common.Drift, error) {
+	instances, err := client.ListAllInstances(ctx, projectID, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list instances in project %s: %w", projectID, err)
+	}
+	addresses, err := client.ListAllStaticIPs(ctx, projectID, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list static IPs in project %s: %w", projectID, err)
+	}
+	addressNames := make(map[string]string) // IP to address name
+	for _, a := range addresses {
+		addressNames[a.Address] = a.Name
+	}
+
+	var drifts []common.Drift
+	for zoneID, zoneInstances := range instances {
+		for _, instance := range zoneInstances {
+			if instance.Tags == nil || !slices.Contains(instance.Tags.Items, firewallTag) ||
+				known[path.Join(projectID, zoneID, instance.Name)] {
+				continue
+			}
+			server := common.Server{
+				Provider:     providerName,
+				PlacementID:  projectID,
+				LocationID:   zoneID,
+				InstanceID:   instance.ID,
+				InstanceName: instance.Name,
+				StaticIPName: addressNames[instance.ExternalIPv4()],
+				Spot:         instance.Scheduling != nil && instance.Scheduling.ProvisioningModel == "SPOT",
+				Config: common.ServerConfiguration{
+					ExternalIp:   instance.ExternalIPv4(),
+					ExternalIpv6: instance.ExternalIPv6(),
+				},
+			}
+			drifts = append(drifts, common.Drift{
+				Kind:    common.DriftOrphaned,
+				Server:  server,
+				Status:  instanceStatus(&instance),
+				Current: server.Config.ExternalIp,
+				Message: fmt.Sprintf("Instance %s in %s is not in the inventory", instance.Name, zoneID),
+			})
+		}
+	}
+	for _, a := range addresses {
+		// Addresses of Lantern instances are named after them, and rotated ones get a suffix
+		if a.Status != "RESERVED" || !strings.HasPrefix(a.Name, "lantern-") || knownIPs[path.Join(projectID, a.Name)] {
+			continue
+		}
+		regionID := path.Base(a.Region)
+		drifts = append(drifts, common.Drift{
+			Kind: common.DriftOrphaned,
+			Server: common.Server{
+				Provider:     providerName,
+				PlacementID:  projectID,
+				LocationID:   regionID,
+				StaticIPName: a.Name,
+				Config:       common.ServerConfiguration{ExternalIp: a.Address},
+			},
+			Status:  common.ServerStatusMissing,
+			Current: a.Address,
+			Message: fmt.Sprintf("Static IP %s (%s) in %s is not used by any server", a.Name, a.Address, regionID),
+		})
+	}
+	return drifts, nil
+}
+
+// PurgeDrift deletes the instance and static IP of an orphaned or missing drift, if they still exist.
+// It is called by Provisioner.Purge through common.PurgeDrift, which keeps other drifts from being deleted.
+func PurgeDrift(ctx context.Context, client *APIClient, drift common.Drift, progress common.ProgressFunc) error {
+	server := drift.Server
+	if server.InstanceName == "" {
+		// An orphaned static IP, its location is a region
+		if server.StaticIPName != "" {
+			releaseStaticIP(ctx, client, RegionLocator{ProjectID: server.PlacementID, RegionID: server.LocationID}, server.StaticIPName)
+		}
+		return nil
+	}
+	return DestroyInstance(ctx, client, server, progress)
+}
+
+// Reconcile implements common.Reconciler. Besides the projects of recorded servers,
+// all projects found during validation are searched for orphaned resources.
+func (p *Provisioner) Reconcile(ctx context.Context) {
+	go func() {
+		p.session.Events <- common.Event{Type: common.EventTypeReconcileStarted}
+		if p.inventory == nil {
+			p.session.Events <- common.Event{Type: common.EventTypeReconcileError, Error: fmt.Errorf("no inventory configured")}
+			return
+		}
+		records, err := p.inventory.List()
+		if err != nil {
+			slog.Error("Failed to list inventory", "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeReconcileError, Error: err}
+			return
+		}
+		var projects []string
+		for _, c := range p.Compartments() {
+			projects = append(projects, common.CompartmentEntryIDs(c.Entries)...)
+		}
+		p.progress("Comparing the inventory with Google Cloud")
+		drifts, err := ReconcileServers(ctx, p.client, records, projects)
+		if err != nil {
+			slog.Warn("Failed to check some servers", "err", err)
+		}
+		slog.Debug("Reconciled inventory", "drifts", len(drifts))
+		p.session.Events <- common.Event{Type: common.EventTypeReconcileCompleted, Drifts: drifts, Error: err}
+	}()
+}
+
+// Adopt implements common.Reconciler.
+func (p *Provisioner) Adopt(ctx context.Context, drift common.Drift) {
+	go func() {
+		if err := common.AdoptDrift(p.inventory, drift); err != nil {
+			slog.Error("Failed to adopt drift", "kind", drift.Kind, "id", drift.ServerID, "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeDriftResolutionError, Error: err}
+			return
+		}
+		p.session.Events <- common.Event{Type: common.EventTypeDriftResolved, Message: string(drift.Kind), Server: &drift.Server}
+	}()
+}
+
+// Purge implements common.Reconciler.
+func (p *Provisioner) Purge(ctx context.Context, drift common.Drift) {
+	go func() {
+		err := common.PurgeDrift(p.inventory, p.secrets, drift, func() error {
+			return PurgeDrift(ctx, p.client, drift, p.progress)
+		})
+		if err != nil {
+			slog.Error("Failed to purge drift", "kind", drift.Kind, "id", drift.ServerID, "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeDriftResolutionError, Error: err}
+			return
+		}
+		p.session.Events <- common.Event{Type: common.EventTypeDriftResolved, Message: string(drift.Kind), Server: &drift.Server}
+	}()
+}
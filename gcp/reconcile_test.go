@@ -0,0 +1,62 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+// roundTripFunc serves the client's requests in-process, whatever host they are for.
+type roundTripFunc func(w http.ResponseWriter, r *http.Request)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	w := httptest.NewRecorder()
+	f(w, r)
+	return w.Result(), nil
+}
+
+// testClient returns a client whose requests are handled by handler.
+func testClient(handler roundTripFunc) *APIClient {
+	return &APIClient{httpClient: &http.Client{Transport: handler}}
+}
+
+func TestReconcileServersContinuesAfterErrors(t *testing.T) {
+	client := testClient(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/instances/running"):
+			w.Write([]byte(`{"name": "running", "status": "RUNNING", "networkInterfaces": [{"accessConfigs": [{"natIP": "192.0.2.1"}]}]}`))
+		case strings.HasSuffix(r.URL.Path, "/instances/gone"):
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"code": 404, "message": "not found", "errors": [{"reason": "notFound"}]}}`))
+		case strings.HasSuffix(r.URL.Path, "/instances/forbidden"):
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error": {"code": 403, "message": "denied", "status": "PERMISSION_DENIED"}}`))
+		case strings.Contains(r.URL.Path, "/aggregated/"):
+			w.Write([]byte(`{"items": {}}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	record := func(id, name string) common.InventoryRecord {
+		return common.InventoryRecord{
+			Server: common.Server{ID: id, Provider: providerName, PlacementID: "project", LocationID: "us-central1-a", InstanceName: name,
+				Config: common.ServerConfiguration{ExternalIp: "192.0.2.1"}},
+			Status: common.ServerStatusActive,
+		}
+	}
+	records := []common.InventoryRecord{record("1", "forbidden"), record("2", "gone"), record("3", "running")}
+
+	drifts, err := ReconcileServers(context.Background(), client, records, nil)
+	if !errors.Is(err, common.ErrPermissionDenied) || !strings.Contains(err.Error(), "server 1") {
+		t.Errorf("error = %v, want the refresh error of server 1", err)
+	}
+	if len(drifts) != 1 || drifts[0].Kind != common.DriftMissing || drifts[0].ServerID != "2" {
+		t.Errorf("drifts = %+v, want server 2 missing", drifts)
+	}
+}
@@ -0,0 +1,110 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+const externalAccessConfigName = "External NAT"
+
+// RotateInstanceIP moves an instance to a new static external IP address.
+// It reserves a new address, swaps the instance's access config to it and releases the old address.
+// If the swap fails, the old address is put back. The instance itself is not touched otherwise,
+// so the installed Lantern server keeps its state. It returns the updated server.
+func RotateInstanceIP(ctx context.Context, client *APIClient, server common.Server, progress common.ProgressFunc) (*common.Server, error) {
+	if progress == nil {
+		progress = func(string) {}
+	}
+	loc := Locator{ProjectID: server.PlacementID, ZoneID: server.LocationID, InstanceID: server.InstanceName}
+	region := RegionLocator{ProjectID: server.PlacementID, RegionID: GetZoneRegionID(server.LocationID)}
+
+	instance, err := client.GetInstance(ctx, loc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get instance %s: %w", server.InstanceName, err)
+	}
+	if len(instance.NetworkInterfaces) == 0 {
+		return nil, fmt.Errorf("instance %s has no network interfaces", server.InstanceName)
+	}
+	nic := instance.NetworkInterfaces[0]
+	var oldConfig *NetworkInterfaceAccessConfig
+	if len(nic.AccessConfigs) > 0 {
+		oldConfig = &nic.AccessConfigs[0]
+	}
+
+	progress("Reserving a new IP address")
+	newName := fmt.Sprintf("%s-%s", server.InstanceName, time.Now().UTC().Format("20060102150405"))
+	sip, err := client.CreateStaticIP(ctx, region, StaticIpCreate{Name: newName, Description: instance.Description})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create static IP: %w", err)
+	}
+	slog.Debug("Reserved new static IP", "name", newName, "ip", sip.Address)
+
+	progress(fmt.Sprintf("Moving instance %s to %s", server.InstanceName, sip.Address))
+	if oldConfig != nil {
+		if err := waitZoneOp(ctx, client, loc, func() (*ComputeEngineOperation, error) {
+			return client.DeleteAccessConfig(ctx, loc, nic.Name, oldConfig.Name)
+		}); err != nil {
+			releaseStaticIP(ctx, client, region, newName)
+			return nil, fmt.Errorf("failed to remove the current access config: %w", err)
+		}
+	}
+	if err := waitZoneOp(ctx, client, loc, func() (*ComputeEngineOperation, error) {
+		return client.AddAccessConfig(ctx, loc, nic.Name, NetworkInterfaceAccessConfig{
+			Type:  "ONE_TO_ONE_NAT",
+			Name:  externalAccessConfigName,
+			NatIP: sip.Address,
+		})
+	}); err != nil {
+		if oldConfig != nil {
+			// Put the old address back, it is still reserved
+			if restoreErr := waitZoneOp(ctx, client, loc, func() (*ComputeEngineOperation, error) {
+				return client.AddAccessConfig(ctx, loc, nic.Name, NetworkInterfaceAccessConfig{
+					Type:  "ONE_TO_ONE_NAT",
+					Name:  oldConfig.Name,
+					NatIP: oldConfig.NatIP,
+				})
+			}); restoreErr != nil {
+				slog.Error("Failed to restore the previous access config", "instance", server.InstanceName, "err", restoreErr)
+			}
+		}
+		releaseStaticIP(ctx, client, region, newName)
+		return nil, fmt.Errorf("failed to attach the new IP address: %w", err)
+	}
+
+	if server.StaticIPName != "" {
+		progress("Releasing the old IP address")
+		releaseStaticIP(ctx, client, region, server.StaticIPName)
+	}
+
+	rotated := server
+	rotated.StaticIPName = newName
+	rotated.Config.ExternalIp = sip.Address
+	return &rotated, nil
+}
+
+// waitZoneOp starts a zone operation and waits for it to complete.
+func waitZoneOp(ctx context.Context, client *APIClient, loc Locator, start func() (*ComputeEngineOperation, error)) error {
+	op, err := start()
+	if err != nil {
+		return err
+	}
+	_, err = client.ComputeEngineOperationZoneWait(ctx, loc, op.Name)
+	return err
+}
+
+// releaseStaticIP deletes a static IP address, logging failures. A leftover address only costs money,
+// so it doesn't fail the calling operation. An address that doesn't exist is ignored.
+func releaseStaticIP(ctx context.Context, client *APIClient, region RegionLocator, name string) {
+	op, err := client.DeleteStaticIP(ctx, region, name)
+	if err == nil {
+		_, err = client.ComputeEngineOperationRegionWait(ctx, region, op.Name)
+	}
+	if err != nil && !errors.Is(err, common.ErrNotFound) {
+		slog.Error("Failed to release static IP", "name", name, "err", err)
+	}
+}
@@ -0,0 +1,209 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+const (
+	// List prices of an e2-micro instance in us-central1, in USD per hour. Other regions are priced
+	// differently and spot prices change over time, so these are only a fixed approximation.
+	onDemandHourlyPrice = 0.008376
+	spotHourlyPrice     = 0.002512
+
+	preemptedOperationType = "compute.instances.preempted"
+	preemptionPollInterval = time.Minute
+)
+
+// instanceScheduling returns the scheduling block for a new instance, or nil for the defaults.
+func instanceScheduling(opts common.ProvisionOptions) *InstanceScheduling {
+	if !opts.Spot {
+		return nil
+	}
+	automaticRestart := false
+	return &InstanceScheduling{
+		ProvisioningModel: "SPOT",
+		// Stopping keeps the disk and address, so the server can be restarted without reinstalling
+		InstanceTerminationAction: "STOP",
+		// SPOT instances can't be live migrated or restarted automatically
+		OnHostMaintenance: "TERMINATE",
+		AutomaticRestart:  &automaticRestart,
+	}
+}
+
+// EstimateCost returns the approximate price of a Lantern instance, from the us-central1 list prices.
+// It is the same for every zone: actual prices vary by region, and spot prices also over time.
+func EstimateCost(opts common.ProvisionOptions) *common.CostEstimate {
+	estimate := &common.CostEstimate{
+		Hourly:         onDemandHourlyPrice,
+		OnDemandHourly: onDemandHourlyPrice,
+		Spot:           opts.Spot,
+	}
+	if opts.Spot {
+		estimate.Hourly = spotHourlyPrice
+	}
+	return estimate
+}
+
+// LastPreemption returns the most recent preemption of an instance after since, or nil if it wasn't preempted.
+func LastPreemption(ctx context.Context, client *APIClient, loc Locator, since time.Time) (*ComputeEngineOperation, error) {
+	ops, err := client.ListZoneOperations(ctx, loc, fmt.Sprintf(`operationType="%s"`, preemptedOperationType))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list operations in zone %s: %w", loc.ZoneID, err)
+	}
+	var last *ComputeEngineOperation
+	var lastTime time.Time
+	for i, op := range ops {
+		if !strings.HasSuffix(op.TargetLink, "/instances/"+loc.InstanceID) {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, op.InsertTime)
+		if err != nil || !t.After(since) || !t.After(lastTime) {
+			continue
+		}
+		last, lastTime = &ops[i], t
+	}
+	return last, nil
+}
+
+// RestartInstance starts a stopped instance, e.g. after it was preempted, and returns the updated server.
+// It fails with common.ErrRegionUnavailable if the zone has no spot capacity left.
+func RestartInstance(ctx context.Context, client *APIClient, server common.Server, progress common.ProgressFunc) (*common.Server, error) {
+	if progress == nil {
+		progress = func(string) {}
+	}
+	loc := Locator{ProjectID: server.PlacementID, ZoneID: server.LocationID, InstanceID: server.InstanceName}
+	progress(fmt.Sprintf("Restarting instance %s", server.InstanceName))
+	if err := waitZoneOp(ctx, client, loc, func() (*ComputeEngineOperation, error) {
+		return client.StartInstance(ctx, loc)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to start instance %s: %w", server.InstanceName, err)
+	}
+	instance, err := client.GetInstance(ctx, loc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get instance %s: %w", server.InstanceName, err)
+	}
+	restarted := server
+	// The static address survives the restart; an ephemeral one would change
+	if ip := instance.ExternalIPv4(); ip != "" {
+		restarted.Config.ExternalIp = ip
+	}
+	if ip := instance.ExternalIPv6(); ip != "" {
+		restarted.Config.ExternalIpv6 = ip
+	}
+	return &restarted, nil
+}
+
+// WatchServer implements common.PreemptionWatcher. It polls the instance status and, when the instance
+// was stopped by a preemption, restarts it. If the zone is out of capacity a replacement server is
+// provisioned in another zone of the same region, or any other zone of the project if there is none.
+// The preempted instance is left stopped, so it can still be restarted or deleted later.
+func (p *Provisioner) WatchServer(ctx context.Context, server common.Server) {
+	go func() {
+		loc := Locator{ProjectID: server.PlacementID, ZoneID: server.LocationID, InstanceID: server.InstanceName}
+		since := time.Now()
+		for {
+			if err := common.Sleep(ctx, preemptionPollInterval); err != nil {
+				return
+			}
+			instance, err := p.client.GetInstance(ctx, loc)
+			if errors.Is(err, common.ErrNotFound) {
+				slog.Info("Instance no longer exists, stopping preemption watch", "instance", server.InstanceName)
+				return
+			}
+			if err != nil {
+				slog.Warn("Failed to get instance status", "instance", server.InstanceName, "err", err)
+				continue
+			}
+			if instance.Status != "TERMINATED" && instance.Status != "STOPPED" {
+				continue
+			}
+			op, err := LastPreemption(ctx, p.client, loc, since)
+			if err != nil {
+				slog.Warn("Failed to check for preemption", "instance", server.InstanceName, "err", err)
+				continue
+			}
+			if op == nil {
+				// Stopped by the user, leave it alone
+				continue
+			}
+			if t, err := time.Parse(time.RFC3339, op.InsertTime); err == nil {
+				since = t
+			}
+			slog.Info("Instance was preempted", "instance", server.InstanceName)
+			p.updateServer(server, common.ServerStatusStopped)
+			p.session.Events <- common.Event{Type: common.EventTypeServerPreempted, Message: server.InstanceName, Server: &server}
+
+			restarted, err := RestartInstance(ctx, p.client, server, p.progress)
+			if err == nil {
+				server = *restarted
+				p.updateServer(server, common.ServerStatusActive)
+				p.session.Events <- common.Event{Type: common.EventTypeServerRestarted, Message: server.Config.Encode(), Server: restarted}
+				continue
+			}
+			if !errors.Is(err, common.ErrRegionUnavailable) && !errors.Is(err, common.ErrQuotaExceeded) {
+				slog.Error("Failed to restart preempted instance", "instance", server.InstanceName, "err", err)
+				p.session.Events <- common.Event{Type: common.EventTypePreemptionRecoveryError, Error: err, Server: &server}
+				return
+			}
+			zoneID := p.replacementZone(server.PlacementID, server.LocationID)
+			if zoneID == "" {
+				p.session.Events <- common.Event{
+					Type:   common.EventTypePreemptionRecoveryError,
+					Error:  fmt.Errorf("no other zone available to replace instance %s: %w", server.InstanceName, err),
+					Server: &server,
+				}
+				return
+			}
+			p.progress(fmt.Sprintf("Zone %s is out of capacity, provisioning a replacement in %s", server.LocationID, zoneID))
+			opts := []common.ProvisionOption{common.WithSpot(), common.WithOS(server.OS)}
+			if server.Config.ExternalIpv6 != "" {
+				opts = append(opts, common.WithIPv6())
+			}
+			if p.inventory != nil && server.ID != "" {
+				if record, err := p.inventory.Get(server.ID); err == nil && record.Profile != nil {
+					opts = append(opts, common.WithInstallProfile(*record.Profile))
+				}
+			}
+			p.Provision(ctx, server.PlacementID, zoneID, opts...)
+			return
+		}
+	}()
+}
+
+// replacementZone picks another zone of the project for a server that can't run in zoneID,
+// preferring zones in the same region. It returns an empty string if there is none.
+func (p *Provisioner) replacementZone(projectID, zoneID string) string {
+	region := GetZoneRegionID(zoneID)
+	var fallback string
+	for _, c := range p.Compartments() {
+		entry := common.CompartmentEntryByID(c.Entries, projectID)
+		if entry == nil {
+			continue
+		}
+		for _, l := range entry.Locations {
+			id := l.GetID()
+			if id == zoneID {
+				continue
+			}
+			if GetZoneRegionID(id) == region {
+				return id
+			}
+			if fallback == "" {
+				fallback = id
+			}
+		}
+	}
+	return fallback
+}
+
+// EstimateCost implements common.CostEstimator. The location is ignored, see EstimateCost.
+func (p *Provisioner) EstimateCost(locationID string, opts ...common.ProvisionOption) (*common.CostEstimate, error) {
+	return EstimateCost(common.NewProvisionOptions(opts...)), nil
+}
@@ -0,0 +1,45 @@
+package gcp
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLastPreemptionFollowsPages(t *testing.T) {
+	pages := map[string]string{
+		"": `{"items": [{"name": "op-1", "targetLink": ".../instances/other", "insertTime": "2025-06-01T12:00:00Z"}], "nextPageToken": "page-2"}`,
+		"page-2": `{"items": [
+			{"name": "op-2", "targetLink": ".../instances/lantern-1", "insertTime": "2025-06-01T10:00:00Z"},
+			{"name": "op-3", "targetLink": ".../instances/lantern-1", "insertTime": "2025-06-01T11:00:00Z"}
+		], "nextPageToken": "page-3"}`,
+		"page-3": `{"items": [{"name": "op-4", "targetLink": ".../instances/lantern-10", "insertTime": "2025-06-01T13:00:00Z"}]}`,
+	}
+	client := testClient(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/zones/us-central1-a/operations") || !strings.Contains(r.URL.Query().Get("filter"), preemptedOperationType) {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		page, ok := pages[r.URL.Query().Get("pageToken")]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(page))
+	})
+	loc := Locator{ProjectID: "project", ZoneID: "us-central1-a", InstanceID: "lantern-1"}
+
+	op, err := LastPreemption(context.Background(), client, loc, time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op == nil || op.Name != "op-3" {
+		t.Errorf("last preemption = %+v, want op-3 from the second page", op)
+	}
+
+	op, err = LastPreemption(context.Background(), client, loc, time.Date(2025, 6, 1, 11, 0, 0, 0, time.UTC))
+	if err != nil || op != nil {
+		t.Errorf("preemption before since = %+v, %v, want none", op, err)
+	}
+}
@@ -0,0 +1,8 @@
+package gcp
+
+import "context"
+
+// CheckUpdates implements common.UpdateChecker.
+func (p *Provisioner) CheckUpdates(ctx context.Context) {
+	p.maintainer().CheckUpdates(ctx)
+}
@@ -0,0 +1,38 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+// UpgradeServer implements common.Upgrader. If the server's ports changed, the firewall rule
+// of the instance's network is updated to match.
+func (p *Provisioner) UpgradeServer(ctx context.Context, id string) {
+	p.maintainer().UpgradeServer(ctx, id)
+}
+
+// maintainer returns the maintenance operations for the provisioner's servers.
+func (p *Provisioner) maintainer() *common.Maintainer {
+	return &common.Maintainer{
+		Provider:       providerName,
+		Events:         p.session.Events,
+		Inventory:      p.inventory,
+		Secrets:        p.secrets,
+		Progress:       p.progress,
+		Log:            p.log,
+		LoadServer:     p.loadServer,
+		UpdateFirewall: p.updateFirewall,
+	}
+}
+
+// updateFirewall opens ports in the firewall rule of the instance's network.
+func (p *Provisioner) updateFirewall(ctx context.Context, record common.InventoryRecord, ports []common.PortRange) error {
+	loc := Locator{ProjectID: record.Server.PlacementID, ZoneID: record.Server.LocationID, InstanceID: record.Server.InstanceName}
+	instance, err := p.client.GetInstance(ctx, loc)
+	if err != nil {
+		return fmt.Errorf("failed to get instance %s: %w", record.Server.InstanceName, err)
+	}
+	return CreateNetworkFirewallIfNeeded(ctx, p.client, record.Server.PlacementID, instanceNetwork(instance), ports)
+}
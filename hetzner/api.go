@@ -0,0 +1,493 @@
+package hetzner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+const (
+	apiURL       = "https://api.hetzner.cloud/v1"
+	firewallName = "lantern-firewall" // Name of the firewall shared by all Lantern servers of a project
+	lanternLabel = "lantern"          // Label of the resources created for Lantern servers
+)
+
+// DefaultRetryPolicy is the retry policy used by new APIClient instances.
+// Hetzner rate limits are per project (3600 requests/hour), so rate-limited requests are retried after
+// the RateLimit-Reset time.
+var DefaultRetryPolicy = common.DefaultRetryPolicy
+
+// APIClient calls the Hetzner Cloud API with a project's API token.
+type APIClient struct {
+	token       string
+	client      *http.Client
+	retryPolicy common.RetryPolicy
+}
+
+// NewAPIClient creates a new APIClient with the given API token.
+// The httpClient parameter is optional; if nil, a default http.Client with a 30-second timeout is used.
+func NewAPIClient(token string, httpClient *http.Client) *APIClient {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &APIClient{
+		token:       token,
+		client:      httpClient,
+		retryPolicy: DefaultRetryPolicy,
+	}
+}
+
+// SetRetryPolicy overrides the retry policy used for API requests.
+func (c *APIClient) SetRetryPolicy(policy common.RetryPolicy) {
+	c.retryPolicy = policy
+}
+
+// ListLocations lists the locations servers can be created in.
+func (c *APIClient) ListLocations(ctx context.Context) ([]Location, error) {
+	var response struct {
+		Locations []Location `json:"locations"`
+	}
+	if err := c.request(ctx, http.MethodGet, "locations", nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Locations, nil
+}
+
+// ListDatacenters lists the datacenters, with the server types available in each.
+func (c *APIClient) ListDatacenters(ctx context.Context) ([]Datacenter, error) {
+	var response struct {
+		Datacenters []Datacenter `json:"datacenters"`
+	}
+	if err := c.request(ctx, http.MethodGet, "datacenters", nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Datacenters, nil
+}
+
+// ListServerTypes lists the server types, with their prices per location.
+func (c *APIClient) ListServerTypes(ctx context.Context) ([]ServerType, error) {
+	var response struct {
+		ServerTypes []ServerType `json:"server_types"`
+	}
+	if err := c.request(ctx, http.MethodGet, "server_types?per_page=50", nil, &response); err != nil {
+		return nil, err
+	}
+	return response.ServerTypes, nil
+}
+
+// GetSystemImage returns the x86 system image with the given name, e.g. "debian-12", or nil if there is none.
+func (c *APIClient) GetSystemImage(ctx context.Context, name string) (*Image, error) {
+	var response struct {
+		Images []Image `json:"images"`
+	}
+	query := url.Values{"type": {"system"}, "name": {name}, "architecture": {"x86"}}
+	if err := c.request(ctx, http.MethodGet, "images?"+query.Encode(), nil, &response); err != nil {
+		return nil, err
+	}
+	if len(response.Images) == 0 {
+		return nil, nil
+	}
+	return &response.Images[0], nil
+}
+
+// CreateSSHKey registers a public key in the project, so it can be authorized for root on new servers.
+func (c *APIClient) CreateSSHKey(ctx context.Context, name, publicKey string) (*SSHKey, error) {
+	data := map[string]interface{}{
+		"name":       name,
+		"public_key": publicKey,
+		"labels":     map[string]string{lanternLabel: ""},
+	}
+	var response struct {
+		SSHKey SSHKey `json:"ssh_key"`
+	}
+	if err := c.request(ctx, http.MethodPost, "ssh_keys", data, &response); err != nil {
+		return nil, err
+	}
+	return &response.SSHKey, nil
+}
+
+// DeleteSSHKey removes a public key from the project. Servers it was authorized on keep it.
+func (c *APIClient) DeleteSSHKey(ctx context.Context, id int) error {
+	return c.request(ctx, http.MethodDelete, fmt.Sprintf("ssh_keys/%d", id), nil, nil)
+}
+
+// GetFirewallByName returns the firewall with the given name, or nil if there is none.
+func (c *APIClient) GetFirewallByName(ctx context.Context, name string) (*Firewall, error) {
+	var response struct {
+		Firewalls []Firewall `json:"firewalls"`
+	}
+	if err := c.request(ctx, http.MethodGet, "firewalls?name="+url.QueryEscape(name), nil, &response); err != nil {
+		return nil, err
+	}
+	if len(response.Firewalls) == 0 {
+		return nil, nil
+	}
+	return &response.Firewalls[0], nil
+}
+
+// CreateFirewall creates a firewall. It isn't applied to any server until servers are created with it.
+func (c *APIClient) CreateFirewall(ctx context.Context, firewall Firewall) (*Firewall, error) {
+	var response struct {
+		Firewall Firewall `json:"firewall"`
+	}
+	if err := c.request(ctx, http.MethodPost, "firewalls", firewall, &response); err != nil {
+		return nil, err
+	}
+	return &response.Firewall, nil
+}
+
+// SetFirewallRules replaces the rules of a firewall and waits until they are applied to its servers.
+func (c *APIClient) SetFirewallRules(ctx context.Context, id int, rules []FirewallRule) error {
+	var response struct {
+		Actions []Action `json:"actions"`
+	}
+	data := map[string]interface{}{"rules": rules}
+	if err := c.request(ctx, http.MethodPost, fmt.Sprintf("firewalls/%d/actions/set_rules", id), data, &response); err != nil {
+		return err
+	}
+	for _, action := range response.Actions {
+		if _, err := c.WaitForAction(ctx, action.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreatePrimaryIP creates a public IPv4 address in a datacenter, to be assigned to a server.
+// An address with autoDelete is deleted with the server it is assigned to.
+func (c *APIClient) CreatePrimaryIP(ctx context.Context, name, datacenter string, autoDelete bool) (*PrimaryIP, error) {
+	data := map[string]interface{}{
+		"name":          name,
+		"type":          "ipv4",
+		"assignee_type": "server",
+		"datacenter":    datacenter,
+		"auto_delete":   autoDelete,
+		"labels":        map[string]string{lanternLabel: ""},
+	}
+	var response struct {
+		PrimaryIP PrimaryIP `json:"primary_ip"`
+	}
+	if err := c.request(ctx, http.MethodPost, "primary_ips", data, &response); err != nil {
+		return nil, err
+	}
+	return &response.PrimaryIP, nil
+}
+
+// GetPrimaryIP retrieves a primary IP by its ID.
+func (c *APIClient) GetPrimaryIP(ctx context.Context, id int) (*PrimaryIP, error) {
+	var response struct {
+		PrimaryIP PrimaryIP `json:"primary_ip"`
+	}
+	if err := c.request(ctx, http.MethodGet, fmt.Sprintf("primary_ips/%d", id), nil, &response); err != nil {
+		return nil, err
+	}
+	return &response.PrimaryIP, nil
+}
+
+// DeletePrimaryIP deletes a primary IP, which must not be assigned.
+func (c *APIClient) DeletePrimaryIP(ctx context.Context, id int) error {
+	return c.request(ctx, http.MethodDelete, fmt.Sprintf("primary_ips/%d", id), nil, nil)
+}
+
+// AssignPrimaryIP assigns a primary IP to a server, which must be powered off, and waits for it.
+func (c *APIClient) AssignPrimaryIP(ctx context.Context, id, serverID int) error {
+	data := map[string]interface{}{"assignee_id": serverID, "assignee_type": "server"}
+	return c.action(ctx, fmt.Sprintf("primary_ips/%d/actions/assign", id), data)
+}
+
+// UnassignPrimaryIP unassigns a primary IP from its server, which must be powered off, and waits for it.
+func (c *APIClient) UnassignPrimaryIP(ctx context.Context, id int) error {
+	return c.action(ctx, fmt.Sprintf("primary_ips/%d/actions/unassign", id), nil)
+}
+
+// CreateServer creates a server and waits until it is running.
+func (c *APIClient) CreateServer(ctx context.Context, data map[string]interface{}) (*Server, error) {
+	var response struct {
+		Server Server `json:"server"`
+		Action Action `json:"action"`
+	}
+	if err := c.request(ctx, http.MethodPost, "servers", data, &response); err != nil {
+		return nil, fmt.Errorf("failed to create server: %w", err)
+	}
+	slog.Debug("Server creation request sent", "serverID", response.Server.ID)
+	if _, err := c.WaitForAction(ctx, response.Action.ID); err != nil {
+		return nil, fmt.Errorf("failed to create server: %w", err)
+	}
+	return c.GetServer(ctx, response.Server.ID)
+}
+
+// GetServer retrieves a server by its ID.
+func (c *APIClient) GetServer(ctx context.Context, id int) (*Server, error) {
+	var response struct {
+		Server Server `json:"server"`
+	}
+	if err := c.request(ctx, http.MethodGet, fmt.Sprintf("servers/%d", id), nil, &response); err != nil {
+		return nil, err
+	}
+	return &response.Server, nil
+}
+
+// DeleteServer deletes a server and waits for it.
+func (c *APIClient) DeleteServer(ctx context.Context, id int) error {
+	var response struct {
+		Action Action `json:"action"`
+	}
+	if err := c.request(ctx, http.MethodDelete, fmt.Sprintf("servers/%d", id), nil, &response); err != nil {
+		return err
+	}
+	_, err := c.WaitForAction(ctx, response.Action.ID)
+	return err
+}
+
+// PowerOffServer cuts the power of a server and waits for it.
+func (c *APIClient) PowerOffServer(ctx context.Context, id int) error {
+	return c.action(ctx, fmt.Sprintf("servers/%d/actions/poweroff", id), nil)
+}
+
+// PowerOnServer starts a server and waits for it.
+func (c *APIClient) PowerOnServer(ctx context.Context, id int) error {
+	return c.action(ctx, fmt.Sprintf("servers/%d/actions/poweron", id), nil)
+}
+
+// action starts an action on a resource and waits for it to complete.
+func (c *APIClient) action(ctx context.Context, actionPath string, data interface{}) error {
+	var response struct {
+		Action Action `json:"action"`
+	}
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	if err := c.request(ctx, http.MethodPost, actionPath, data, &response); err != nil {
+		return err
+	}
+	_, err := c.WaitForAction(ctx, response.Action.ID)
+	return err
+}
+
+// GetAction retrieves an action by its ID.
+func (c *APIClient) GetAction(ctx context.Context, id int) (*Action, error) {
+	var response struct {
+		Action Action `json:"action"`
+	}
+	if err := c.request(ctx, http.MethodGet, fmt.Sprintf("actions/%d", id), nil, &response); err != nil {
+		return nil, err
+	}
+	return &response.Action, nil
+}
+
+// WaitForAction polls an action until it completes.
+func (c *APIClient) WaitForAction(ctx context.Context, id int) (*Action, error) {
+	maxRequests := 90
+	pollInterval := 2 * time.Second
+	for requestCount := 0; requestCount < maxRequests; requestCount++ {
+		action, err := c.GetAction(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get action %d: %w", id, err)
+		}
+		slog.Debug("Action state", "id", id, "command", action.Command, "status", action.Status)
+		switch action.Status {
+		case "success":
+			return action, nil
+		case "error":
+			if action.Error != nil {
+				return action, fmt.Errorf("action %d (%s) failed: %s", id, action.Command, action.Error.Message)
+			}
+			return action, fmt.Errorf("action %d (%s) failed", id, action.Command)
+		}
+		if err := common.Sleep(ctx, pollInterval); err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("action %d didn't complete after %d attempts", id, maxRequests)
+}
+
+// request makes an HTTP request to the Hetzner Cloud API, retrying it according to the client's retry policy
+// as adjusted for the method by common.RetryPolicy.ForMethod.
+func (c *APIClient) request(ctx context.Context, method, actionPath string, data interface{}, response interface{}) error {
+	var jsonData []byte
+	if data != nil {
+		var err error
+		if jsonData, err = json.Marshal(data); err != nil {
+			return err
+		}
+	}
+	return c.retryPolicy.ForMethod(method).Do(ctx, func(ctx context.Context) error {
+		return c.doRequest(ctx, method, actionPath, jsonData, response)
+	})
+}
+
+// doRequest performs a single HTTP request to the Hetzner Cloud API.
+func (c *APIClient) doRequest(ctx context.Context, method, actionPath string, jsonData []byte, response interface{}) error {
+	var reqBody io.Reader
+	if jsonData != nil {
+		reqBody = bytes.NewReader(jsonData)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, apiURL+"/"+actionPath, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		err = fmt.Errorf("error performing Hetzner request: %w", err)
+		if common.IsRetriable(err) {
+			return &common.ProviderError{Provider: providerName, Category: common.ErrTransient, Err: err}
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		if response != nil && len(body) > 0 {
+			if err := json.Unmarshal(body, response); err != nil {
+				return fmt.Errorf("error parsing response body: %w", err)
+			}
+		}
+		return nil
+	}
+
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		retryAfter: common.ParseRetryAfter(resp.Header, time.Now()),
+	}
+	var responseJSON struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &responseJSON); err != nil {
+		// Gateways may return non-JSON bodies for 5xx errors; keep the status so the request can be retried.
+		apiErr.Message = fmt.Sprintf("error parsing response body: %v", err)
+	} else {
+		apiErr.Code = responseJSON.Error.Code
+		apiErr.Message = responseJSON.Error.Message
+	}
+	slog.Debug("Hetzner request failed", "status", resp.StatusCode, "code", apiErr.Code)
+	return toProviderError(apiErr)
+}
+
+// CreateFirewallIfNeeded makes sure the project's Lantern firewall allows the given ports from anywhere,
+// over both IPv4 and IPv6, and returns its ID. The ports include SSH's, e.g. InstallProfile.SSHPort(),
+// so a hardened server's port 22 isn't opened. The firewall is created if it doesn't exist.
+// An existing one is updated in place: rules are added for the requested ports it doesn't allow yet.
+// It is shared by all Lantern servers, so its rules are left as they are, including over-broad ones
+// allowing all ports or other protocols. This mirrors digitalocean.CreateFirewallIfNeeded.
+func CreateFirewallIfNeeded(ctx context.Context, client *APIClient, ports []common.PortRange) (int, error) {
+	existing, err := client.GetFirewallByName(ctx, firewallName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get firewall: %w", err)
+	}
+	if existing != nil {
+		if _, broad := firewallRulePorts(existing.Rules); len(broad) > 0 {
+			slog.Warn("Firewall allows more than specific ports, leaving those rules in place", "firewallName", firewallName, "rules", len(broad))
+		}
+		rules, changed := mergeFirewallRules(existing.Rules, ports)
+		if !changed {
+			slog.Info("Firewall already up to date", "firewallName", firewallName)
+			return existing.ID, nil
+		}
+		slog.Info("Updating firewall", "firewallName", firewallName, "ports", ports)
+		// set_rules replaces all rules, the outbound ones are part of the existing rules
+		if err := client.SetFirewallRules(ctx, existing.ID, rules); err != nil {
+			return 0, fmt.Errorf("failed to update firewall: %w", err)
+		}
+		return existing.ID, nil
+	}
+
+	fw, err := client.CreateFirewall(ctx, Firewall{
+		Name:   firewallName,
+		Labels: map[string]string{lanternLabel: ""},
+		Rules:  firewallRules(ports),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to create firewall: %w", err)
+	}
+	slog.Info("Firewall created successfully", "firewallID", fw.ID, "ports", ports)
+	return fw.ID, nil
+}
+
+// firewallRules returns one inbound rule per port range, open to all IPv4 and IPv6 addresses.
+// Without outbound rules, Hetzner allows all outbound traffic.
+func firewallRules(ports []common.PortRange) []FirewallRule {
+	var rules []FirewallRule
+	for _, p := range common.MergePorts(ports) {
+		rules = append(rules, FirewallRule{
+			Direction: "in",
+			Protocol:  p.Protocol,
+			Port:      p.Ports(),
+			SourceIPs: []string{common.AnyIPv4, common.AnyIPv6},
+		})
+	}
+	return rules
+}
+
+// firewallRulePorts converts inbound rules back to ports. Inbound rules that can't be expressed as ports
+// are returned as broad: those allowing all ports, or a protocol other than TCP and UDP. Outbound rules
+// are ignored.
+func firewallRulePorts(rules []FirewallRule) (ports []common.PortRange, broad []FirewallRule) {
+	for _, rule := range rules {
+		if rule.Direction != "in" {
+			continue
+		}
+		if rule.Protocol != "tcp" && rule.Protocol != "udp" {
+			broad = append(broad, rule)
+			continue
+		}
+		port, err := common.ParsePortRange(rule.Port + "/" + rule.Protocol)
+		if err != nil {
+			// "any" means all ports
+			broad = append(broad, rule)
+			continue
+		}
+		ports = append(ports, port)
+	}
+	return common.MergePorts(ports), broad
+}
+
+// mergeFirewallRules adds inbound rules for the ports the shared firewall doesn't allow yet.
+// Existing rules are kept as they are, other servers may rely on them. changed is false if the rules
+// already allow all ports.
+func mergeFirewallRules(existing []FirewallRule, ports []common.PortRange) (rules []FirewallRule, changed bool) {
+	current, broad := firewallRulePorts(existing)
+	allowed := func(p common.PortRange) bool {
+		return slices.ContainsFunc(current, func(c common.PortRange) bool { return c.Contains(p) }) ||
+			slices.ContainsFunc(broad, func(r FirewallRule) bool {
+				return r.Protocol == p.Protocol // A TCP or UDP rule is only broad if it allows all ports
+			})
+	}
+	var missing []common.PortRange
+	for _, p := range ports {
+		if !allowed(p) {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) == 0 {
+		return existing, false
+	}
+	return append(slices.Clone(existing), firewallRules(missing)...), true
+}
+
+// releasePrimaryIP deletes a primary IP, logging failures. A leftover address only costs money,
+// so it doesn't fail the calling operation. An address that doesn't exist is ignored.
+func releasePrimaryIP(ctx context.Context, client *APIClient, id int) {
+	if err := client.DeletePrimaryIP(ctx, id); err != nil && !errors.Is(err, common.ErrNotFound) {
+		slog.Error("Failed to release primary IP", "id", id, "err", err)
+	}
+}
@@ -0,0 +1,77 @@
+package hetzner
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+func inbound(protocol, port string) FirewallRule {
+	return FirewallRule{Direction: "in", Protocol: protocol, Port: port, SourceIPs: []string{common.AnyIPv4, common.AnyIPv6}}
+}
+
+func TestFirewallRulePorts(t *testing.T) {
+	outbound := FirewallRule{Direction: "out", Protocol: "tcp", Port: "any"}
+	ports, broad := firewallRulePorts([]FirewallRule{
+		inbound("tcp", "443"), inbound("tcp", "22"), inbound("udp", "30000-30100"),
+		inbound("udp", "any"), inbound("icmp", ""), outbound,
+	})
+	wantPorts := []common.PortRange{common.Port("tcp", 22), common.Port("tcp", 443), {Protocol: "udp", From: 30000, To: 30100}}
+	if !reflect.DeepEqual(ports, wantPorts) {
+		t.Errorf("ports = %v, want %v", ports, wantPorts)
+	}
+	wantBroad := []FirewallRule{inbound("udp", "any"), inbound("icmp", "")}
+	if !reflect.DeepEqual(broad, wantBroad) {
+		t.Errorf("broad = %v, want %v", broad, wantBroad)
+	}
+}
+
+func TestMergeFirewallRules(t *testing.T) {
+	outbound := FirewallRule{Direction: "out", Protocol: "tcp", Port: "443", DestinationIPs: []string{common.AnyIPv4}}
+	tests := []struct {
+		name     string
+		existing []FirewallRule
+		ports    []common.PortRange
+		want     []FirewallRule
+		changed  bool
+	}{
+		{
+			name:     "ports already allowed",
+			existing: []FirewallRule{inbound("tcp", "22"), inbound("udp", "30000-30100")},
+			ports:    []common.PortRange{common.SSHPort, common.Port("udp", 30050)},
+			want:     []FirewallRule{inbound("tcp", "22"), inbound("udp", "30000-30100")},
+		},
+		{
+			name:     "rules of other servers are kept",
+			existing: []FirewallRule{inbound("tcp", "22"), inbound("tcp", "443"), outbound},
+			ports:    []common.PortRange{common.SSHPort, common.Port("udp", 8443)},
+			want:     []FirewallRule{inbound("tcp", "22"), inbound("tcp", "443"), outbound, inbound("udp", "8443")},
+			changed:  true,
+		},
+		{
+			name:     "covered by a broad rule",
+			existing: []FirewallRule{inbound("tcp", "any")},
+			ports:    []common.PortRange{common.SSHPort, common.Port("tcp", 443)},
+			want:     []FirewallRule{inbound("tcp", "any")},
+		},
+		{
+			name:     "broad rules are kept when adding ports",
+			existing: []FirewallRule{inbound("icmp", ""), inbound("udp", "any")},
+			ports:    []common.PortRange{common.SSHPort, common.Port("udp", 8443)},
+			want:     []FirewallRule{inbound("icmp", ""), inbound("udp", "any"), inbound("tcp", "22")},
+			changed:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed := mergeFirewallRules(tt.existing, tt.ports)
+			if changed != tt.changed {
+				t.Errorf("changed = %v, want %v", changed, tt.changed)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rules = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package hetzner
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+const providerName = "hetzner"
+
+// APIError represents an error response returned by the Hetzner Cloud API.
+// See https://docs.hetzner.cloud/reference/cloud#errors
+type APIError struct {
+	StatusCode int           // HTTP status code of the response
+	Code       string        // Error code, e.g. "resource_limit_exceeded" or "uniqueness_error"
+	Message    string        // Human-readable error message
+	retryAfter time.Duration // Delay requested via the RateLimit-Reset header
+}
+
+func (e *APIError) Error() string {
+	if e.StatusCode == http.StatusUnauthorized {
+		return "Hetzner request failed with Unauthorized error"
+	}
+	return fmt.Sprintf("request failed with %d (%s): %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Retriable implements common.Retriable.
+func (e *APIError) Retriable() bool {
+	return common.IsRetriableStatus(e.StatusCode) || e.Code == "locked" || e.Code == "conflict"
+}
+
+// RetryAfter implements common.RetryAfterHint.
+func (e *APIError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// toProviderError classifies a Hetzner API error into the common error taxonomy.
+func toProviderError(e *APIError) *common.ProviderError {
+	category := common.CategoryForStatus(e.StatusCode)
+	switch e.Code {
+	case "unauthorized":
+		category = common.ErrUnauthorized
+	case "forbidden", "token_readonly":
+		category = common.ErrPermissionDenied
+	case "not_found":
+		category = common.ErrNotFound
+	case "rate_limit_exceeded":
+		category = common.ErrRateLimited
+	case "resource_limit_exceeded":
+		// e.g. the project's server or primary IP limit
+		category = common.ErrQuotaExceeded
+	case "resource_unavailable", "placement_error":
+		// The server type is sold out in the location
+		category = common.ErrRegionUnavailable
+	case "locked", "conflict", "maintenance", "timeout", "unavailable", "server_error":
+		category = common.ErrTransient
+	}
+	return &common.ProviderError{
+		Provider:   providerName,
+		Category:   category,
+		StatusCode: e.StatusCode,
+		Code:       e.Code,
+		Message:    e.Message,
+		Err:        e,
+	}
+}
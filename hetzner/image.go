@@ -0,0 +1,35 @@
+package hetzner
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+// imageNames are the names of the system images, which Hetzner keeps pointing to the latest build.
+var imageNames = map[common.OSTarget]string{
+	common.OSUbuntu2204: "ubuntu-22.04",
+	common.OSUbuntu2404: "ubuntu-24.04",
+	common.OSDebian12:   "debian-12",
+}
+
+// LatestImage returns the name of the latest system image of target. Deprecated images are refused,
+// so a server is never created with an EOL distribution.
+func LatestImage(ctx context.Context, client *APIClient, target common.OSTarget) (string, error) {
+	name, ok := imageNames[target]
+	if !ok {
+		return "", fmt.Errorf("no Hetzner image for %s", target)
+	}
+	image, err := client.GetSystemImage(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to get image %s: %w", name, err)
+	}
+	if image == nil {
+		return "", fmt.Errorf("image %s is no longer offered", name)
+	}
+	if image.Deprecated != nil {
+		return "", fmt.Errorf("image %s was deprecated on %s", name, *image.Deprecated)
+	}
+	return image.Name, nil
+}
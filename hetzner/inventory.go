@@ -0,0 +1,177 @@
+package hetzner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+// RefreshServer reconciles an inventory record with the server's actual state.
+// It updates the record's status and addresses; a deleted server is marked missing.
+func RefreshServer(ctx context.Context, client *APIClient, record *common.InventoryRecord) error {
+	server := &record.Server
+	serverID, err := strconv.Atoi(server.InstanceID)
+	if err != nil {
+		return fmt.Errorf("invalid server ID %q: %w", server.InstanceID, err)
+	}
+	hs, err := client.GetServer(ctx, serverID)
+	if errors.Is(err, common.ErrNotFound) {
+		record.Status = common.ServerStatusMissing
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	record.Status = common.RefreshedStatus(record.Status, serverStatus(hs))
+	if server.StaticIPName != "" {
+		if hs.PublicNet.IPv4 == nil || strconv.Itoa(hs.PublicNet.IPv4.ID) != server.StaticIPName {
+			// Unassigned or replaced outside of the library
+			server.StaticIPName = ""
+		}
+	}
+	if ip := hs.PublicIPv4(); ip != "" {
+		server.Config.ExternalIp = ip
+	}
+	server.Config.ExternalIpv6 = hs.PublicIPv6()
+	return nil
+}
+
+// serverStatus maps a server status to the inventory status.
+func serverStatus(hs *Server) common.ServerStatus {
+	switch hs.Status {
+	case "off", "stopping":
+		return common.ServerStatusStopped
+	}
+	return common.ServerStatusActive
+}
+
+// DestroyServer deletes a server and its kept primary IP.
+// A server that no longer exists is not an error, so a partially destroyed server can be cleaned up.
+func DestroyServer(ctx context.Context, client *APIClient, server common.Server, progress common.ProgressFunc) error {
+	if progress == nil {
+		progress = func(string) {}
+	}
+	serverID, err := strconv.Atoi(server.InstanceID)
+	if err != nil {
+		return fmt.Errorf("invalid server ID %q: %w", server.InstanceID, err)
+	}
+	progress(fmt.Sprintf("Deleting server %s", server.InstanceName))
+	if err := client.DeleteServer(ctx, serverID); err != nil && !errors.Is(err, common.ErrNotFound) {
+		return fmt.Errorf("failed to delete server %d: %w", serverID, err)
+	}
+	if server.StaticIPName != "" {
+		if id, err := strconv.Atoi(server.StaticIPName); err == nil {
+			progress("Releasing the primary IP")
+			releasePrimaryIP(ctx, client, id)
+		}
+	}
+	return nil
+}
+
+// SetInventory implements common.ServerManager.
+func (p *Provisioner) SetInventory(inventory common.Inventory) {
+	p.inventory = inventory
+}
+
+// Inventory implements common.ServerManager.
+func (p *Provisioner) Inventory() common.Inventory {
+	return p.inventory
+}
+
+// SetSecretStore implements common.ServerManager.
+func (p *Provisioner) SetSecretStore(store common.SecretStore) {
+	p.secrets = store
+}
+
+// SecretStore implements common.ServerManager.
+func (p *Provisioner) SecretStore() common.SecretStore {
+	return p.secrets
+}
+
+// DestroyServer implements common.ServerManager.
+func (p *Provisioner) DestroyServer(ctx context.Context, id string) {
+	go func() {
+		p.session.Events <- common.Event{Type: common.EventTypeDestroyStarted, Message: id}
+		record, client, err := p.loadServer(ctx, id)
+		if err != nil {
+			slog.Error("Failed to load server", "id", id, "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeDestroyError, Error: err}
+			return
+		}
+		if err := DestroyServer(ctx, client, record.Server, p.progress); err != nil {
+			slog.Error("Failed to destroy server", "id", id, "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeDestroyError, Error: err}
+			return
+		}
+		if err := common.ForgetServer(p.inventory, p.secrets, id); err != nil {
+			slog.Error("Failed to remove server from inventory", "id", id, "err", err)
+		}
+		p.session.Events <- common.Event{Type: common.EventTypeDestroyCompleted, Message: id, Server: &record.Server}
+	}()
+}
+
+// RotateServerIP implements common.ServerManager.
+func (p *Provisioner) RotateServerIP(ctx context.Context, id string) {
+	go func() {
+		p.session.Events <- common.Event{Type: common.EventTypeIPRotationStarted, Message: id}
+		record, client, err := p.loadServer(ctx, id)
+		if err == nil && record.Status == common.ServerStatusMissing {
+			err = fmt.Errorf("server %s no longer exists: %w", record.Server.InstanceID, common.ErrNotFound)
+		}
+		if err != nil {
+			slog.Error("Failed to load server", "id", id, "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeIPRotationError, Message: id, Error: err}
+			return
+		}
+		p.rotateIP(ctx, client, record.Server)
+	}()
+}
+
+// loadServer gets a server from the inventory, reconciled with the server's actual state, and the
+// client of its project.
+func (p *Provisioner) loadServer(ctx context.Context, id string) (*common.InventoryRecord, *APIClient, error) {
+	if p.inventory == nil {
+		return nil, nil, errors.New("no inventory configured")
+	}
+	record, err := p.inventory.Get(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	prj, err := p.project(record.Server.PlacementID)
+	if err != nil {
+		return nil, nil, err
+	}
+	record, err = common.LoadServer(p.inventory, id, func(record *common.InventoryRecord) error {
+		return RefreshServer(ctx, prj.client, record)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return record, prj.client, nil
+}
+
+// recordServer assigns the server a local ID, adds it to the inventory and stores its SSH key.
+// Failing to add the server to the inventory doesn't fail provisioning, the server is usable either way.
+// Failing to store the SSH key does: without it the server can't be installed or maintained.
+func (p *Provisioner) recordServer(server *common.Server, sshUser, sshPrivateKey string, status common.ServerStatus, profile *common.InstallProfile) error {
+	record := common.NewInventoryRecord(server, sshUser)
+	record.Status = status
+	record.Profile = profile
+	if p.inventory != nil {
+		if err := p.inventory.Put(record); err != nil {
+			slog.Error("Failed to add server to inventory", "id", server.ID, "err", err)
+		}
+	}
+	return common.StoreSSHKey(p.secrets, server.ID, sshPrivateKey)
+}
+
+// updateServer saves a changed server in the inventory.
+func (p *Provisioner) updateServer(server common.Server, status common.ServerStatus) {
+	if err := common.UpdateServer(p.inventory, server, status); err != nil {
+		slog.Error("Failed to update server in inventory", "id", server.ID, "err", err)
+	}
+}
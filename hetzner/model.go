@@ -0,0 +1,204 @@
+package hetzner
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+// Location represents a Hetzner Cloud location.
+// Reference:
+// https://docs.hetzner.cloud/reference/cloud#locations
+type Location struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"` // e.g. "fsn1"
+	Description string `json:"description"`
+	Country     string `json:"country"`
+	City        string `json:"city"`
+	NetworkZone string `json:"network_zone"` // e.g. "eu-central" or "us-east"
+}
+
+// GetID implements the CloudLocation interface.
+func (l Location) GetID() string {
+	return l.Name
+}
+
+// GetLocation implements the CloudLocation interface.
+func (l Location) GetLocation() *common.GeoLocation {
+	locationMap := map[string]*common.GeoLocation{
+		"fsn1": &common.FALKENSTEIN,
+		"nbg1": &common.NUREMBERG,
+		"hel1": &common.HELSINKI,
+		"ash":  &common.ASHBURN,
+		"hil":  &common.HILLSBORO,
+		"sin":  &common.SINGAPORE,
+	}
+	return locationMap[l.Name]
+}
+
+// Datacenter represents a datacenter in a location, with the server types it can host.
+// Reference:
+// https://docs.hetzner.cloud/reference/cloud#datacenters
+type Datacenter struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name"` // e.g. "fsn1-dc14"
+	Description string   `json:"description"`
+	Location    Location `json:"location"`
+	ServerTypes struct {
+		Supported []int `json:"supported"`
+		Available []int `json:"available"` // Server types that can be created right now
+	} `json:"server_types"`
+}
+
+// ServerType represents a Hetzner Cloud server type, e.g. "cx22".
+// Reference:
+// https://docs.hetzner.cloud/reference/cloud#server-types
+type ServerType struct {
+	ID           int               `json:"id"`
+	Name         string            `json:"name"`
+	Description  string            `json:"description"`
+	Cores        int               `json:"cores"`
+	Memory       float64           `json:"memory"` // GB
+	Disk         int               `json:"disk"`   // GB
+	Architecture string            `json:"architecture"`
+	Deprecated   bool              `json:"deprecated"`
+	Prices       []ServerTypePrice `json:"prices"`
+}
+
+// ServerTypePrice is the price of a server type in a location. Amounts are decimal strings in EUR.
+type ServerTypePrice struct {
+	Location     string `json:"location"`
+	PriceHourly  Price  `json:"price_hourly"`
+	PriceMonthly Price  `json:"price_monthly"`
+}
+
+type Price struct {
+	Net   string `json:"net"`
+	Gross string `json:"gross"`
+}
+
+// monthlyPrice returns the gross monthly price of the server type in a location, and false if it isn't sold there.
+func (t ServerType) monthlyPrice(location string) (float64, bool) {
+	for _, p := range t.Prices {
+		if p.Location == location {
+			price, err := strconv.ParseFloat(p.PriceMonthly.Gross, 64)
+			return price, err == nil
+		}
+	}
+	return 0, false
+}
+
+// Image represents a Hetzner Cloud image.
+// Reference:
+// https://docs.hetzner.cloud/reference/cloud#images
+type Image struct {
+	ID           int     `json:"id"`
+	Name         string  `json:"name"` // e.g. "ubuntu-24.04", set for system images
+	Type         string  `json:"type"` // "system", "app", "snapshot" or "backup"
+	Status       string  `json:"status"`
+	OSFlavor     string  `json:"os_flavor"`
+	OSVersion    string  `json:"os_version"`
+	Architecture string  `json:"architecture"`
+	Deprecated   *string `json:"deprecated"` // When the image was deprecated, nil if it isn't
+}
+
+// SSHKey represents an SSH key registered in a project.
+type SSHKey struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	PublicKey   string `json:"public_key"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// Firewall represents a Hetzner Cloud firewall.
+// Reference:
+// https://docs.hetzner.cloud/reference/cloud#firewalls
+type Firewall struct {
+	ID     int               `json:"id,omitempty"`
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Rules  []FirewallRule    `json:"rules"`
+}
+
+// FirewallRule allows traffic. Servers with a firewall drop inbound traffic no rule allows;
+// outbound traffic is allowed unless there are outbound rules.
+type FirewallRule struct {
+	Direction      string   `json:"direction"` // "in" or "out"
+	Protocol       string   `json:"protocol"`  // "tcp", "udp", "icmp", "esp" or "gre"
+	Port           string   `json:"port,omitempty"`
+	SourceIPs      []string `json:"source_ips,omitempty"`      // Inbound rules
+	DestinationIPs []string `json:"destination_ips,omitempty"` // Outbound rules
+	Description    string   `json:"description,omitempty"`
+}
+
+// PrimaryIP represents a public address that can be assigned to a server in the same datacenter.
+// Reference:
+// https://docs.hetzner.cloud/reference/cloud#primary-ips
+type PrimaryIP struct {
+	ID         int               `json:"id"`
+	Name       string            `json:"name"`
+	IP         string            `json:"ip"`
+	Type       string            `json:"type"`        // "ipv4" or "ipv6"
+	AssigneeID *int              `json:"assignee_id"` // Server the address is assigned to, nil if none
+	AutoDelete bool              `json:"auto_delete"` // Deleted with the server it is assigned to
+	Datacenter Datacenter        `json:"datacenter"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+// Server represents a Hetzner Cloud server.
+// Reference:
+// https://docs.hetzner.cloud/reference/cloud#servers
+type Server struct {
+	ID         int               `json:"id"`
+	Name       string            `json:"name"`
+	Status     string            `json:"status"` // e.g. "initializing", "running" or "off"
+	Labels     map[string]string `json:"labels"`
+	Datacenter Datacenter        `json:"datacenter"`
+	PublicNet  struct {
+		IPv4 *struct {
+			ID int    `json:"id"`
+			IP string `json:"ip"`
+		} `json:"ipv4"`
+		IPv6 *struct {
+			ID int    `json:"id"`
+			IP string `json:"ip"` // The server's /64 network, e.g. "2001:db8::/64"
+		} `json:"ipv6"`
+	} `json:"public_net"`
+}
+
+// PublicIPv4 returns the server's public IPv4 address, or an empty string if it has none.
+func (s *Server) PublicIPv4() string {
+	if s.PublicNet.IPv4 == nil {
+		return ""
+	}
+	return s.PublicNet.IPv4.IP
+}
+
+// PublicIPv6 returns the server's public IPv6 address, or an empty string if it has none.
+// Servers get a /64 network and are configured with its first address.
+func (s *Server) PublicIPv6() string {
+	if s.PublicNet.IPv6 == nil {
+		return ""
+	}
+	ip, _, err := net.ParseCIDR(s.PublicNet.IPv6.IP)
+	if err != nil || ip.To16() == nil {
+		return ""
+	}
+	addr := ip.To16()
+	addr[len(addr)-1] = 1
+	return addr.String()
+}
+
+// Action is an asynchronous operation, e.g. creating a server.
+// Reference:
+// https://docs.hetzner.cloud/reference/cloud#actions
+type Action struct {
+	ID      int    `json:"id"`
+	Command string `json:"command"`
+	Status  string `json:"status"` // "running", "success" or "error"
+	Error   *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
@@ -0,0 +1,366 @@
+package hetzner
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+type Provisioner struct {
+	session   *common.Session
+	mu        sync.Mutex // Guards projects
+	projects  []*project
+	inventory common.Inventory
+	secrets   common.SecretStore
+}
+
+// project is a Hetzner Cloud project, which API tokens are scoped to.
+type project struct {
+	id          string
+	client      *APIClient
+	locations   []Location
+	datacenters []Datacenter
+	serverTypes []ServerType
+}
+
+// GetProvisioner returns a Hetzner Cloud provisioner. Hetzner has no OAuth flow: each project has its own
+// API tokens, created in the Cloud Console under Security, which are passed to Validate. Without an OS keychain,
+// SSH keys are kept in a file encrypted with the passphrase returned by passphrase, see common.DefaultSecretStore.
+func GetProvisioner(passphrase common.PassphraseFunc) *Provisioner {
+	return &Provisioner{
+		// Nothing to cancel without an OAuth flow
+		session:   &common.Session{Events: make(chan common.Event), Cancel: func() {}},
+		inventory: common.DefaultInventory(),
+		secrets:   common.DefaultSecretStore(passphrase),
+	}
+}
+
+// projectID returns the placement ID of the project of an API token. The API doesn't reveal the
+// project's ID or name, so it is derived from the token.
+func projectID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "project-" + hex.EncodeToString(sum[:4])
+}
+
+// Validate checks an API token and collects its project's locations and server types. A token only grants
+// access to one project, so Validate can be called once per project; each becomes an entry of Compartments.
+// Servers of a project can only be managed after its token was validated.
+func (p *Provisioner) Validate(ctx context.Context, token string) {
+	client := NewAPIClient(token, nil)
+	go func(resultChan chan<- common.Event) {
+		resultChan <- common.Event{Type: common.EventTypeValidationStarted}
+
+		prj := &project{id: projectID(token), client: client}
+		var err error
+		if prj.locations, err = client.ListLocations(ctx); err != nil {
+			slog.Error("Failed to list locations", "err", err)
+			resultChan <- common.Event{Type: common.EventTypeValidationError, Error: err}
+			return
+		}
+		if prj.datacenters, err = client.ListDatacenters(ctx); err != nil {
+			slog.Error("Failed to list datacenters", "err", err)
+			resultChan <- common.Event{Type: common.EventTypeValidationError, Error: err}
+			return
+		}
+		if prj.serverTypes, err = client.ListServerTypes(ctx); err != nil {
+			slog.Error("Failed to list server types", "err", err)
+			resultChan <- common.Event{Type: common.EventTypeValidationError, Error: err}
+			return
+		}
+
+		p.mu.Lock()
+		p.projects = slices.DeleteFunc(p.projects, func(existing *project) bool { return existing.id == prj.id })
+		p.projects = append(p.projects, prj)
+		p.mu.Unlock()
+		slog.Debug("Validation completed", "project", prj.id, "locations", len(prj.locations), "serverTypes", len(prj.serverTypes))
+		resultChan <- common.Event{Type: common.EventTypeValidationCompleted}
+	}(p.session.Events)
+}
+
+// Compartments returns one compartment with an entry per validated project, listing the locations
+// where a server can be created right now.
+func (p *Provisioner) Compartments() []common.Compartment {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var entries []common.CompartmentEntry
+	for _, prj := range p.projects {
+		var locs []common.CloudLocation
+		for _, loc := range prj.locations {
+			if _, _, err := prj.placement(loc.Name); err == nil {
+				locs = append(locs, loc)
+			}
+		}
+		entries = append(entries, common.CompartmentEntry{ID: prj.id, Locations: locs})
+	}
+	return []common.Compartment{{ID: providerName, Name: "Hetzner Cloud", Entries: entries}}
+}
+
+func (p *Provisioner) Session() *common.Session {
+	return p.session
+}
+
+// project returns the validated project with the given placement ID.
+func (p *Provisioner) project(id string) (*project, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i := slices.IndexFunc(p.projects, func(prj *project) bool { return prj.id == id })
+	if i < 0 {
+		return nil, fmt.Errorf("project %s wasn't validated, validate its API token first", id)
+	}
+	return p.projects[i], nil
+}
+
+// placement picks a datacenter in location and the cheapest x86 server type available there.
+func (prj *project) placement(location string) (*Datacenter, *ServerType, error) {
+	var dc *Datacenter
+	var serverType *ServerType
+	var price float64
+	for i := range prj.datacenters {
+		d := &prj.datacenters[i]
+		if d.Location.Name != location {
+			continue
+		}
+		for j := range prj.serverTypes {
+			t := &prj.serverTypes[j]
+			p, ok := t.monthlyPrice(location)
+			if !ok || t.Deprecated || t.Architecture != "x86" || !slices.Contains(d.ServerTypes.Available, t.ID) {
+				continue
+			}
+			if serverType == nil || p < price {
+				dc, serverType, price = d, t, p
+			}
+		}
+	}
+	if serverType == nil {
+		return nil, nil, fmt.Errorf("no server type available in %s: %w", location, common.ErrRegionUnavailable)
+	}
+	return dc, serverType, nil
+}
+
+// Provision creates a server in the project placementID and location locationID, e.g. "fsn1", and installs it.
+// The server gets the cheapest x86 server type available in the location, the project's Lantern firewall
+// and a primary IPv4 address, which is kept when the server is deleted if common.WithStaticIP is given.
+func (p *Provisioner) Provision(ctx context.Context, placementID string, locationID string, opts ...common.ProvisionOption) {
+	options := common.NewProvisionOptions(opts...)
+	go func() {
+		p.session.Events <- common.Event{Type: common.EventTypeProvisioningStarted, Message: placementID}
+		fail := func(msg string, err error) {
+			slog.Error(msg, "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err}
+		}
+
+		prj, err := p.project(placementID)
+		if err != nil {
+			fail("Failed to get project", err)
+			return
+		}
+		client := prj.client
+		recipe, err := common.Recipe(options.OS)
+		if err == nil {
+			err = recipe.CheckSupported(time.Now())
+		}
+		if err != nil {
+			fail("Unsupported operating system", err)
+			return
+		}
+		if err := common.CheckSecretStore(p.secrets); err != nil {
+			fail("Secret store is unusable", fmt.Errorf("SSH keys can't be stored: %w", err))
+			return
+		}
+		dc, serverType, err := prj.placement(locationID)
+		if err != nil {
+			fail("Failed to find a server type", err)
+			return
+		}
+		image, err := LatestImage(ctx, client, recipe.Target)
+		if err != nil {
+			fail("Failed to get image", err)
+			return
+		}
+
+		publicSSHKey, privateSSHKey, err := common.MakeSSHKeyPair()
+		if err != nil {
+			fail("Failed to create SSH key pair", err)
+			return
+		}
+
+		// Only SSH is needed until the server is installed and its service ports are known
+		firewallID, err := CreateFirewallIfNeeded(ctx, client, []common.PortRange{options.InstallProfile().SSHPort()})
+		if err != nil {
+			fail("Failed to create firewall", err)
+			return
+		}
+
+		name := common.MakeInstanceName()
+		sshKey, err := client.CreateSSHKey(ctx, name, publicSSHKey)
+		if err != nil {
+			fail("Failed to register SSH key", err)
+			return
+		}
+		// The key is only needed to create the server, which keeps it authorized
+		defer func() {
+			if err := client.DeleteSSHKey(ctx, sshKey.ID); err != nil {
+				slog.Warn("Failed to delete SSH key", "id", sshKey.ID, "err", err)
+			}
+		}()
+
+		p.progress(fmt.Sprintf("Creating a %s server in %s", serverType.Name, dc.Name))
+		pip, err := client.CreatePrimaryIP(ctx, name, dc.Name, !options.StaticIP)
+		if err != nil {
+			fail("Failed to create primary IP", err)
+			return
+		}
+		spec := map[string]interface{}{
+			"name":        name,
+			"server_type": serverType.Name,
+			"image":       image,
+			"datacenter":  dc.Name,
+			"ssh_keys":    []int{sshKey.ID},
+			"labels":      map[string]string{lanternLabel: ""},
+			"firewalls":   []map[string]int{{"firewall": firewallID}},
+			"public_net": map[string]interface{}{
+				"enable_ipv4": true,
+				"enable_ipv6": true,
+				"ipv4":        pip.ID,
+			},
+		}
+		if script := recipe.FirstBootScript(options.InstallProfile()); script != "" {
+			// The firewall only allows a hardened SSH port, so sshd is moved there before it's reached
+			spec["user_data"] = script
+		}
+		hs, err := client.CreateServer(ctx, spec)
+		if err != nil {
+			releasePrimaryIP(ctx, client, pip.ID)
+			fail("Failed to create server", err)
+			return
+		}
+		slog.Debug("Created server", "serverID", hs.ID, "ip", pip.IP)
+
+		server := &common.Server{
+			Provider:     providerName,
+			PlacementID:  placementID,
+			LocationID:   locationID,
+			InstanceID:   strconv.Itoa(hs.ID),
+			InstanceName: name,
+			OS:           recipe.Target,
+			Config:       common.ServerConfiguration{ExternalIp: pip.IP, ExternalIpv6: hs.PublicIPv6()},
+		}
+		if options.StaticIP {
+			server.StaticIPName = strconv.Itoa(pip.ID)
+		}
+		// Recorded before it is installed, so a failed installation can be resumed
+		if err := p.recordServer(server, "root", privateSSHKey, common.ServerStatusInstalling, options.Profile); err != nil {
+			slog.Error("Failed to record server", "id", server.ID, "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err, Server: server}
+			return
+		}
+		p.installServer(ctx, client, server, privateSSHKey, "root", options.InstallProfile())
+	}()
+}
+
+// ResumeInstall implements common.InstallResumer.
+func (p *Provisioner) ResumeInstall(ctx context.Context, id string) {
+	go func() {
+		p.session.Events <- common.Event{Type: common.EventTypeProvisioningStarted, Message: id}
+		record, client, err := p.loadServer(ctx, id)
+		if err == nil && record.Status != common.ServerStatusInstalling {
+			err = fmt.Errorf("server %s is %s, not installing", id, record.Status)
+		}
+		if err != nil {
+			slog.Error("Failed to load server", "id", id, "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err}
+			return
+		}
+		server := record.Server
+		privateSSHKey, err := common.LoadSSHKey(p.secrets, id)
+		if err != nil {
+			slog.Error("Failed to load SSH key", "id", id, "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err, Server: &server}
+			return
+		}
+		p.installServer(ctx, client, &server, privateSSHKey, record.SSHUser, record.InstallProfile())
+	}()
+}
+
+// installServer installs a recorded server, opens its ports in the project's firewall and verifies it.
+// The result is reported with EventTypeProvisioningCompleted or EventTypeProvisioningError; the server
+// stays installing in the inventory on failure.
+func (p *Provisioner) installServer(ctx context.Context, client *APIClient, server *common.Server, privateSSHKey, sshUser string, profile common.InstallProfile) {
+	fail := func(msg string, err error) {
+		slog.Error(msg, "id", server.ID, "err", err)
+		p.session.Events <- common.Event{Type: common.EventTypeProvisioningError, Error: err, Server: server}
+	}
+	conf, err := common.InstallServer(ctx, server.Config.ExternalIp, privateSSHKey, sshUser, server.OS, profile, p.log)
+	if err != nil {
+		fail("Failed to install server", err)
+		return
+	}
+	if _, err := CreateFirewallIfNeeded(ctx, client, conf.FirewallPorts()); err != nil {
+		fail("Failed to update firewall", err)
+		return
+	}
+	conf.ExternalIp = server.Config.ExternalIp
+	conf.ExternalIpv6 = server.Config.ExternalIpv6
+	p.progress("Verifying the server")
+	if err := common.VerifyServer(ctx, *conf, privateSSHKey, sshUser); err != nil {
+		fail("Server verification failed", err)
+		return
+	}
+	server.Config = *conf
+	p.updateServer(*server, common.ServerStatusActive)
+	p.session.Events <- common.Event{
+		Type:    common.EventTypeProvisioningCompleted,
+		Message: conf.Encode(),
+		Server:  server,
+	}
+}
+
+// RotateIP implements common.IPRotator. The server is powered off while its address is changed.
+func (p *Provisioner) RotateIP(ctx context.Context, server common.Server) {
+	go func() {
+		p.session.Events <- common.Event{Type: common.EventTypeIPRotationStarted, Message: server.ID, Server: &server}
+		prj, err := p.project(server.PlacementID)
+		if err != nil {
+			slog.Error("Failed to get project", "err", err)
+			p.session.Events <- common.Event{Type: common.EventTypeIPRotationError, Message: server.ID, Error: err, Server: &server}
+			return
+		}
+		p.rotateIP(ctx, prj.client, server)
+	}()
+}
+
+// rotateIP rotates the server's IP, updates the inventory and reports the result.
+func (p *Provisioner) rotateIP(ctx context.Context, client *APIClient, server common.Server) {
+	rotated, err := RotatePrimaryIP(ctx, client, server, p.progress)
+	if err != nil {
+		slog.Error("Failed to rotate IP", "id", server.ID, "instance", server.InstanceName, "err", err)
+		p.session.Events <- common.Event{Type: common.EventTypeIPRotationError, Message: server.ID, Error: err, Server: &server}
+		return
+	}
+	slog.Debug("Rotated IP", "instance", server.InstanceName, "ip", rotated.Config.ExternalIp)
+	p.updateServer(*rotated, common.ServerStatusActive)
+	p.session.Events <- common.Event{
+		Type:    common.EventTypeIPRotationCompleted,
+		Message: rotated.Config.Encode(),
+		Server:  rotated,
+	}
+}
+
+// progress reports a progress message on the session.
+func (p *Provisioner) progress(message string) {
+	slog.Debug("Progress", "message", message)
+	p.session.Events <- common.Event{Type: common.EventTypeProgress, Message: message}
+}
+
+// log reports a line of remote command output on the session.
+func (p *Provisioner) log(line string) {
+	p.session.Events <- common.Event{Type: common.EventTypeLog, Message: line}
+}
@@ -0,0 +1,87 @@
+package hetzner
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+// RotatePrimaryIP moves a server to a new primary IPv4 address and deletes the old one.
+// Hetzner only changes the addresses of servers that are powered off, so the server is powered off
+// for the move and started again; the installed Lantern server keeps its state. If the assignment
+// fails, the old address is assigned back. The new address is kept when the server is deleted if
+// the old one was, see common.WithStaticIP. It returns the updated server.
+func RotatePrimaryIP(ctx context.Context, client *APIClient, server common.Server, progress common.ProgressFunc) (*common.Server, error) {
+	if progress == nil {
+		progress = func(string) {}
+	}
+	serverID, err := strconv.Atoi(server.InstanceID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server ID %q: %w", server.InstanceID, err)
+	}
+	hs, err := client.GetServer(ctx, serverID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get server %d: %w", serverID, err)
+	}
+
+	progress("Creating a new IP address")
+	name := fmt.Sprintf("%s-%s", server.InstanceName, common.NewServerID()[:6])
+	pip, err := client.CreatePrimaryIP(ctx, name, hs.Datacenter.Name, server.StaticIPName == "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create primary IP: %w", err)
+	}
+	slog.Debug("Created new primary IP", "ip", pip.IP)
+
+	progress(fmt.Sprintf("Powering off server %s", server.InstanceName))
+	if err := client.PowerOffServer(ctx, serverID); err != nil {
+		releasePrimaryIP(ctx, client, pip.ID)
+		return nil, fmt.Errorf("failed to power off server %d: %w", serverID, err)
+	}
+	restart := func() {
+		if err := client.PowerOnServer(ctx, serverID); err != nil {
+			slog.Error("Failed to power on server", "id", serverID, "err", err)
+		}
+	}
+
+	progress(fmt.Sprintf("Moving server %s to %s", server.InstanceName, pip.IP))
+	oldID := 0
+	if hs.PublicNet.IPv4 != nil {
+		// A server can only have one primary IPv4 address
+		oldID = hs.PublicNet.IPv4.ID
+		if err := client.UnassignPrimaryIP(ctx, oldID); err != nil {
+			restart()
+			releasePrimaryIP(ctx, client, pip.ID)
+			return nil, fmt.Errorf("failed to unassign primary IP %d: %w", oldID, err)
+		}
+	}
+	if err := client.AssignPrimaryIP(ctx, pip.ID, serverID); err != nil {
+		if oldID != 0 {
+			if restoreErr := client.AssignPrimaryIP(ctx, oldID, serverID); restoreErr != nil {
+				slog.Error("Failed to restore the previous primary IP", "id", oldID, "err", restoreErr)
+			}
+		}
+		restart()
+		releasePrimaryIP(ctx, client, pip.ID)
+		return nil, fmt.Errorf("failed to assign primary IP %s to server %d: %w", pip.IP, serverID, err)
+	}
+
+	progress(fmt.Sprintf("Starting server %s", server.InstanceName))
+	if err := client.PowerOnServer(ctx, serverID); err != nil {
+		return nil, fmt.Errorf("failed to power on server %d: %w", serverID, err)
+	}
+	if oldID != 0 {
+		progress("Releasing the old IP address")
+		// An unassigned address isn't deleted automatically, even with auto_delete
+		releasePrimaryIP(ctx, client, oldID)
+	}
+
+	rotated := server
+	if server.StaticIPName != "" {
+		rotated.StaticIPName = strconv.Itoa(pip.ID)
+	}
+	rotated.Config.ExternalIp = pip.IP
+	return &rotated, nil
+}
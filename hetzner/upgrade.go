@@ -0,0 +1,36 @@
+package hetzner
+
+import (
+	"context"
+
+	"github.com/getlantern/lantern-server-provisioner/common"
+)
+
+// UpgradeServer implements common.Upgrader. If the server's ports changed, the firewall is updated to match.
+func (p *Provisioner) UpgradeServer(ctx context.Context, id string) {
+	p.maintainer().UpgradeServer(ctx, id)
+}
+
+// maintainer returns the maintenance operations for the provisioner's servers.
+func (p *Provisioner) maintainer() *common.Maintainer {
+	return &common.Maintainer{
+		Provider:  providerName,
+		Events:    p.session.Events,
+		Inventory: p.inventory,
+		Secrets:   p.secrets,
+		Progress:  p.progress,
+		Log:       p.log,
+		LoadServer: func(ctx context.Context, id string) (*common.InventoryRecord, error) {
+			record, _, err := p.loadServer(ctx, id)
+			return record, err
+		},
+		UpdateFirewall: func(ctx context.Context, record common.InventoryRecord, ports []common.PortRange) error {
+			prj, err := p.project(record.Server.PlacementID)
+			if err != nil {
+				return err
+			}
+			_, err = CreateFirewallIfNeeded(ctx, prj.client, ports)
+			return err
+		},
+	}
+}